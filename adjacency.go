@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// boundaryPostcodeProperties are the feature properties tried, in order, for a
+// boundary's postcode when -property isn't given. The POA_CODE names are those of
+// the ABS Postal Areas files.
+var boundaryPostcodeProperties = []string{"postcode", "POA_CODE21", "POA_CODE16", "POA_CODE"}
+
+// adjacencyGraph lists, for each postcode, the postcodes whose areas border it.
+type adjacencyGraph map[string][]string
+
+// activeAdjacency is the loaded graph, or nil when -adjacency is not set.
+var activeAdjacency adjacencyGraph
+
+// geoJSONFeatures is the part of a GeoJSON FeatureCollection the boundaries need.
+type geoJSONFeatures struct {
+	Features []struct {
+		Properties map[string]any `json:"properties"`
+		Geometry   struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"features"`
+}
+
+// vertex is a boundary point rounded to about a centimetre, so the same point
+// written by two polygons compares equal despite floating-point noise.
+type vertex [2]int64
+
+func roundVertex(point []float64) vertex {
+	return vertex{int64(math.Round(point[0] * 1e7)), int64(math.Round(point[1] * 1e7))}
+}
+
+// polygonRings returns the rings of a Polygon or MultiPolygon geometry.
+func polygonRings(kind string, coordinates json.RawMessage) ([][][]float64, error) {
+	switch kind {
+	case "Polygon":
+		var rings [][][]float64
+		err := json.Unmarshal(coordinates, &rings)
+		return rings, err
+	case "MultiPolygon":
+		var polygons [][][][]float64
+		if err := json.Unmarshal(coordinates, &polygons); err != nil {
+			return nil, err
+		}
+		var rings [][][]float64
+		for _, polygon := range polygons {
+			rings = append(rings, polygon...)
+		}
+		return rings, nil
+	default:
+		return nil, nil
+	}
+}
+
+// buildAdjacency reads postcode boundary polygons from GeoJSON and links postcodes
+// whose boundaries share at least one edge; touching at a single corner doesn't
+// count. Edges must share both end points, which holds for boundary files cut
+// from one topology such as the ABS Postal Areas.
+func buildAdjacency(r io.Reader, property string) (adjacencyGraph, error) {
+	var collection geoJSONFeatures
+	if err := json.NewDecoder(r).Decode(&collection); err != nil {
+		return nil, fmt.Errorf("decode boundaries: %w", err)
+	}
+
+	properties := boundaryPostcodeProperties
+	if property != "" {
+		properties = []string{property}
+	}
+
+	edges := map[[2]vertex]string{} // edge -> first postcode seen using it
+	neighbours := map[string]map[string]bool{}
+	for i, feature := range collection.Features {
+		postcode := ""
+		for _, name := range properties {
+			if value, ok := feature.Properties[name]; ok {
+				postcode = strings.TrimSpace(fmt.Sprint(value))
+				break
+			}
+		}
+		if postcode == "" {
+			return nil, fmt.Errorf("feature %d has no %s property", i, strings.Join(properties, " or "))
+		}
+		if neighbours[postcode] == nil {
+			neighbours[postcode] = map[string]bool{}
+		}
+
+		rings, err := polygonRings(feature.Geometry.Type, feature.Geometry.Coordinates)
+		if err != nil {
+			return nil, fmt.Errorf("feature %d (%s): %w", i, postcode, err)
+		}
+		for _, ring := range rings {
+			for j := 1; j < len(ring); j++ {
+				if len(ring[j-1]) < 2 || len(ring[j]) < 2 {
+					continue
+				}
+				a, b := roundVertex(ring[j-1]), roundVertex(ring[j])
+				if a == b {
+					continue
+				}
+				// An edge is the same whichever polygon walks it, and in which direction.
+				if b[0] < a[0] || (b[0] == a[0] && b[1] < a[1]) {
+					a, b = b, a
+				}
+				other, seen := edges[[2]vertex{a, b}]
+				if !seen {
+					edges[[2]vertex{a, b}] = postcode
+				} else if other != postcode {
+					neighbours[postcode][other] = true
+					neighbours[other][postcode] = true
+				}
+			}
+		}
+	}
+
+	graph := adjacencyGraph{}
+	for postcode, adjacent := range neighbours {
+		graph[postcode] = []string{}
+		for other := range adjacent {
+			graph[postcode] = append(graph[postcode], other)
+		}
+		slices.Sort(graph[postcode])
+	}
+	return graph, nil
+}
+
+// writeCSV writes the graph as "postcode,adjacent" rows, one per neighbour.
+// Postcodes without neighbours, such as islands, get a row with no neighbour so
+// they are still known.
+func (g adjacencyGraph) writeCSV(w io.Writer) error {
+	postcodes := make([]string, 0, len(g))
+	for postcode := range g {
+		postcodes = append(postcodes, postcode)
+	}
+	sort.Strings(postcodes)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"postcode", "adjacent"})
+	for _, postcode := range postcodes {
+		if len(g[postcode]) == 0 {
+			out.Write([]string{postcode, ""})
+		}
+		for _, other := range g[postcode] {
+			out.Write([]string{postcode, other})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// loadAdjacency reads a graph written by the adjacency command.
+func loadAdjacency(path string) (adjacencyGraph, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open adjacency: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	if _, err := reader.Read(); err != nil {
+		return nil, fmt.Errorf("read adjacency header: %w", err)
+	}
+
+	graph := adjacencyGraph{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read adjacency: %w", err)
+		}
+		postcode, other := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if graph[postcode] == nil {
+			graph[postcode] = []string{}
+		}
+		if other != "" {
+			graph[postcode] = append(graph[postcode], other)
+		}
+	}
+	return graph, nil
+}
+
+// runAdjacency implements the "adjacency" subcommand, precomputing the postcode
+// adjacency graph from boundary polygons so the server doesn't load them.
+func runAdjacency(args []string) error {
+	fs := flag.NewFlagSet("adjacency", flag.ExitOnError)
+	property := fs.String("property", "", "Feature property holding the postcode (default: postcode, POA_CODE21, POA_CODE16 or POA_CODE)")
+	output := fs.String("o", "", "File to write the adjacency CSV to (default: standard output)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: adjacency [-property NAME] [-o FILE] BOUNDARIES.geojson")
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	graph, err := buildAdjacency(f, *property)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return graph.writeCSV(os.Stdout)
+	}
+	out, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := graph.writeCSV(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// PostcodeAdjacency is the response of GET /postcode/{code}/adjacent.
+type PostcodeAdjacency struct {
+	Postcode string   `json:"postcode"`
+	Adjacent []string `json:"adjacent"`
+}
+
+// adjacentHandler handles GET /postcode/{code}/adjacent, listing the postcodes
+// whose areas border the given one.
+func adjacentHandler(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimSpace(r.PathValue("code"))
+	if activeAdjacency == nil {
+		writeError(w, http.StatusServiceUnavailable, codeDatasetUnavailable, "This endpoint requires postcode adjacency data; start the server with -adjacency")
+		return
+	}
+	adjacent, ok := activeAdjacency[code]
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Postcode %s has no boundary data", code))
+		return
+	}
+	writeJSON(w, http.StatusOK, PostcodeAdjacency{Postcode: code, Adjacent: adjacent})
+}
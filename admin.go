@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// datasetReloader re-reads the dataset from the source it was started with; it is
+// configured in main and nil when the server has no reloadable dataset. It returns
+// a nil dataset when the source is unchanged.
+var datasetReloader func(ctx context.Context) (*Dataset, string, error)
+
+func init() {
+	// Surface the dataset size next to the runtime's memstats on /debug/vars.
+	expvar.Publish("dataset_rows", expvar.Func(func() any {
+		if dataset := currentDataset(); dataset != nil {
+			return len(dataset.Rows)
+		}
+		return 0
+	}))
+}
+
+// newAdminMux returns the handler for operational endpoints. These are served on a
+// separate listener so they are never exposed on the public API port.
+func newAdminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// Runtime profiling: go tool pprof http://<admin-addr>/debug/pprof/heap
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	// Memstats, command line and published counters as JSON.
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	// The same counters in the Prometheus and OpenMetrics text formats.
+	mux.HandleFunc("GET /metrics", metricsHandler)
+
+	// The same health check as the public port, for probes on the private network.
+	mux.HandleFunc("GET /healthz", healthHandler)
+
+	// The raw upstream page for a search and what the parser made of it.
+	mux.HandleFunc("GET /debug/scrape", debugScrapeHandler)
+
+	// Administrative actions, each recorded in the audit log.
+	mux.HandleFunc("POST /admin/cache/flush", rejectWhenReadOnly(cacheFlushHandler))
+	mux.HandleFunc("POST /admin/dataset/reload", datasetReloadHandler)
+	mux.Handle("POST /admin/keys", withBodyLimit(rejectWhenReadOnly(createKeyHandler)))
+	mux.HandleFunc("POST /admin/drain", drainHandler)
+
+	// Runtime log level, e.g. debug logging of scrapes for a while.
+	mux.HandleFunc("GET /admin/log-level", logLevelHandler)
+	mux.Handle("PUT /admin/log-level", withBodyLimit(http.HandlerFunc(logLevelHandler)))
+
+	// Search history, when -search-history is on.
+	mux.HandleFunc("GET /admin/history/export", historyExportHandler)
+	mux.HandleFunc("GET /admin/history/failed", failedQueriesHandler)
+
+	return mux
+}
+
+// adminToken, when set from $ADMIN_TOKEN, must be sent as a bearer token on every
+// admin listener request except /healthz.
+var adminToken string
+
+// withAdminToken rejects admin requests without the admin token. With no token
+// configured the admin listener relies on being bound privately.
+func withAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" || r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := bearerToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, codeAdminTokenInvalid, "Admin endpoints require the admin bearer token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminContextKey{}, true)))
+	})
+}
+
+type adminContextKey struct{}
+
+// adminAuthenticated reports whether r presented the admin token.
+func adminAuthenticated(r *http.Request) bool {
+	authenticated, _ := r.Context().Value(adminContextKey{}).(bool)
+	return authenticated
+}
+
+// sameListenAddr reports whether two -listen style addresses could be the same
+// socket: the same Unix socket path, or TCP addresses with the same port. Hosts
+// aren't compared, since ":8080" covers "localhost:8080".
+func sameListenAddr(a, b string) bool {
+	if strings.HasPrefix(a, "unix:") || strings.HasPrefix(b, "unix:") {
+		return a == b
+	}
+	_, portA, errA := net.SplitHostPort(a)
+	_, portB, errB := net.SplitHostPort(b)
+	return errA == nil && errB == nil && portA == portB
+}
+
+// serveAdmin runs the admin listener. A failure is logged rather than fatal, so a
+// port clash on the admin address never takes the public API down. The address
+// takes the same forms as -listen, and is opened with SO_REUSEPORT alongside the
+// public port so a restarting process can bind it too.
+func serveAdmin(addr string, reusePort bool) {
+	listener, err := openListener(addr, reusePort)
+	if err != nil {
+		log.Printf("Warning: admin server failed to start: %v", err)
+		return
+	}
+	log.Printf("Starting admin server on %s", listener.Addr())
+	server := &http.Server{
+		Handler:           withRequestID(withAdminToken(newAdminMux())),
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	if err := server.Serve(listener); err != nil {
+		log.Printf("Warning: admin server stopped: %v", err)
+	}
+}
+
+// debugScrapeHandler handles GET /debug/scrape?keyword=..., fetching the upstream
+// search page and returning its HTML with the selector used and the rows and
+// warnings the parser produced. It bypasses the result cache, so it shows what the
+// parser does with the page now; the on-disk page cache still applies, and its
+// X-Cache verdict is included. The fetch draws on the scrape budget, waiting for
+// the next window when it is spent.
+func debugScrapeHandler(w http.ResponseWriter, r *http.Request) {
+	keyword := r.URL.Query().Get("keyword")
+	if keyword == "" {
+		writeError(w, http.StatusBadRequest, codeKeywordMissing, "Missing 'keyword' parameter in the query string. Example: /debug/scrape?keyword=sydney")
+		return
+	}
+
+	// A debug fetch is still an upstream scrape. Operators would rather wait for
+	// the next window than be turned away mid-diagnosis.
+	if err := waitForBudget(r.Context()); err != nil {
+		writeError(w, http.StatusServiceUnavailable, codeRequestTimeout, fmt.Sprintf("Gave up waiting for the scrape budget: %s", err))
+		return
+	}
+
+	targetURL := searchURL(keyword)
+	resp, body, err := fetchPage(r.Context(), targetURL)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, upstreamErrorCode(err), err.Error())
+		return
+	}
+
+	report := map[string]any{
+		"url":         targetURL,
+		"status":      resp.StatusCode,
+		"cache":       resp.Header.Get("X-Cache"),
+		"selector":    postcodeTableSelector,
+		"table_found": false,
+		"rows":        []PostcodeResult{},
+		"warnings":    []ParseWarning{},
+		"html":        string(body),
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		report["warnings"] = []ParseWarning{{Message: fmt.Sprintf("Failed to parse HTML: %s", err)}}
+	} else {
+		rows, warnings, parse := parseResultsTable(doc)
+		report["rows"], report["warnings"], report["table_found"] = rows, warnings, parse.Selector != ""
+		if parse.Selector != "" {
+			report["selector"] = parse.Selector
+		}
+		report["rows_skipped"] = parse.Skipped
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// cacheFlushHandler handles POST /admin/cache/flush, emptying the scrape result caches
+// so the next lookups are fetched from the upstream again.
+func cacheFlushHandler(w http.ResponseWriter, r *http.Request) {
+	caches := []struct {
+		bucket string
+		flush  func() (int, error)
+	}{
+		{resultsBucket, scrapeCache.flush},
+		{nearbyBucket, nearbyCache.flush},
+	}
+
+	flushed := map[string]int{}
+	for _, cache := range caches {
+		n, err := cache.flush()
+		flushed[cache.bucket] = n
+		if err != nil {
+			bucket := cache.bucket
+			auditTrail.recordRequest(r, "cache.flush", map[string]any{"flushed": flushed, "error": err.Error()})
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to flush the %s cache: %s", bucket, err))
+			return
+		}
+	}
+	renderedResponses.invalidate()
+	invalidations.publish(invalidateCaches, "")
+	auditTrail.recordRequest(r, "cache.flush", map[string]any{"flushed": flushed})
+	writeJSON(w, http.StatusOK, map[string]any{"flushed": flushed})
+}
+
+// datasetReloadHandler handles POST /admin/dataset/reload, re-reading the dataset
+// from its file, URL or snapshot without a restart.
+func datasetReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if datasetReloader == nil {
+		writeError(w, http.StatusServiceUnavailable, codeDatasetUnavailable, "The server was not started with a reloadable dataset (-dataset, -dataset-url or -snapshot)")
+		return
+	}
+	dataset, source, err := datasetReloader(r.Context())
+	if err != nil {
+		auditTrail.recordRequest(r, "dataset.reload", map[string]any{"source": source, "error": err.Error()})
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to reload the dataset: %s", err))
+		return
+	}
+	if dataset == nil {
+		auditTrail.recordRequest(r, "dataset.reload", map[string]any{"source": source, "unchanged": true})
+		writeJSON(w, http.StatusOK, map[string]any{"source": source, "unchanged": true})
+		return
+	}
+	replaceDataset(dataset, "admin", auditActor(r))
+	invalidations.publish(invalidateDataset, "")
+	writeJSON(w, http.StatusOK, map[string]any{"source": source, "rows": len(dataset.Rows)})
+}
+
+// createKeyRequest is the body of POST /admin/keys.
+type createKeyRequest struct {
+	Name         string `json:"name"`
+	DailyQuota   int64  `json:"daily_quota"`
+	MonthlyQuota int64  `json:"monthly_quota"`
+}
+
+// createKeyHandler handles POST /admin/keys, issuing a new API key. The key is only
+// ever shown in this response; the server keeps just its hash in memory.
+func createKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req createKeyRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || req.DailyQuota < 0 || req.MonthlyQuota < 0 {
+		writeError(w, http.StatusBadRequest, codeBodyInvalid, "A 'name' is required and quotas must not be negative")
+		return
+	}
+
+	key, err := createAPIKey(apiKey{Name: req.Name, Daily: req.DailyQuota, Monthly: req.MonthlyQuota})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+	auditTrail.recordRequest(r, "apikey.create", map[string]any{
+		"name":          req.Name,
+		"daily_quota":   req.DailyQuota,
+		"monthly_quota": req.MonthlyQuota,
+	})
+	writeJSON(w, http.StatusCreated, map[string]any{"name": req.Name, "key": key})
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcomes of a single upstream scrape, as counted by the alert monitor.
+const (
+	scrapeOK           = iota
+	scrapeFailed       // the request failed or the upstream returned an error status
+	scrapeParseFailure // the page was fetched but the results table was not found
+)
+
+// alertMonitor watches the outcome of recent scrapes and notifies the configured
+// destinations when the error or parse-failure rate over the window crosses its
+// threshold, and again once it recovers.
+type alertMonitor struct {
+	window           time.Duration
+	minScrapes       int     // rates over fewer scrapes than this never alert
+	errorRate        float64 // 0 disables the error-rate alert
+	parseFailureRate float64 // 0 disables the parse-failure alert
+	destinations     []alertDestination
+	now              func() time.Time
+
+	mu     sync.Mutex
+	events []scrapeEvent
+	firing map[string]bool // alert name -> currently firing
+}
+
+type scrapeEvent struct {
+	at      time.Time
+	outcome int
+}
+
+// alertDestination delivers an alert message, e.g. to Slack or by email.
+type alertDestination interface {
+	send(subject, body string) error
+}
+
+// scrapeAlerts is the configured monitor, or nil when no destination is set.
+var scrapeAlerts *alertMonitor
+
+// newAlertMonitor returns a monitor for the given destinations, or nil if there are none.
+func newAlertMonitor(window time.Duration, minScrapes int, errorRate, parseFailureRate float64, destinations []alertDestination) *alertMonitor {
+	if len(destinations) == 0 {
+		return nil
+	}
+	return &alertMonitor{
+		window:           window,
+		minScrapes:       max(minScrapes, 1),
+		errorRate:        errorRate,
+		parseFailureRate: parseFailureRate,
+		destinations:     destinations,
+		now:              time.Now,
+		firing:           map[string]bool{},
+	}
+}
+
+// record counts one scrape outcome and fires or resolves alerts as the rates change.
+// It is safe to call on a nil monitor.
+func (m *alertMonitor) record(outcome int) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	now := m.now()
+	m.events = append(m.events, scrapeEvent{at: now, outcome: outcome})
+	cutoff := now.Add(-m.window)
+	drop := 0
+	for drop < len(m.events) && m.events[drop].at.Before(cutoff) {
+		drop++
+	}
+	m.events = m.events[drop:]
+
+	total := len(m.events)
+	counts := map[int]int{}
+	for _, event := range m.events {
+		counts[event.outcome]++
+	}
+
+	var pending [][2]string
+	check := func(name string, threshold float64, count int) {
+		if threshold <= 0 || total < m.minScrapes {
+			return
+		}
+		rate := float64(count) / float64(total)
+		switch {
+		case rate >= threshold && !m.firing[name]:
+			m.firing[name] = true
+			pending = append(pending, [2]string{
+				fmt.Sprintf("[postcode_scraper] %s rate is %.0f%%", name, rate*100),
+				fmt.Sprintf("%d of the last %d upstream scrapes (over %s) ended in a %s, crossing the %.0f%% threshold. The upstream site may be down or its markup may have changed.",
+					count, total, m.window, name, threshold*100),
+			})
+		case rate < threshold && m.firing[name]:
+			m.firing[name] = false
+			pending = append(pending, [2]string{
+				fmt.Sprintf("[postcode_scraper] %s rate recovered", name),
+				fmt.Sprintf("%d of the last %d upstream scrapes ended in a %s, back under the %.0f%% threshold.", count, total, name, threshold*100),
+			})
+		}
+	}
+	check("scrape error", m.errorRate, counts[scrapeFailed])
+	check("parse failure", m.parseFailureRate, counts[scrapeParseFailure])
+	m.mu.Unlock()
+
+	for _, alert := range pending {
+		go m.notify(alert[0], alert[1])
+	}
+}
+
+// notify sends an alert to every destination, logging any that fail.
+func (m *alertMonitor) notify(subject, body string) {
+	log.Printf("Alert: %s", subject)
+	for _, destination := range m.destinations {
+		if err := destination.send(subject, body); err != nil {
+			log.Printf("Warning: failed to deliver alert %q: %v", subject, err)
+		}
+	}
+}
+
+// slackDestination posts alerts to a Slack incoming webhook.
+type slackDestination struct {
+	webhookURL string
+	client     *http.Client
+}
+
+func (s slackDestination) send(subject, body string) error {
+	payload, err := json.Marshal(map[string]string{"text": "*" + subject + "*\n" + body})
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Slack webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// emailDestination sends alerts through an SMTP relay.
+type emailDestination struct {
+	addr     string // host:port of the SMTP server
+	from     string
+	to       []string
+	username string
+	password string
+}
+
+func (e emailDestination) send(subject, body string) error {
+	var auth smtp.Auth
+	if e.username != "" {
+		host, _, _ := net.SplitHostPort(e.addr)
+		auth = smtp.PlainAuth("", e.username, e.password, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		e.from, strings.Join(e.to, ", "), subject, body)
+	return smtp.SendMail(e.addr, auth, e.from, e.to, []byte(msg))
+}
+
+// newAlertDestinations builds the destinations configured by the -alert-slack-webhook
+// and -alert-email flags. Email needs SMTP_ADDR, and reads ALERT_EMAIL_FROM,
+// SMTP_USERNAME and SMTP_PASSWORD from the environment.
+func newAlertDestinations(slackWebhook, emailSpec string) ([]alertDestination, error) {
+	var destinations []alertDestination
+	if slackWebhook != "" {
+		destinations = append(destinations, slackDestination{webhookURL: slackWebhook, client: &http.Client{Timeout: 10 * time.Second}})
+	}
+
+	var to []string
+	for _, addr := range strings.Split(emailSpec, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	if len(to) > 0 {
+		email := emailDestination{
+			addr:     os.Getenv("SMTP_ADDR"),
+			from:     os.Getenv("ALERT_EMAIL_FROM"),
+			to:       to,
+			username: os.Getenv("SMTP_USERNAME"),
+			password: os.Getenv("SMTP_PASSWORD"),
+		}
+		if email.addr == "" {
+			return nil, fmt.Errorf("-alert-email requires SMTP_ADDR (host:port) to be set")
+		}
+		if email.from == "" {
+			email.from = "postcode-scraper@localhost"
+		}
+		destinations = append(destinations, email)
+	}
+	return destinations, nil
+}
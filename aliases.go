@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Alias maps a former or alternative suburb name to the current official locality.
+type Alias struct {
+	Name   string `json:"alias"`  // e.g. a former suburb name or common alternative spelling
+	Suburb string `json:"suburb"` // the official locality it refers to
+	State  string `json:"state"`
+}
+
+// aliasTable is the set of known aliases, indexed by alias name.
+type aliasTable struct {
+	aliases []Alias
+	index   *searchIndex
+}
+
+// activeAliases holds the loaded alias table, or nil when none is configured.
+var activeAliases atomic.Pointer[aliasTable]
+
+// currentAliases returns the loaded alias table, or nil.
+func currentAliases() *aliasTable {
+	return activeAliases.Load()
+}
+
+// loadAliases reads an alias CSV file with "alias", "suburb" and "state" columns.
+func loadAliases(path string) (*aliasTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open aliases: %w", err)
+	}
+	defer f.Close()
+
+	return readAliases(f)
+}
+
+// readAliases parses alias CSV data.
+func readAliases(r io.Reader) (*aliasTable, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read aliases header: %w", err)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"alias", "suburb", "state"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("aliases header is missing a %s column", required)
+		}
+	}
+
+	cell := func(record []string, column string) string {
+		if i := index[column]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	aliases := []Alias{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read aliases: %w", err)
+		}
+		alias := Alias{Name: cell(record, "alias"), Suburb: cell(record, "suburb"), State: cell(record, "state")}
+		if alias.Name == "" || alias.Suburb == "" {
+			continue
+		}
+		aliases = append(aliases, alias)
+	}
+
+	return newAliasTable(aliases), nil
+}
+
+// newAliasTable indexes aliases by name so they can be searched like suburbs.
+func newAliasTable(aliases []Alias) *aliasTable {
+	rows := make([]PostcodeResult, len(aliases))
+	for i, alias := range aliases {
+		rows[i] = PostcodeResult{Suburb: alias.Name, State: alias.State}
+	}
+	return &aliasTable{aliases: aliases, index: buildSearchIndex(rows)}
+}
+
+// Search returns a row for every dataset entry whose official suburb has an alias
+// matching the keyword. Each row is named by the alias, with AliasOf set to the
+// official suburb, so lookups against older address data still resolve.
+func (t *aliasTable) Search(d *Dataset, keyword string) []PostcodeResult {
+	results := []PostcodeResult{}
+	if t == nil {
+		return results
+	}
+	for _, i := range t.index.lookup(keyword) {
+		alias := t.aliases[i]
+		for _, row := range d.RowsForSuburb(alias.Suburb, alias.State) {
+			row.AliasOf = row.Suburb
+			row.Suburb = alias.Name
+			results = append(results, row)
+		}
+	}
+	return results
+}
+
+// Resolve returns the official suburb an alias name refers to within a state, if any.
+// An empty state matches aliases in any state. Comparison is lenient.
+func (t *aliasTable) Resolve(name, state string) (Alias, bool) {
+	if t == nil {
+		return Alias{}, false
+	}
+	name = canonicalSuburb(name)
+	for _, alias := range t.aliases {
+		if canonicalSuburb(alias.Name) == name && (state == "" || strings.EqualFold(alias.State, state)) {
+			return alias, true
+		}
+	}
+	return Alias{}, false
+}
+
+// RowsForSuburb returns the rows for an official suburb name in a state (case-insensitive).
+func (d *Dataset) RowsForSuburb(suburb, state string) []PostcodeResult {
+	rows := []PostcodeResult{}
+	for _, row := range d.Rows {
+		if strings.EqualFold(row.Suburb, suburb) && strings.EqualFold(row.State, state) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// usageBucket is the store bucket holding per-key request counters.
+const usageBucket = "usage"
+
+// apiKey is a client allowed to call the API, with its request quotas. A quota of 0
+// means unlimited.
+type apiKey struct {
+	Name    string
+	Daily   int64
+	Monthly int64
+}
+
+// apiKeys maps the SHA-256 of each configured key to its client; nil disables
+// API key authentication. Keys are kept hashed so they never sit in memory or logs
+// in the clear once loaded.
+var apiKeys map[string]apiKey
+
+// apiKeysMu guards apiKeys, which grows when keys are created at runtime.
+var apiKeysMu sync.RWMutex
+
+// apiKeysPath is the file the keys were loaded from; created keys are appended to it.
+var apiKeysPath string
+
+// usageMeter counts requests per key; it is configured in main.
+var usageMeter *usageCounter
+
+// loadAPIKeys reads an API key CSV file with "name" and "key" columns, and optional
+// "daily_quota" and "monthly_quota" columns.
+func loadAPIKeys(path string) (map[string]apiKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open API keys: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read API keys header: %w", err)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"name", "key"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("API keys header is missing a %s column", required)
+		}
+	}
+
+	cell := func(record []string, column string) string {
+		i, ok := index[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	quota := func(record []string, column string) (int64, error) {
+		raw := cell(record, column)
+		if raw == "" {
+			return 0, nil
+		}
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid %s %q", column, raw)
+		}
+		return n, nil
+	}
+
+	keys := map[string]apiKey{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read API keys line %d: %w", line, err)
+		}
+
+		client := apiKey{Name: cell(record, "name")}
+		secret := cell(record, "key")
+		if client.Name == "" || secret == "" {
+			return nil, fmt.Errorf("API keys line %d: name and key are required", line)
+		}
+		if client.Daily, err = quota(record, "daily_quota"); err != nil {
+			return nil, fmt.Errorf("API keys line %d: %w", line, err)
+		}
+		if client.Monthly, err = quota(record, "monthly_quota"); err != nil {
+			return nil, fmt.Errorf("API keys line %d: %w", line, err)
+		}
+		keys[sha256Hex([]byte(secret))] = client
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("API keys file contains no keys")
+	}
+	return keys, nil
+}
+
+// createAPIKey generates a key for a new client, appends it to the keys file and
+// starts accepting it immediately. The key is returned in the clear only here.
+func createAPIKey(client apiKey) (string, error) {
+	apiKeysMu.Lock()
+	defer apiKeysMu.Unlock()
+
+	if apiKeys == nil {
+		return "", errors.New("API keys are not enabled; start the server with -api-keys")
+	}
+	for _, existing := range apiKeys {
+		if existing.Name == client.Name {
+			return "", fmt.Errorf("an API key named %q already exists", client.Name)
+		}
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(b)
+
+	// Write the columns in whatever order the file's header uses.
+	f, err := os.OpenFile(apiKeysPath, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		return "", fmt.Errorf("open API keys: %w", err)
+	}
+	defer f.Close()
+	header, err := csv.NewReader(f).Read()
+	if err != nil {
+		return "", fmt.Errorf("read API keys header: %w", err)
+	}
+	values := map[string]string{
+		"name":          client.Name,
+		"key":           secret,
+		"daily_quota":   strconv.FormatInt(client.Daily, 10),
+		"monthly_quota": strconv.FormatInt(client.Monthly, 10),
+	}
+	record := make([]string, len(header))
+	for i, column := range header {
+		record[i] = values[strings.ToLower(strings.TrimSpace(column))]
+	}
+	// Don't glue the new record onto a last line that lacks its newline.
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			f.Write([]byte("\n"))
+		}
+	}
+	out := csv.NewWriter(f)
+	out.Write(record)
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return "", fmt.Errorf("write API key: %w", err)
+	}
+
+	apiKeys[sha256Hex([]byte(secret))] = client
+	return secret, nil
+}
+
+// quotaPeriod is one window a key's requests are counted over.
+type quotaPeriod struct {
+	Name  string // "daily" or "monthly"
+	Limit int64
+	Start time.Time
+	Reset time.Time
+}
+
+// quotaPeriods returns the daily and monthly windows containing now, in UTC.
+func (k apiKey) quotaPeriods(now time.Time) []quotaPeriod {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return []quotaPeriod{
+		{Name: "daily", Limit: k.Daily, Start: day, Reset: day.AddDate(0, 0, 1)},
+		{Name: "monthly", Limit: k.Monthly, Start: month, Reset: month.AddDate(0, 1, 0)},
+	}
+}
+
+// usageCounter persists per-key request counts in the store, one counter per key
+// and quota period, so usage survives restarts when the store does.
+type usageCounter struct {
+	store Store
+	mu    sync.Mutex
+}
+
+func usageKey(name string, period quotaPeriod) string {
+	return name + "|" + period.Name + "|" + period.Start.Format(time.DateOnly)
+}
+
+func (u *usageCounter) read(key string) (int64, error) {
+	raw, ok, err := u.store.Get(usageBucket, key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	return strconv.ParseInt(string(raw), 10, 64)
+}
+
+// usage returns the number of requests counted in each of the key's periods.
+func (u *usageCounter) usage(client apiKey, periods []quotaPeriod) ([]int64, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	used := make([]int64, len(periods))
+	for i, period := range periods {
+		n, err := u.read(usageKey(client.Name, period))
+		if err != nil {
+			return nil, err
+		}
+		used[i] = n
+	}
+	return used, nil
+}
+
+// take counts one request against every period unless one of them is already at
+// its limit, in which case nothing is counted and ok is false. used reports the
+// counts after the request was (or would have been) taken.
+func (u *usageCounter) take(client apiKey, periods []quotaPeriod) (used []int64, ok bool, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	used = make([]int64, len(periods))
+	ok = true
+	for i, period := range periods {
+		n, err := u.read(usageKey(client.Name, period))
+		if err != nil {
+			return nil, false, err
+		}
+		used[i] = n
+		if period.Limit > 0 && n >= period.Limit {
+			ok = false
+		}
+	}
+	// A read-only server enforces the counts other instances keep, adding none.
+	if !ok || readOnly {
+		return used, ok, nil
+	}
+
+	for i, period := range periods {
+		used[i]++
+		if err := u.store.Put(usageBucket, usageKey(client.Name, period), []byte(strconv.FormatInt(used[i], 10))); err != nil {
+			return nil, false, err
+		}
+	}
+	return used, true, nil
+}
+
+// setRateLimitHeaders reports the most constrained limited period in the
+// X-RateLimit-* headers. Keys without quotas get no headers.
+func setRateLimitHeaders(w http.ResponseWriter, periods []quotaPeriod, used []int64) {
+	tightest := -1
+	for i, period := range periods {
+		if period.Limit == 0 {
+			continue
+		}
+		if tightest < 0 || period.Limit-used[i] < periods[tightest].Limit-used[tightest] {
+			tightest = i
+		}
+	}
+	if tightest < 0 {
+		return
+	}
+	period := periods[tightest]
+	w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(period.Limit, 10))
+	w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(max(period.Limit-used[tightest], 0), 10))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(period.Reset.Unix(), 10))
+}
+
+type apiKeyContextKey struct{}
+
+// requestAPIKey returns the client authenticated for r, if API keys are enabled.
+func requestAPIKey(r *http.Request) (apiKey, bool) {
+	client, ok := r.Context().Value(apiKeyContextKey{}).(apiKey)
+	return client, ok
+}
+
+// withAuth authenticates requests when API keys or JWT validation are configured.
+// A bearer token is verified as a JWT; otherwise a valid X-API-Key header is
+// required and the request is charged against the key's quotas. Counter failures
+// are logged and the request is let through, so a broken store never locks
+// clients out.
+func withAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := bearerToken(r); ok && jwtAuth != nil {
+			serveWithJWT(w, r, token, next)
+			return
+		}
+		apiKeysMu.RLock()
+		keysEnabled := apiKeys != nil
+		apiKeysMu.RUnlock()
+		if !keysEnabled {
+			if jwtAuth != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, codeTokenMissing, "Missing Authorization bearer token")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		secret := r.Header.Get("X-API-Key")
+		if secret == "" {
+			message := "Missing X-API-Key header"
+			if jwtAuth != nil {
+				message = "Missing X-API-Key header or Authorization bearer token"
+			}
+			writeError(w, http.StatusUnauthorized, codeAPIKeyMissing, message)
+			return
+		}
+		apiKeysMu.RLock()
+		client, ok := apiKeys[sha256Hex([]byte(secret))]
+		apiKeysMu.RUnlock()
+		if !ok {
+			writeError(w, http.StatusUnauthorized, codeAPIKeyInvalid, "The API key is not valid")
+			return
+		}
+
+		now := time.Now()
+		periods := client.quotaPeriods(now)
+		used, allowed, err := usageMeter.take(client, periods)
+		if err != nil {
+			logf(r.Context(), "Warning: usage accounting failed for '%s': %v", client.Name, err)
+		} else {
+			setRateLimitHeaders(w, periods, used)
+		}
+		if err == nil && !allowed {
+			for i, period := range periods {
+				if period.Limit > 0 && used[i] >= period.Limit {
+					w.Header().Set("Retry-After", strconv.Itoa(int(period.Reset.Sub(now).Seconds())+1))
+					writeError(w, http.StatusTooManyRequests, codeQuotaExceeded, fmt.Sprintf("The %s quota of %d requests for this API key is used up", period.Name, period.Limit))
+					return
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, client)))
+	})
+}
+
+// QuotaUsage is one period's entry in the GET /usage response.
+type QuotaUsage struct {
+	Limit     int64     `json:"limit"` // 0 means unlimited
+	Used      int64     `json:"used"`
+	Remaining *int64    `json:"remaining,omitempty"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+// KeyUsage is the response of GET /usage.
+type KeyUsage struct {
+	Name    string     `json:"name"`
+	Daily   QuotaUsage `json:"daily"`
+	Monthly QuotaUsage `json:"monthly"`
+}
+
+// usageHandler handles GET /usage, reporting the calling key's quotas and how much
+// of them it has used. The request itself is included in the counts.
+func usageHandler(w http.ResponseWriter, r *http.Request) {
+	client, ok := requestAPIKey(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, "Usage is only tracked for requests made with an API key")
+		return
+	}
+
+	periods := client.quotaPeriods(time.Now())
+	used, err := usageMeter.usage(client, periods)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to read usage: %s", err))
+		return
+	}
+
+	quotas := make([]QuotaUsage, len(periods))
+	for i, period := range periods {
+		quotas[i] = QuotaUsage{Limit: period.Limit, Used: used[i], ResetsAt: period.Reset}
+		if period.Limit > 0 {
+			remaining := max(period.Limit-used[i], 0)
+			quotas[i].Remaining = &remaining
+		}
+	}
+	writeJSON(w, http.StatusOK, KeyUsage{Name: client.Name, Daily: quotas[0], Monthly: quotas[1]})
+}
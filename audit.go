@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry is one administrative action in the audit log.
+type AuditEntry struct {
+	Time    time.Time      `json:"time"`
+	Actor   string         `json:"actor"`  // "system" for scheduled jobs, otherwise the authenticated caller
+	Action  string         `json:"action"` // e.g. "cache.flush", "dataset.reload", "apikey.create"
+	Details map[string]any `json:"details,omitempty"`
+	// ClaimedActor is the caller's X-Admin-Actor header. Nothing verifies it, so
+	// it is a note alongside Actor, never a replacement for it.
+	ClaimedActor string `json:"claimed_actor,omitempty"`
+}
+
+// auditLog appends administrative actions to a JSON-lines file. The file is only
+// ever opened for appending, so earlier entries are never rewritten.
+type auditLog struct {
+	path string
+
+	mu sync.Mutex
+}
+
+// auditTrail is the configured audit log, or nil when -audit-log is not set.
+var auditTrail *auditLog
+
+func newAuditLog(path string) *auditLog {
+	if path == "" {
+		return nil
+	}
+	return &auditLog{path: path}
+}
+
+// record appends an entry for action. Every action is also written to the server
+// log, so it is visible even when no audit file is configured. A failed write is
+// logged rather than failing the action it describes.
+func (a *auditLog) record(actor, action string, details map[string]any) {
+	a.write(AuditEntry{Time: time.Now().UTC(), Actor: actor, Action: action, Details: details})
+}
+
+// recordRequest is record for an action taken by request r, noting the actor the
+// caller claimed alongside the one it authenticated as.
+func (a *auditLog) recordRequest(r *http.Request, action string, details map[string]any) {
+	a.write(AuditEntry{
+		Time:         time.Now().UTC(),
+		Actor:        auditActor(r),
+		Action:       action,
+		Details:      details,
+		ClaimedActor: r.Header.Get("X-Admin-Actor"),
+	})
+}
+
+func (a *auditLog) write(entry AuditEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Warning: failed to encode audit entry %s: %v", entry.Action, err)
+		return
+	}
+	log.Printf("Audit: %s", line)
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+		if syncErr := f.Sync(); err == nil {
+			err = syncErr
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Printf("Warning: failed to write audit entry %s: %v", entry.Action, err)
+	}
+}
+
+// auditActor identifies who made a request from the credential it was
+// authenticated with: "admin-token@" and its address for the admin token, which
+// every operator shares, "api-key:" and the key's name, or the namespace of a
+// JWT's client. Requests made without one are known only by their address.
+func auditActor(r *http.Request) string {
+	if adminAuthenticated(r) {
+		return "admin-token@" + r.RemoteAddr
+	}
+	if client, ok := requestAPIKey(r); ok {
+		return "api-key:" + client.Name
+	}
+	if namespace := requestNamespace(r); namespace != "" {
+		return namespace
+	}
+	return r.RemoteAddr
+}
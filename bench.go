@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BenchReport is the output of the bench command. Latencies are in milliseconds,
+// and only cover successful requests.
+type BenchReport struct {
+	Target      string `json:"target"`
+	Requests    int    `json:"requests"`
+	Concurrency int    `json:"concurrency"`
+	// Errors counts requests that failed or got a non-2xx status.
+	Errors            int            `json:"errors"`
+	Statuses          map[string]int `json:"statuses,omitempty"` // server mode only
+	Seconds           float64        `json:"seconds"`
+	RequestsPerSecond float64        `json:"requests_per_second"`
+	Latency           LatencySummary `json:"latency_ms"`
+}
+
+// LatencySummary describes a latency distribution in milliseconds.
+type LatencySummary struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// summarizeLatencies sorts latencies and summarises them, using the nearest-rank
+// percentile.
+func summarizeLatencies(latencies []time.Duration) LatencySummary {
+	if len(latencies) == 0 {
+		return LatencySummary{}
+	}
+	slices.Sort(latencies)
+	ms := func(d time.Duration) float64 { return float64(d.Nanoseconds()) / 1e6 }
+	percentile := func(p float64) float64 {
+		rank := int(p/100*float64(len(latencies))+0.5) - 1
+		return ms(latencies[min(max(rank, 0), len(latencies)-1)])
+	}
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	return LatencySummary{
+		Mean: ms(total / time.Duration(len(latencies))),
+		P50:  percentile(50),
+		P90:  percentile(90),
+		P95:  percentile(95),
+		P99:  percentile(99),
+		Max:  ms(latencies[len(latencies)-1]),
+	}
+}
+
+// readKeywords reads one keyword per line, skipping blank lines and '#' comments.
+func readKeywords(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open keywords: %w", err)
+	}
+	defer f.Close()
+
+	var keywords []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			keywords = append(keywords, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read keywords: %w", err)
+	}
+	if len(keywords) == 0 {
+		return nil, fmt.Errorf("%s has no keywords", path)
+	}
+	return keywords, nil
+}
+
+// runBench implements the "bench" subcommand, replaying a keyword list against a
+// running server, or against a dataset in-process with -dataset, and reporting
+// latency percentiles. It fails when any request does, and with -max-p99 when
+// the p99 latency is higher, so it can gate a release.
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	target := fs.String("url", "http://localhost:8080", "Base URL of the server to send /search requests to")
+	datasetPath := fs.String("dataset", "", "Search this dataset CSV in-process instead of calling a server")
+	concurrency := fs.Int("c", 8, "Number of concurrent workers")
+	requests := fs.Int("n", 0, "Total requests, cycling through the keywords (default: each keyword once)")
+	apiKey := fs.String("api-key", "", "X-API-Key header sent to the server")
+	maxP99 := fs.Duration("max-p99", 0, "Fail when the p99 latency exceeds this (0 disables the check)")
+	fs.Parse(args)
+	if fs.NArg() != 1 || *concurrency < 1 || *requests < 0 {
+		return errors.New("usage: bench [-url URL | -dataset FILE] [-c WORKERS] [-n REQUESTS] [-api-key KEY] [-max-p99 DURATION] KEYWORDS")
+	}
+
+	keywords, err := readKeywords(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	total := *requests
+	if total == 0 {
+		total = len(keywords)
+	}
+
+	report := BenchReport{Target: *target, Requests: total, Concurrency: *concurrency}
+	var lookup func(keyword string) (status int, err error)
+	if *datasetPath != "" {
+		dataset, err := loadDataset(*datasetPath)
+		if err != nil {
+			return err
+		}
+		report.Target = *datasetPath
+		lookup = func(keyword string) (int, error) {
+			dataset.Search(keyword)
+			return 0, nil
+		}
+	} else {
+		report.Statuses = map[string]int{}
+		client := &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency},
+		}
+		base := strings.TrimSuffix(*target, "/") + "/search?keyword="
+		lookup = func(keyword string) (int, error) {
+			req, err := http.NewRequest(http.MethodGet, base+url.QueryEscape(keyword), nil)
+			if err != nil {
+				return 0, err
+			}
+			if *apiKey != "" {
+				req.Header.Set("X-API-Key", *apiKey)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return 0, err
+			}
+			defer resp.Body.Close()
+			_, err = io.Copy(io.Discard, resp.Body)
+			return resp.StatusCode, err
+		}
+	}
+
+	latencies := make([]time.Duration, total)
+	statuses := make([]int, total)
+	succeeded := make([]bool, total)
+	var failures atomic.Int64
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= total {
+					return
+				}
+				began := time.Now()
+				status, err := lookup(keywords[i%len(keywords)])
+				latencies[i] = time.Since(began)
+				statuses[i] = status
+				// Error responses are often much faster than real answers, so
+				// they'd flatter the latencies as well as hide a broken server.
+				if err != nil || (status != 0 && (status < 200 || status > 299)) {
+					failures.Add(1)
+				} else {
+					succeeded[i] = true
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report.Errors = int(failures.Load())
+	for _, status := range statuses {
+		if status != 0 {
+			report.Statuses[strconv.Itoa(status)]++
+		}
+	}
+	report.Seconds = elapsed.Seconds()
+	report.RequestsPerSecond = float64(total) / elapsed.Seconds()
+	var successful []time.Duration
+	for i, latency := range latencies {
+		if succeeded[i] {
+			successful = append(successful, latency)
+		}
+	}
+	report.Latency = summarizeLatencies(successful)
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "    ")
+	if err := out.Encode(report); err != nil {
+		return err
+	}
+	if report.Errors > 0 {
+		return fmt.Errorf("%d of %d requests failed or got a non-2xx status", report.Errors, total)
+	}
+	if *maxP99 > 0 && report.Latency.P99 > float64(maxP99.Nanoseconds())/1e6 {
+		return fmt.Errorf("p99 latency %.3fms exceeds -max-p99 %s", report.Latency.P99, *maxP99)
+	}
+	return nil
+}
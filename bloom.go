@@ -0,0 +1,112 @@
+package main
+
+import (
+	"hash/maphash"
+	"math"
+)
+
+// bloomFalsePositiveRate is the share of absent keys a dataset's filter lets
+// through to the index. Each key then costs about ten bits.
+const bloomFalsePositiveRate = 0.01
+
+// bloomFilter is a set that answers "definitely absent" or "possibly present".
+// It never reports a present key as absent, so a miss can be trusted outright.
+type bloomFilter struct {
+	bits   []uint64
+	hashes int
+	seed   maphash.Seed
+}
+
+// newBloomFilter sizes a filter for n keys at the given false positive rate.
+func newBloomFilter(n int, falsePositiveRate float64) *bloomFilter {
+	n = max(n, 1)
+	m := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / float64(n) * math.Ln2))
+	return &bloomFilter{
+		bits:   make([]uint64, (int(m)+63)/64),
+		hashes: max(k, 1),
+		seed:   maphash.MakeSeed(),
+	}
+}
+
+// positions calls fn with each bit position of key. The positions are derived from
+// one 64-bit hash split in two (h1 + i*h2), which is as good as k independent hashes.
+func (f *bloomFilter) positions(key string, fn func(bit uint64) bool) {
+	h := maphash.String(f.seed, key)
+	h1, h2 := h&0xffffffff, h>>32|1
+	size := uint64(len(f.bits)) * 64
+	for i := 0; i < f.hashes; i++ {
+		if !fn((h1 + uint64(i)*h2) % size) {
+			return
+		}
+	}
+}
+
+func (f *bloomFilter) add(key string) {
+	f.positions(key, func(bit uint64) bool {
+		f.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+}
+
+// mayContain reports whether key may have been added. False is certain.
+func (f *bloomFilter) mayContain(key string) bool {
+	present := true
+	f.positions(key, func(bit uint64) bool {
+		present = f.bits[bit/64]&(1<<(bit%64)) != 0
+		return present
+	})
+	return present
+}
+
+// Key prefixes keep the kinds of key in a dataset's filter apart, so the postcode
+// "3000" and the search word "3000" are distinct.
+const (
+	bloomPostcodeKey = "p:"
+	bloomTokenKey    = "t:"
+)
+
+// buildKnownKeys returns a filter of the dataset's postcodes and of every prefix of
+// every word the search index holds, so lookups for postcodes or words that appear
+// nowhere in the data can be turned away before the index is consulted.
+func buildKnownKeys(rows []PostcodeResult) *bloomFilter {
+	keys := map[string]struct{}{}
+	for _, row := range rows {
+		keys[bloomPostcodeKey+row.Postcode] = struct{}{}
+		tokens := append(tokenize(row.Suburb), tokenize(row.State)...)
+		for _, token := range append(tokens, row.Postcode) {
+			for end := 1; end <= len(token); end++ {
+				keys[bloomTokenKey+token[:end]] = struct{}{}
+			}
+		}
+	}
+	filter := newBloomFilter(len(keys), bloomFalsePositiveRate)
+	for key := range keys {
+		filter.add(key)
+	}
+	return filter
+}
+
+// mayHavePostcode reports whether the dataset may hold postcode. False is certain.
+func (d *Dataset) mayHavePostcode(postcode string) bool {
+	if d.known == nil || d.known.mayContain(bloomPostcodeKey+postcode) {
+		return true
+	}
+	lookupMetrics.Add("bloom_rejections", 1)
+	return false
+}
+
+// mayMatch reports whether a search for keyword may find rows. Every word of a
+// search must prefix an indexed word, so one word missing from the filter settles it.
+func (d *Dataset) mayMatch(keyword string) bool {
+	if d.known == nil {
+		return true
+	}
+	for _, token := range tokenize(keyword) {
+		if !d.known.mayContain(bloomTokenKey + token) {
+			lookupMetrics.Add("bloom_rejections", 1)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Store buckets holding cached scrape results, keyed by the normalised lookup.
+const (
+	resultsBucket = "results"
+	nearbyBucket  = "nearby"
+)
+
+// cachedEntry is a cached value as persisted in the store.
+type cachedEntry[T any] struct {
+	Value     T         `json:"results"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// resultCache caches parsed scrape results so repeated lookups for the same key
+// don't hit the upstream site again until the entry's TTL expires.
+type resultCache[T any] struct {
+	store  Store
+	bucket string
+	ttl    time.Duration
+
+	// hits and misses count lookups, for GET /status.
+	hits, misses atomic.Uint64
+
+	// mu serialises writes while they keep size up to date. size is the number
+	// of entries, counted from the store once when first asked for and then
+	// kept by put, forget and flush, so GET /status doesn't walk the bucket.
+	mu      sync.Mutex
+	counted bool
+	size    int
+}
+
+// cacheKey normalises a key's case and surrounding space, so "Sydney" and " sydney"
+// share an entry. Search keywords are also passed through normalizeName first, so
+// spellings that map to the same upstream URL share one too.
+func cacheKey(keyword string) string {
+	return strings.ToLower(strings.TrimSpace(keyword))
+}
+
+// get returns the cached value for key if present and not yet expired.
+// Store failures are logged and treated as a miss so a broken cache never fails a lookup.
+func (c *resultCache[T]) get(ctx context.Context, key string) (T, bool) {
+	value, ok := c.lookup(ctx, key)
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return value, ok
+}
+
+// lookup is get without counting the hit or miss.
+func (c *resultCache[T]) lookup(ctx context.Context, key string) (T, bool) {
+	entry, ok := c.read(ctx, key)
+	if !ok || time.Since(entry.FetchedAt) > c.ttl {
+		var zero T
+		return zero, false
+	}
+	return entry.Value, true
+}
+
+// stale returns the cached value for key even if it has expired, for when a
+// fresh one can't be had in time.
+func (c *resultCache[T]) stale(ctx context.Context, key string) (T, bool) {
+	entry, ok := c.read(ctx, key)
+	return entry.Value, ok
+}
+
+// read returns the stored entry for key, expired or not.
+func (c *resultCache[T]) read(ctx context.Context, key string) (cachedEntry[T], bool) {
+	var entry cachedEntry[T]
+	raw, ok, err := c.store.Get(c.bucket, cacheKey(key))
+	if err != nil {
+		logf(ctx, "Warning: cache read failed for '%s': %v", key, err)
+		return entry, false
+	}
+	if !ok {
+		return entry, false
+	}
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		logf(ctx, "Warning: discarding corrupt cache entry for '%s': %v", key, err)
+		return cachedEntry[T]{}, false
+	}
+	return entry, true
+}
+
+// put stores value for key, stamped with the current time.
+func (c *resultCache[T]) put(ctx context.Context, key string, value T) {
+	if readOnly {
+		return
+	}
+	raw, err := json.Marshal(cachedEntry[T]{Value: value, FetchedAt: time.Now()})
+	if err != nil {
+		logf(ctx, "Warning: cache write failed for '%s': %v", key, err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	existed := c.exists(key)
+	if err := c.store.Put(c.bucket, cacheKey(key), raw); err != nil {
+		logf(ctx, "Warning: cache write failed for '%s': %v", key, err)
+		return
+	}
+	if c.counted && !existed {
+		c.size++
+	}
+}
+
+// forget removes the entry for key, if any.
+func (c *resultCache[T]) forget(ctx context.Context, key string) {
+	if readOnly {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	existed := c.exists(key)
+	if err := c.store.Delete(c.bucket, cacheKey(key)); err != nil {
+		logf(ctx, "Warning: cache delete failed for '%s': %v", key, err)
+		return
+	}
+	if c.counted && existed {
+		c.size--
+	}
+}
+
+// exists reports whether the store holds an entry for key. It only matters once
+// the entries have been counted, so it skips the read until then.
+func (c *resultCache[T]) exists(key string) bool {
+	if !c.counted {
+		return false
+	}
+	_, ok, err := c.store.Get(c.bucket, cacheKey(key))
+	return ok && err == nil
+}
+
+// entries returns how many entries the cache holds, expired ones included. The
+// store is only walked the first time; after that the running count is returned.
+func (c *resultCache[T]) entries() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counted {
+		return c.size, nil
+	}
+	n := 0
+	err := c.store.ForEach(c.bucket, func(string, []byte) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	c.counted, c.size = true, n
+	return n, nil
+}
+
+// flush removes every entry from the cache and returns how many were removed.
+func (c *resultCache[T]) flush() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Collect keys first: bbolt cannot delete from inside a ForEach transaction.
+	var keys []string
+	err := c.store.ForEach(c.bucket, func(key string, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// The store is walked anyway, so the count is taken from it.
+	c.counted, c.size = true, len(keys)
+	for i, key := range keys {
+		if err := c.store.Delete(c.bucket, key); err != nil {
+			c.size -= i
+			return i, err
+		}
+	}
+	c.size = 0
+	return len(keys), nil
+}
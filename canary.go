@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// canaryCheck is a known-good expectation: searching Keyword returns a row for
+// Suburb, State with Postcode.
+type canaryCheck struct {
+	Keyword  string `json:"keyword"`
+	Postcode string `json:"postcode"`
+	Suburb   string `json:"suburb"`
+	State    string `json:"state"`
+}
+
+func (c canaryCheck) String() string {
+	return fmt.Sprintf("%q → %s %s %s", c.Keyword, c.Postcode, c.Suburb, c.State)
+}
+
+// canaryFailure is a check that failed, and why.
+type canaryFailure struct {
+	canaryCheck
+	Reason string `json:"reason"`
+}
+
+// canaryMonitor runs the canary checks in the background. While any fails,
+// /healthz reports the server unready and an alert is sent, so silently
+// corrupted data or a changed upstream is caught before users notice. A check
+// whose live scrape fails hasn't shown the data wrong, so it is reported in
+// unreachable instead, which alerts but leaves the server ready.
+type canaryMonitor struct {
+	checks []canaryCheck
+
+	mu          sync.Mutex
+	lastRun     time.Time
+	failures    []canaryFailure
+	unreachable []canaryFailure
+}
+
+// canary is the configured monitor, or nil when -canary-file is not set.
+var canary *canaryMonitor
+
+// loadCanaryChecks reads a canary CSV file with "keyword", "postcode", "suburb"
+// and "state" columns.
+func loadCanaryChecks(path string) ([]canaryCheck, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open canary checks: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read canary header: %w", err)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"keyword", "postcode", "suburb", "state"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("canary header is missing a %s column", required)
+		}
+	}
+	cell := func(record []string, column string) string {
+		if i := index[column]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var checks []canaryCheck
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read canary checks: %w", err)
+		}
+		check := canaryCheck{
+			Keyword:  cell(record, "keyword"),
+			Postcode: cell(record, "postcode"),
+			Suburb:   strings.ToUpper(cell(record, "suburb")),
+			State:    strings.ToUpper(cell(record, "state")),
+		}
+		if check.Keyword == "" || check.Postcode == "" || check.Suburb == "" {
+			return nil, fmt.Errorf("canary check %q needs a keyword, postcode and suburb", strings.Join(record, ","))
+		}
+		checks = append(checks, check)
+	}
+	if len(checks) == 0 {
+		return nil, errors.New("canary file contains no checks")
+	}
+	return checks, nil
+}
+
+func newCanaryMonitor(checks []canaryCheck) *canaryMonitor {
+	return &canaryMonitor{checks: checks}
+}
+
+// verify runs one check the way a search would be answered: from the dataset
+// when one is loaded, otherwise by scraping the upstream live. It returns
+// mismatch when the expected row is missing, and fetchErr when the scrape
+// couldn't be done, which says nothing about the data.
+func (c canaryCheck) verify(ctx context.Context) (mismatch, fetchErr error) {
+	var rows []PostcodeResult
+	if dataset := currentDataset(); dataset != nil {
+		rows = dataset.Search(c.Keyword)
+	} else {
+		// Canary scrapes share the budget with searches, waiting when it is spent.
+		if err := waitForBudget(ctx); err != nil {
+			return nil, err
+		}
+		var err error
+		if rows, _, err = searchPostcodes(ctx, c.Keyword); err != nil {
+			return nil, err
+		}
+	}
+	for _, row := range rows {
+		if row.Postcode == c.Postcode && strings.EqualFold(row.Suburb, c.Suburb) && (c.State == "" || strings.EqualFold(row.State, c.State)) {
+			return nil, nil
+		}
+	}
+	return fmt.Errorf("expected row missing from %d results", len(rows)), nil
+}
+
+// run checks every expectation, then alerts when the canary starts or stops
+// failing, and when checks start or stop being unreachable.
+func (m *canaryMonitor) run(ctx context.Context) {
+	var failures, unreachable []canaryFailure
+	for _, check := range m.checks {
+		mismatch, fetchErr := check.verify(ctx)
+		switch {
+		case mismatch != nil:
+			failures = append(failures, canaryFailure{canaryCheck: check, Reason: mismatch.Error()})
+		case fetchErr != nil:
+			unreachable = append(unreachable, canaryFailure{canaryCheck: check, Reason: fetchErr.Error()})
+		}
+	}
+
+	m.mu.Lock()
+	wasFailing, wasUnreachable := len(m.failures) > 0, len(m.unreachable) > 0
+	m.lastRun, m.failures, m.unreachable = time.Now().UTC(), failures, unreachable
+	m.mu.Unlock()
+
+	switch {
+	case len(failures) > 0 && !wasFailing:
+		canaryAlert(fmt.Sprintf("[postcode_scraper] %d of %d canary checks failing", len(failures), len(m.checks)),
+			"Known-good lookups no longer return their expected rows, so /healthz reports the server unready:\n"+canaryLines(failures))
+	case len(failures) == 0 && wasFailing:
+		canaryAlert("[postcode_scraper] canary checks recovered", fmt.Sprintf("All %d canary checks pass again.", len(m.checks)))
+	}
+	switch {
+	case len(unreachable) > 0 && !wasUnreachable:
+		canaryAlert(fmt.Sprintf("[postcode_scraper] %d of %d canary checks could not reach the upstream", len(unreachable), len(m.checks)),
+			"These lookups could not be scraped, so their data went unchecked; /healthz still reports the server ready:\n"+canaryLines(unreachable))
+	case len(unreachable) == 0 && wasUnreachable:
+		canaryAlert("[postcode_scraper] canary checks reach the upstream again", "Every canary lookup could be scraped again.")
+	}
+}
+
+// canaryLines lists failures one per line for an alert.
+func canaryLines(failures []canaryFailure) string {
+	lines := make([]string, len(failures))
+	for i, failure := range failures {
+		lines[i] = fmt.Sprintf("%s: %s", failure.canaryCheck, failure.Reason)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// canaryAlert logs a canary state change and sends it to the alert destinations.
+func canaryAlert(subject, body string) {
+	if scrapeAlerts == nil {
+		log.Printf("Alert: %s\n%s", subject, body)
+		return
+	}
+	go scrapeAlerts.notify(subject, body)
+}
+
+// loop runs the checks now and then every interval until ctx is done.
+func (m *canaryMonitor) loop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.run(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// failing reports whether the last run had failures. It is safe to call on a nil monitor.
+func (m *canaryMonitor) failing() bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.failures) > 0
+}
+
+// canaryStatus is the canary section of GET /status.
+type canaryStatus struct {
+	Checks   int             `json:"checks"`
+	LastRun  *time.Time      `json:"last_run,omitempty"`
+	Failures []canaryFailure `json:"failures"`
+	// Unreachable lists the checks whose live scrape failed in the last run.
+	Unreachable []canaryFailure `json:"unreachable"`
+}
+
+func (m *canaryMonitor) status() *canaryStatus {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := &canaryStatus{
+		Checks:      len(m.checks),
+		Failures:    append([]canaryFailure{}, m.failures...),
+		Unreachable: append([]canaryFailure{}, m.unreachable...),
+	}
+	if !m.lastRun.IsZero() {
+		status.LastRun = &m.lastRun
+	}
+	return status
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// lowerCaseWords stay lower case inside a title-cased name, as in "Isle of Capri".
+var lowerCaseWords = map[string]bool{"of": true, "the": true, "on": true, "and": true}
+
+// parseCase reads the ?case= parameter, which is "upper" (the default, as the
+// upstream spells names) or "title".
+func parseCase(value string) (bool, error) {
+	switch value {
+	case "", "upper":
+		return false, nil
+	case "title":
+		return true, nil
+	}
+	return false, errors.New("Invalid 'case' parameter: expected 'upper' or 'title'")
+}
+
+// titleCase turns an upper-case place name into title case: "SURRY HILLS" becomes
+// "Surry Hills", "MCKINNON" "McKinnon", "O'CONNOR" "O'Connor" and
+// "KINGS CROSS-DARLINGHURST" "Kings Cross-Darlinghurst". Mac is left alone, since
+// gazetted names spell it "Mackay" and "Macleod" rather than "MacLeod".
+func titleCase(name string) string {
+	words := strings.Fields(strings.ToLower(name))
+	for i, word := range words {
+		if i > 0 && lowerCaseWords[word] {
+			continue
+		}
+		parts := strings.Split(word, "-")
+		for j, part := range parts {
+			parts[j] = titleWord(part)
+		}
+		words[i] = strings.Join(parts, "-")
+	}
+	return strings.Join(words, " ")
+}
+
+// titleWord capitalises one lower-case word, and the letter after a Mc prefix or
+// after a one-letter prefix and apostrophe (O'Connor, D'Aguilar). A possessive
+// such as "King's" keeps its s.
+func titleWord(word string) string {
+	runes := []rune(word)
+	first := 0
+	for first < len(runes) && !unicode.IsLetter(runes[first]) {
+		first++
+	}
+	if first == len(runes) {
+		return word
+	}
+	runes[first] = unicode.ToUpper(runes[first])
+
+	rest := runes[first:]
+	switch {
+	case len(rest) > 2 && rest[1] == 'c' && rest[0] == 'M' && unicode.IsLetter(rest[2]):
+		rest[2] = unicode.ToUpper(rest[2])
+	case len(rest) > 2 && (rest[1] == '\'' || rest[1] == '’') && unicode.IsLetter(rest[2]):
+		rest[2] = unicode.ToUpper(rest[2])
+	}
+	return string(runes)
+}
+
+// titleCaseResults returns a copy of results with suburb names in title case,
+// leaving the cached and dataset rows they came from untouched.
+func titleCaseResults(results []PostcodeResult) []PostcodeResult {
+	cased := make([]PostcodeResult, len(results))
+	for i, result := range results {
+		result.Suburb = titleCase(result.Suburb)
+		if result.AliasOf != "" {
+			result.AliasOf = titleCase(result.AliasOf)
+		}
+		if result.DualName != "" {
+			names := strings.Split(result.DualName, " / ")
+			for i, name := range names {
+				names[i] = titleCase(name)
+			}
+			result.DualName = strings.Join(names, " / ")
+		}
+		cased[i] = result
+	}
+	return cased
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// clientTimeoutHeader lets a client cap how long it will wait for a response,
+// in milliseconds, below the endpoint's own timeout.
+const clientTimeoutHeader = "X-Timeout-Ms"
+
+// staleHeader marks a search answered from an expired cache entry because the
+// client's X-Timeout-Ms ran out before the upstream answered.
+const staleHeader = "X-Stale"
+
+// clientDeadlineKey marks a request context whose deadline the client set.
+type clientDeadlineKey struct{}
+
+// withClientTimeout applies a request's X-Timeout-Ms, when it is shorter than
+// limit, as the deadline of its context.
+func withClientTimeout(limit time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := r.Header.Get(clientTimeoutHeader)
+		if raw == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ms, err := strconv.Atoi(raw)
+		if err != nil || ms <= 0 {
+			writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid X-Timeout-Ms header: expected a positive number of milliseconds")
+			return
+		}
+		timeout := time.Duration(ms) * time.Millisecond
+		if timeout >= limit {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.WithValue(r.Context(), clientDeadlineKey{}, true), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientTimedOut reports whether ctx ended because the client's X-Timeout-Ms ran out.
+func clientTimedOut(ctx context.Context) bool {
+	set, _ := ctx.Value(clientDeadlineKey{}).(bool)
+	return set && errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// writeClientTimeout answers a request whose X-Timeout-Ms ran out with a 504.
+func writeClientTimeout(w http.ResponseWriter) {
+	writeError(w, http.StatusGatewayTimeout, codeClientTimeout, "The X-Timeout-Ms deadline passed before the upstream answered")
+}
+
+// scrapeFallback handles a failed scrape of key. When the client's X-Timeout-Ms
+// cut it short, an expired cache entry is better than nothing and is returned,
+// marked with X-Stale; without one the client gets a 504. Any other failure is
+// written as an upstream error. It returns false when it has written a response.
+func scrapeFallback(w http.ResponseWriter, r *http.Request, key string, err error) ([]PostcodeResult, bool) {
+	if !clientTimedOut(r.Context()) {
+		writeError(w, http.StatusInternalServerError, upstreamErrorCode(err), err.Error())
+		return nil, false
+	}
+	if results, ok := scrapeCache.stale(context.WithoutCancel(r.Context()), key); ok {
+		w.Header().Set(staleHeader, "true")
+		return results, true
+	}
+	writeClientTimeout(w)
+	return nil, false
+}
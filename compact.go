@@ -0,0 +1,152 @@
+package main
+
+import "net/http"
+
+// compactColumn is one column of a ?compact=true table.
+type compactColumn struct {
+	name string
+	// always columns are listed even when every row leaves them empty, matching
+	// the fields the object form always has.
+	always bool
+	value  func(PostcodeResult) any
+}
+
+// compactColumns are the result fields in the order the object form lists them.
+var compactColumns = []compactColumn{
+	{"postcode", true, func(r PostcodeResult) any { return r.Postcode }},
+	{"suburb", true, func(r PostcodeResult) any { return r.Suburb }},
+	{"state", true, func(r PostcodeResult) any { return r.State }},
+	{"category", true, func(r PostcodeResult) any { return r.Category }},
+	{"locality_type", false, func(r PostcodeResult) any { return r.LocalityType }},
+	{"delivery_office", false, func(r PostcodeResult) any { return r.DeliveryOffice }},
+	{"bsp_number", false, func(r PostcodeResult) any { return r.BSPNumber }},
+	{"bsp_name", false, func(r PostcodeResult) any { return r.BSPName }},
+	{"latitude", false, func(r PostcodeResult) any { return r.Latitude }},
+	{"longitude", false, func(r PostcodeResult) any { return r.Longitude }},
+	{"alias_of", false, func(r PostcodeResult) any { return r.AliasOf }},
+	{"dual_name", false, func(r PostcodeResult) any { return r.DualName }},
+	{"effective_to", false, func(r PostcodeResult) any { return r.EffectiveTo }},
+	{"last_verified_at", false, func(r PostcodeResult) any { return r.LastVerifiedAt }},
+	{"raw", false, func(r PostcodeResult) any { return r.Raw }},
+	{"score", false, func(r PostcodeResult) any { return r.Score }},
+}
+
+// compactJSON reports whether the client asked for ?compact=true.
+func compactJSON(w http.ResponseWriter) bool {
+	fw, ok := w.(*formatWriter)
+	return ok && fw.compact
+}
+
+// compactTable lays results out as a header row of field names followed by one
+// positional array per result, so a long list doesn't repeat every key. Optional
+// fields get a column only when some result has them, and are null in the rows
+// of results without them. extra adds trailing columns computed from each result's
+// position.
+func compactTable(results []PostcodeResult, extra ...compactExtra) [][]any {
+	var columns []compactColumn
+	for _, column := range compactColumns {
+		used := column.always
+		for i := 0; i < len(results) && !used; i++ {
+			used = column.value(results[i]) != column.value(PostcodeResult{})
+		}
+		if used {
+			columns = append(columns, column)
+		}
+	}
+
+	header := make([]any, 0, len(columns)+len(extra))
+	for _, column := range columns {
+		header = append(header, column.name)
+	}
+	for _, e := range extra {
+		header = append(header, e.name)
+	}
+	table := [][]any{header}
+	for i, result := range results {
+		row := make([]any, 0, len(header))
+		for _, column := range columns {
+			value := column.value(result)
+			if !column.always && value == column.value(PostcodeResult{}) {
+				value = nil
+			}
+			row = append(row, value)
+		}
+		for _, e := range extra {
+			row = append(row, e.value(i))
+		}
+		table = append(table, row)
+	}
+	return table
+}
+
+// compactExtra is a column appended to a compact table for a response type that
+// adds fields to its results.
+type compactExtra struct {
+	name  string
+	value func(i int) any
+}
+
+// compactGroups lays postcode groups out as a compact table whose suburbs column
+// holds each group's suburbs as a nested table.
+func compactGroups(groups []PostcodeGroup) [][]any {
+	table := [][]any{{"postcode", "suburbs"}}
+	for _, group := range groups {
+		suburbs := [][]any{{"suburb", "state", "category"}}
+		for _, suburb := range group.Suburbs {
+			suburbs = append(suburbs, []any{suburb.Suburb, suburb.State, suburb.Category})
+		}
+		table = append(table, []any{group.Postcode, suburbs})
+	}
+	return table
+}
+
+// compactSuburbs lays suburb listings out as a compact table.
+func compactSuburbs(listings []SuburbListing) [][]any {
+	table := [][]any{{"suburb", "state", "postcodes"}}
+	for _, listing := range listings {
+		table = append(table, []any{listing.Suburb, listing.State, listing.Postcodes})
+	}
+	return table
+}
+
+// compactPage keeps a page's pagination fields around its compacted results.
+func compactPage[T any](page Page[T], table [][]any) Page[[]any] {
+	return Page[[]any]{Page: page.Page, PerPage: page.PerPage, Total: page.Total, Results: table}
+}
+
+// compactBody returns v in the ?compact=true layout when v holds result rows:
+// lists of results become compact tables, including inside the warnings wrapper,
+// state groups, geo query responses, postcode groups and the pages of the
+// listings. Other bodies are returned unchanged.
+func compactBody(v any) any {
+	switch body := v.(type) {
+	case []PostcodeResult:
+		return compactTable(body)
+	case []PostcodeGroup:
+		return compactGroups(body)
+	case Page[PostcodeGroup]:
+		return compactPage(body, compactGroups(body.Results))
+	case Page[SuburbListing]:
+		return compactPage(body, compactSuburbs(body.Results))
+	case warnedResults:
+		body.Results = compactBody(body.Results)
+		return body
+	case map[string][]PostcodeResult:
+		grouped := make(map[string][][]any, len(body))
+		for state, results := range body {
+			grouped[state] = compactTable(results)
+		}
+		return grouped
+	case []GeoResult:
+		results := make([]PostcodeResult, len(body))
+		for i, result := range body {
+			results[i] = result.PostcodeResult
+		}
+		return compactTable(results, compactExtra{"distance_km", func(i int) any { return body[i].DistanceKM }})
+	case map[string]any:
+		if _, ok := body["results"]; ok && len(body) == 1 {
+			return map[string]any{"results": compactBody(body["results"])}
+		}
+	}
+	return v
+}
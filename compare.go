@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FieldMismatch is a locality present in both datasets with differing fields.
+type FieldMismatch struct {
+	Postcode string               `json:"postcode"`
+	Suburb   string               `json:"suburb"`
+	Fields   map[string][2]string `json:"fields"` // field -> [scraped, official]
+}
+
+// ComparisonSummary counts how the scraped dataset lines up with the official one.
+type ComparisonSummary struct {
+	Official   int `json:"official_rows"`
+	Scraped    int `json:"scraped_rows"`
+	Matched    int `json:"matched"`    // in both, identical
+	Mismatched int `json:"mismatched"` // in both, some field differs
+	Missing    int `json:"missing"`    // official rows the scrape lacks
+	Extra      int `json:"extra"`      // scraped rows the official file lacks
+	// Coverage is the share of official rows found by the scrape, and Accuracy the
+	// share of those found whose fields all agree.
+	Coverage float64 `json:"coverage"`
+	Accuracy float64 `json:"accuracy"`
+}
+
+// ComparisonReport is the output of the compare command.
+type ComparisonReport struct {
+	Summary    ComparisonSummary `json:"summary"`
+	Missing    []PostcodeResult  `json:"missing"`
+	Extra      []PostcodeResult  `json:"extra"`
+	Mismatches []FieldMismatch   `json:"mismatches"`
+}
+
+// localityKey identifies a row across datasets by postcode and suburb name, ignoring
+// case and spacing, since the scraped and official spellings differ in casing.
+func localityKey(row PostcodeResult) string {
+	return row.Postcode + "|" + normalizeName(row.Suburb)
+}
+
+// compareDatasets diffs scraped rows against the official datafile. Rows are
+// matched by postcode and suburb; state and category are then compared.
+func compareDatasets(scraped, official []PostcodeResult) ComparisonReport {
+	report := ComparisonReport{Missing: []PostcodeResult{}, Extra: []PostcodeResult{}, Mismatches: []FieldMismatch{}}
+	report.Summary.Official = len(official)
+	report.Summary.Scraped = len(scraped)
+
+	scrapedByKey := map[string]PostcodeResult{}
+	for _, row := range scraped {
+		scrapedByKey[localityKey(row)] = row
+	}
+	officialKeys := map[string]bool{}
+
+	for _, want := range official {
+		key := localityKey(want)
+		officialKeys[key] = true
+		got, ok := scrapedByKey[key]
+		if !ok {
+			report.Missing = append(report.Missing, want)
+			continue
+		}
+
+		fields := map[string][2]string{}
+		if !strings.EqualFold(got.State, want.State) {
+			fields["state"] = [2]string{got.State, want.State}
+		}
+		// The official file doesn't always carry a category; only compare when it does.
+		if want.Category != "" && !strings.EqualFold(got.Category, want.Category) {
+			fields["category"] = [2]string{got.Category, want.Category}
+		}
+		if len(fields) > 0 {
+			report.Mismatches = append(report.Mismatches, FieldMismatch{Postcode: want.Postcode, Suburb: want.Suburb, Fields: fields})
+		} else {
+			report.Summary.Matched++
+		}
+	}
+	for _, row := range scraped {
+		if !officialKeys[localityKey(row)] {
+			report.Extra = append(report.Extra, row)
+		}
+	}
+
+	byLocality := func(rows []PostcodeResult) {
+		sort.SliceStable(rows, func(i, j int) bool { return localityKey(rows[i]) < localityKey(rows[j]) })
+	}
+	byLocality(report.Missing)
+	byLocality(report.Extra)
+
+	s := &report.Summary
+	s.Mismatched = len(report.Mismatches)
+	s.Missing = len(report.Missing)
+	s.Extra = len(report.Extra)
+	if found := s.Matched + s.Mismatched; found > 0 {
+		s.Coverage = float64(found) / float64(s.Official)
+		s.Accuracy = float64(s.Matched) / float64(found)
+	}
+	return report
+}
+
+// loadComparisonDataset loads a dataset from a local CSV file or, for s3:// paths,
+// the latest snapshot at that location.
+func loadComparisonDataset(ctx context.Context, source string) (*Dataset, error) {
+	if strings.HasPrefix(source, "s3://") {
+		dataset, _, err := loadSnapshotDataset(ctx, source)
+		return dataset, err
+	}
+	return loadDataset(source)
+}
+
+// runCompare implements the "compare" subcommand, printing a JSON report of how a
+// scraped dataset differs from the official Australia Post datafile.
+func runCompare(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	limit := fs.Int("limit", 100, "Maximum rows listed per section of the report (0 lists all)")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errors.New("usage: compare [-limit N] SCRAPED OFFICIAL (each a CSV file or s3://bucket/prefix snapshot)")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	scraped, err := loadComparisonDataset(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	official, err := loadComparisonDataset(ctx, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	report := compareDatasets(scraped.Rows, official.Rows)
+	if *limit > 0 {
+		report.Missing = report.Missing[:min(len(report.Missing), *limit)]
+		report.Extra = report.Extra[:min(len(report.Extra), *limit)]
+		report.Mismatches = report.Mismatches[:min(len(report.Mismatches), *limit)]
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "    ")
+	return out.Encode(report)
+}
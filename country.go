@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Source is one country's postcode data, answering the searches and validation
+// the API offers for it. Australia's source is the -dataset, or the upstream
+// scraper when none is loaded; other countries are served from their own datasets.
+type Source interface {
+	Search(keyword string) []PostcodeResult
+	Validate(postcode, suburb, state string, strict bool) ValidationResult
+}
+
+// defaultCountry is the country searched without ?country=.
+const defaultCountry = "AU"
+
+// supportedCountries maps each ?country= code to the flag that loads its data.
+var supportedCountries = map[string]string{
+	"AU": "-dataset",
+	"NZ": "-nz-dataset",
+}
+
+// countrySources holds the loaded sources of countries other than Australia,
+// keyed by ISO 3166 code; main registers them at startup.
+var countrySources = map[string]Source{}
+
+// datasetSource serves a country other than Australia from a dataset. Its rows
+// keep the region in State. The Australian alias table doesn't apply.
+type datasetSource struct {
+	dataset *Dataset
+}
+
+// newDatasetSource returns a source for another country's dataset. Loading
+// classifies rows by Australia's PO Box postcode ranges, which mean nothing
+// elsewhere, so rows marked po_box by their postcode alone go back to locality.
+func newDatasetSource(dataset *Dataset) datasetSource {
+	for i := range dataset.Rows {
+		row := &dataset.Rows[i]
+		if row.LocalityType == localityPOBox && inPOBoxRange(row.Postcode) && !poBoxCategory(row.Category) {
+			row.LocalityType = localityLocality
+		}
+	}
+	return datasetSource{dataset: dataset}
+}
+
+func (s datasetSource) Search(keyword string) []PostcodeResult {
+	return s.dataset.Search(keyword)
+}
+
+func (s datasetSource) Validate(postcode, suburb, state string, strict bool) ValidationResult {
+	return s.dataset.validate(postcode, suburb, state, strict, nil)
+}
+
+// countrySource reads ?country=AU|NZ. It returns a nil Source for Australia, whose
+// requests take the existing dataset or scrape path. For any other country it
+// writes an error response and returns false when the code is unknown or its data
+// isn't loaded.
+func countrySource(w http.ResponseWriter, query url.Values) (Source, bool) {
+	country := strings.ToUpper(strings.TrimSpace(query.Get("country")))
+	if country == "" || country == defaultCountry {
+		return nil, true
+	}
+	flagName, ok := supportedCountries[country]
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid 'country' parameter: expected AU or NZ")
+		return nil, false
+	}
+	source, ok := countrySources[country]
+	if !ok {
+		writeError(w, http.StatusServiceUnavailable, codeDatasetUnavailable, fmt.Sprintf("Postcodes for %s are not loaded; start the server with %s", country, flagName))
+		return nil, false
+	}
+	return source, true
+}
+
+// australianOnlyParams are the /search parameters that depend on Australian
+// states or the Australian data, and so are rejected with another country.
+var australianOnlyParams = []string{"match", "prefer_state", "as_of", "refresh", "shape"}
+
+// countrySearch answers a /search for a country other than Australia: a ranked
+// keyword search of its source, with the options that aren't Australia-specific.
+func countrySearch(w http.ResponseWriter, query url.Values, source Source, keyword string, countOnly, withScore bool, groupBy string, localityFilter map[string]bool, titleCased bool) {
+	for _, name := range australianOnlyParams {
+		if query.Has(name) {
+			writeError(w, http.StatusBadRequest, codeParameterInvalid, fmt.Sprintf("The '%s' parameter is only available for Australian searches", name))
+			return
+		}
+	}
+
+	results := source.Search(keyword)
+	rankResults(results, keyword, withScore)
+	results = filterLocalityTypes(results, localityFilter)
+	if titleCased {
+		results = titleCaseResults(results)
+	}
+
+	switch {
+	case countOnly:
+		writeJSON(w, http.StatusOK, map[string]int{"count": len(results)})
+	case len(results) == 0:
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"message": fmt.Sprintf("No postcodes found for keyword '%s'.", keyword),
+			"code":    string(codeNotFound),
+		})
+	case groupBy == "state":
+		writeJSON(w, http.StatusOK, groupByState(results))
+	default:
+		writeJSON(w, http.StatusOK, results)
+	}
+}
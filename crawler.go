@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// checkpointEvery is how many postcodes are crawled between checkpoint saves.
+const checkpointEvery = 50
+
+// crawler scrapes the upstream page of every allocated postcode to build a full
+// dataset, pausing between requests to stay polite.
+type crawler struct {
+	delay time.Duration
+	// checkpoint is a file progress is saved to, so an interrupted crawl resumes
+	// where it stopped instead of starting over; empty disables checkpointing.
+	checkpoint string
+
+	// retries is how many more times a failed postcode is tried once the first
+	// pass is done; the wait before each retry round starts at retryBackoff and
+	// doubles.
+	retries      int
+	retryBackoff time.Duration
+	// deadLetter is a file the postcodes that still failed after every retry are
+	// reported to; empty only logs them.
+	deadLetter string
+}
+
+// crawlFailure is a postcode whose page could not be crawled.
+type crawlFailure struct {
+	Postcode  string `json:"postcode"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+}
+
+// crawlCheckpoint is the progress of an unfinished crawl.
+type crawlCheckpoint struct {
+	StartedAt    time.Time        `json:"started_at"`
+	LastPostcode string           `json:"last_postcode"`
+	Failures     []crawlFailure   `json:"failures"`
+	Rows         []PostcodeResult `json:"rows"`
+}
+
+// loadCheckpoint returns the saved progress, or a fresh checkpoint when there is
+// none. An unreadable checkpoint is logged and ignored.
+func (c *crawler) loadCheckpoint() crawlCheckpoint {
+	fresh := crawlCheckpoint{StartedAt: time.Now().UTC(), Failures: []crawlFailure{}, Rows: []PostcodeResult{}}
+	if c.checkpoint == "" {
+		return fresh
+	}
+	raw, err := os.ReadFile(c.checkpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return fresh
+	}
+	var saved crawlCheckpoint
+	if err == nil {
+		err = json.Unmarshal(raw, &saved)
+	}
+	if err != nil {
+		log.Printf("Warning: ignoring unreadable crawl checkpoint %s: %v", c.checkpoint, err)
+		return fresh
+	}
+	return saved
+}
+
+// saveCheckpoint persists progress. A failed save is logged; the crawl carries on.
+func (c *crawler) saveCheckpoint(cp crawlCheckpoint) {
+	if c.checkpoint == "" {
+		return
+	}
+	raw, err := json.Marshal(cp)
+	if err == nil {
+		err = writeFileAtomic(c.checkpoint, raw)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to save crawl checkpoint %s: %v", c.checkpoint, err)
+	}
+}
+
+// run crawls every postcode and returns the collected rows. Postcodes that fail
+// are logged and skipped. It stops early, returning what it has, if ctx is
+// cancelled; with a checkpoint file the next run then resumes after the last
+// postcode processed.
+func (c *crawler) run(ctx context.Context) ([]PostcodeResult, error) {
+	codes := allPostcodes()
+	cp := c.loadCheckpoint()
+
+	start := 0
+	if cp.LastPostcode != "" {
+		start = sort.SearchStrings(codes, cp.LastPostcode)
+		if start < len(codes) && codes[start] == cp.LastPostcode {
+			start++
+		}
+		log.Printf("Crawl resumed after postcode %s (started %s): %d postcodes left, %d rows, %d failures so far",
+			cp.LastPostcode, cp.StartedAt.Format(time.RFC3339), len(codes)-start, len(cp.Rows), len(cp.Failures))
+	} else {
+		log.Printf("Crawl started: %d postcodes", len(codes))
+	}
+
+	for i := start; i < len(codes); i++ {
+		code := codes[i]
+		if i > start {
+			select {
+			case <-ctx.Done():
+				c.saveCheckpoint(cp)
+				return cp.Rows, ctx.Err()
+			case <-time.After(c.delay):
+			}
+		}
+
+		results, _, err := crawlFetch(ctx, code)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			// Interrupted mid-request: leave this postcode for the resumed run.
+			c.saveCheckpoint(cp)
+			return cp.Rows, ctx.Err()
+		case err != nil:
+			cp.Failures = append(cp.Failures, crawlFailure{Postcode: code, Attempts: 1, LastError: err.Error()})
+			log.Printf("Warning: crawl of postcode %s failed: %v", code, err)
+		default:
+			// A postcode page can mention neighbouring codes; keep only its own rows.
+			// They become dataset rows, which don't carry the raw cell texts.
+			for _, result := range results {
+				if result.Postcode == code {
+					result.Raw = nil
+					cp.Rows = append(cp.Rows, result)
+				}
+			}
+		}
+		cp.LastPostcode = code
+
+		if (i+1)%checkpointEvery == 0 {
+			c.saveCheckpoint(cp)
+		}
+		if (i+1)%500 == 0 {
+			log.Printf("Crawl progress: %d/%d postcodes, %d rows, %d failures", i+1, len(codes), len(cp.Rows), len(cp.Failures))
+		}
+	}
+
+	if err := c.retryFailures(ctx, &cp); err != nil {
+		c.saveCheckpoint(cp)
+		return cp.Rows, err
+	}
+
+	log.Printf("Crawl finished: %d rows, %d failures", len(cp.Rows), len(cp.Failures))
+	c.reportDeadLetters(cp.Failures)
+	if c.checkpoint != "" {
+		if err := os.Remove(c.checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Warning: failed to remove crawl checkpoint %s: %v", c.checkpoint, err)
+		}
+	}
+	return cp.Rows, nil
+}
+
+// retryFailures works through the postcodes that failed in the first pass, in rounds
+// separated by an exponentially growing backoff, until each has been retried
+// c.retries times or succeeds. Failures left in cp.Failures are permanent.
+func (c *crawler) retryFailures(ctx context.Context, cp *crawlCheckpoint) error {
+	backoff := c.retryBackoff
+	for {
+		var queue, exhausted []crawlFailure
+		for _, failure := range cp.Failures {
+			if failure.Attempts <= c.retries {
+				queue = append(queue, failure)
+			} else {
+				exhausted = append(exhausted, failure)
+			}
+		}
+		if len(queue) == 0 {
+			return nil
+		}
+
+		log.Printf("Crawl retrying %d failed postcodes in %s", len(queue), backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+
+		for i, failure := range queue {
+			if i > 0 {
+				select {
+				case <-ctx.Done():
+					cp.Failures = append(exhausted, queue[i:]...)
+					return ctx.Err()
+				case <-time.After(c.delay):
+				}
+			}
+
+			results, _, err := crawlFetch(ctx, failure.Postcode)
+			if err != nil && ctx.Err() != nil {
+				cp.Failures = append(exhausted, queue[i:]...)
+				return ctx.Err()
+			}
+			failure.Attempts++
+			if err != nil {
+				failure.LastError = err.Error()
+				exhausted = append(exhausted, failure)
+				continue
+			}
+			for _, result := range results {
+				if result.Postcode == failure.Postcode {
+					result.Raw = nil
+					cp.Rows = append(cp.Rows, result)
+				}
+			}
+		}
+		cp.Failures = exhausted
+		c.saveCheckpoint(*cp)
+	}
+}
+
+// crawlFetch scrapes one postcode's page once the scrape budget, shared with
+// searches and the rest of the fleet, allows it.
+func crawlFetch(ctx context.Context, postcode string) ([]PostcodeResult, []ParseWarning, error) {
+	if err := waitForBudget(ctx); err != nil {
+		return nil, nil, err
+	}
+	return searchPostcodes(ctx, postcode)
+}
+
+// deadLetterReport lists the postcodes a finished crawl could not fetch, so they can
+// be investigated rather than silently missing from the dataset.
+type deadLetterReport struct {
+	FinishedAt time.Time      `json:"finished_at"`
+	Failed     []crawlFailure `json:"failed"`
+}
+
+// reportDeadLetters logs the permanently failed postcodes and writes them to the
+// dead-letter report file, replacing the previous crawl's report.
+func (c *crawler) reportDeadLetters(failures []crawlFailure) {
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Postcode < failures[j].Postcode })
+	if len(failures) > 0 {
+		codes := make([]string, len(failures))
+		for i, failure := range failures {
+			codes[i] = failure.Postcode
+		}
+		log.Printf("Warning: crawl could not fetch %d postcodes after retries: %s", len(codes), strings.Join(codes, ", "))
+	}
+	if c.deadLetter == "" {
+		return
+	}
+
+	raw, err := json.MarshalIndent(deadLetterReport{FinishedAt: time.Now().UTC(), Failed: failures}, "", "    ")
+	if err == nil {
+		err = writeFileAtomic(c.deadLetter, raw)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to write crawl dead-letter report %s: %v", c.deadLetter, err)
+	}
+}
+
+// writeDatasetCSV writes rows in the CSV format readDataset accepts.
+func writeDatasetCSV(w io.Writer, rows []PostcodeResult) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"postcode", "suburb", "state", "category", "locality_type"})
+	for _, row := range rows {
+		out.Write([]string{row.Postcode, row.Suburb, row.State, row.Category, row.LocalityType})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeDatasetFile writes rows to path atomically, so readers never see a partial file.
+func writeDatasetFile(path string, rows []PostcodeResult) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeDatasetCSV(tmp, rows); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Dataset is an in-memory copy of postcode data loaded from a local CSV file,
+// such as the official Australia Post postcode datafile. When a dataset is loaded,
+// searches are answered locally instead of scraping the upstream site.
+type Dataset struct {
+	Rows []PostcodeResult
+
+	index *searchIndex
+	// postcodes and suburbs index rows by postcode and by normalised suburb name
+	// for prefix listings.
+	postcodes, suburbs *radixTree
+	// locations is a spatial index of the rows' coordinates, or nil when the
+	// dataset has none.
+	locations *spatialIndex
+	// known holds the dataset's postcodes and search words, to turn away lookups
+	// for ones it doesn't have.
+	known *bloomFilter
+	// sourceTime is when the data was current at its source: the file's
+	// modification time, the snapshot's time or the remote file's Last-Modified.
+	// It is zero when unknown, as for a crawl, whose rows carry their own.
+	sourceTime time.Time
+	// release frees the file mapping Rows' strings point into, for a packed
+	// dataset; it is nil otherwise.
+	release func() error
+}
+
+// activeDataset holds the dataset served by the API, or nil when none is loaded.
+var activeDataset atomic.Pointer[Dataset]
+
+// currentDataset returns the loaded dataset, or nil if the server runs in scrape-only mode.
+func currentDataset() *Dataset {
+	return activeDataset.Load()
+}
+
+// datasetColumns maps accepted CSV header names to the PostcodeResult field they fill.
+// The official datafile uses "Pcode" and "Locality"; friendlier names are accepted too.
+var datasetColumns = map[string]string{
+	"postcode":      "postcode",
+	"pcode":         "postcode",
+	"suburb":        "suburb",
+	"locality":      "suburb",
+	"state":         "state",
+	"region":        "state", // New Zealand datasets
+	"category":      "category",
+	"locality_type": "locality_type",
+	"type":          "locality_type",
+
+	"deliveryoffice":  "delivery_office",
+	"delivery_office": "delivery_office",
+	"bspnumber":       "bsp_number",
+	"bsp_number":      "bsp_number",
+	"bspname":         "bsp_name",
+	"bsp_name":        "bsp_name",
+
+	"latitude":  "latitude",
+	"lat":       "latitude",
+	"longitude": "longitude",
+	"long":      "longitude",
+	"lon":       "longitude",
+	"lng":       "longitude",
+}
+
+// loadDataset reads a postcode CSV file from disk, or maps a packed dataset
+// written by the pack command.
+func loadDataset(path string) (*Dataset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open dataset: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("read dataset: %w", err)
+	}
+
+	var dataset *Dataset
+	magic := make([]byte, len(packedMagic))
+	if _, readErr := io.ReadFull(f, magic); readErr == nil && isPacked(magic) {
+		dataset, err = loadPackedDataset(path)
+	} else if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("read dataset: %w", err)
+	} else {
+		dataset, err = readDataset(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dataset.sourceTime = info.ModTime()
+	return dataset, nil
+}
+
+// parseCoordinates parses a row's latitude and longitude columns. Rows without
+// coordinates, such as PO boxes in many datasets, leave both blank.
+func parseCoordinates(latitude, longitude string) (float64, float64, error) {
+	if latitude == "" && longitude == "" {
+		return 0, 0, nil
+	}
+	lat, err := strconv.ParseFloat(latitude, 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("invalid latitude %q", latitude)
+	}
+	lon, err := strconv.ParseFloat(longitude, 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("invalid longitude %q", longitude)
+	}
+	return lat, lon, nil
+}
+
+// readDataset parses postcode CSV data. The first record must be a header naming at
+// least the postcode, suburb (or locality) and state columns; other columns are ignored.
+func readDataset(r io.Reader) (*Dataset, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read dataset header: %w", err)
+	}
+
+	index := map[string]int{}
+	for i, name := range header {
+		if field, ok := datasetColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			if _, seen := index[field]; !seen {
+				index[field] = i
+			}
+		}
+	}
+	for _, required := range []string{"postcode", "suburb", "state"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("dataset header is missing a %s column", required)
+		}
+	}
+
+	cell := func(record []string, field string) string {
+		i, ok := index[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	rows := []PostcodeResult{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read dataset: %w", err)
+		}
+
+		row := PostcodeResult{
+			Postcode: cell(record, "postcode"),
+			Suburb:   cell(record, "suburb"),
+			State:    cell(record, "state"),
+			Category: cell(record, "category"),
+
+			DeliveryOffice: cell(record, "delivery_office"),
+			BSPNumber:      cell(record, "bsp_number"),
+			BSPName:        cell(record, "bsp_name"),
+		}
+		if row.Latitude, row.Longitude, err = parseCoordinates(cell(record, "latitude"), cell(record, "longitude")); err != nil {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("read dataset: line %d: %w", line, err)
+		}
+		// Datasets built from a gazetteer can say exactly what each row is.
+		row.LocalityType = normalizeLocalityType(cell(record, "locality_type"))
+		if row.LocalityType == "" {
+			row.LocalityType = classifyLocality(row)
+		}
+		// Skip blank lines and rows without the fields every result needs.
+		if row.Postcode == "" || row.Suburb == "" {
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	return newDataset(rows), nil
+}
+
+// newDataset wraps rows in a Dataset and builds its search index.
+func newDataset(rows []PostcodeResult) *Dataset {
+	return &Dataset{
+		Rows:      rows,
+		index:     buildSearchIndex(rows),
+		postcodes: buildRadixTree(rows, func(row PostcodeResult) string { return row.Postcode }),
+		suburbs:   buildRadixTree(rows, func(row PostcodeResult) string { return normalizeName(row.Suburb) }),
+		locations: buildSpatialIndex(rows),
+		known:     buildKnownKeys(rows),
+	}
+}
+
+// Search returns the rows matching every word of the keyword. Words are matched
+// against the suburb name, state and postcode, and each may be a prefix of the
+// indexed word, so "surfers paradise qld" and "rich vic" both work.
+func (d *Dataset) Search(keyword string) []PostcodeResult {
+	results := []PostcodeResult{}
+	if !d.mayMatch(keyword) {
+		return results
+	}
+	for _, i := range d.index.lookup(keyword) {
+		results = append(results, d.Rows[i])
+	}
+	return results
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRemoteDatasetSize bounds how much a remote dataset download may read.
+const maxRemoteDatasetSize = 64 << 20
+
+// remoteDataset fetches a centrally published dataset file over HTTP(S).
+// Downloads are verified against either a fixed SHA-256 or a checksum file
+// published next to the dataset, in `sha256sum` format.
+type remoteDataset struct {
+	url         string
+	checksumURL string
+	sha256      string
+	client      *http.Client
+
+	// lastSum is the checksum of the dataset currently loaded, used to skip
+	// re-indexing when a refresh downloads an unchanged file.
+	lastSum string
+	// mu serialises loads, which the refresh loop and admin reloads can start at once.
+	mu sync.Mutex
+}
+
+func newRemoteDataset(url, checksumURL, sha256 string) *remoteDataset {
+	return &remoteDataset{
+		url:         url,
+		checksumURL: checksumURL,
+		sha256:      strings.ToLower(strings.TrimSpace(sha256)),
+		client:      &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// load downloads, verifies and parses the dataset. It returns a nil dataset (and no
+// error) when the file is unchanged since the previous successful load.
+func (r *remoteDataset) load(ctx context.Context) (*Dataset, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, modified, err := r.download(ctx, r.url, maxRemoteDatasetSize)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+
+	expected := r.sha256
+	if r.checksumURL != "" {
+		raw, _, err := r.download(ctx, r.checksumURL, 4096)
+		if err != nil {
+			return nil, fmt.Errorf("fetch checksum: %w", err)
+		}
+		// sha256sum output is "<hex>  <filename>"; only the first field matters.
+		fields := strings.Fields(string(raw))
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("checksum file %s is empty", r.checksumURL)
+		}
+		expected = strings.ToLower(fields[0])
+	}
+	if expected != "" && expected != actual {
+		return nil, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", r.url, expected, actual)
+	}
+
+	if actual == r.lastSum {
+		return nil, nil
+	}
+
+	dataset, err := readDataset(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	dataset.sourceTime = modified
+	r.lastSum = actual
+	return dataset, nil
+}
+
+// download fetches url, returning its body and Last-Modified time; without the
+// header, the data is known to be current as of now.
+func (r *remoteDataset) download(ctx context.Context, url string, limit int64) ([]byte, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+
+	// Read one byte past the limit so an oversized file is an error, not a silent truncation.
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if int64(len(data)) > limit {
+		return nil, time.Time{}, fmt.Errorf("GET %s: response exceeds %d bytes", url, limit)
+	}
+	modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		modified = time.Now()
+	}
+	return data, modified, nil
+}
+
+// refreshLoop re-fetches the dataset every interval until ctx is cancelled, swapping
+// in new data when it changes. Failures are logged and the current dataset kept.
+func (r *remoteDataset) refreshLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		dataset, err := r.load(ctx)
+		switch {
+		case err != nil:
+			log.Printf("Warning: dataset refresh from %s failed, keeping current data: %v", r.url, err)
+		case dataset == nil:
+			log.Printf("Dataset at %s is unchanged", r.url)
+		default:
+			replaceDataset(dataset, "remote", "system")
+			log.Printf("Reloaded %d rows from %s", len(dataset.Rows), r.url)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// datasetDelta is the body of GET /dataset/changes: what changed in the dataset
+// since the client's copy, for apps that embed the dataset to sync cheaply.
+type datasetDelta struct {
+	// Version identifies the dataset the delta brings the client up to; it is
+	// the since of the next sync.
+	Version string `json:"version"`
+	// Full is set when Added lists every row, because the client's version is
+	// unknown or it gave none: the client should replace its copy.
+	Full    bool             `json:"full"`
+	Added   []PostcodeResult `json:"added"`
+	Removed []PostcodeResult `json:"removed"`
+}
+
+// versionOfRows fingerprints a set of rows by their content; see datasetVersion.
+func versionOfRows(rows []PostcodeResult) string {
+	fingerprints := make([]string, len(rows))
+	for i, row := range rows {
+		fingerprints[i] = rowFingerprint(row)
+	}
+	sort.Strings(fingerprints)
+	sum := sha256.Sum256([]byte(strings.Join(fingerprints, "")))
+	return hex.EncodeToString(sum[:6])
+}
+
+// versionTime returns the time from which the dataset had the given version,
+// looking through the states the history can rebuild: before the first recorded
+// change and after each one.
+func (h *rowHistory) versionTime(dataset *Dataset, version string) (time.Time, bool) {
+	if h == nil {
+		return time.Time{}, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	seen := map[time.Time]bool{}
+	var times []time.Time
+	for _, period := range h.periods {
+		for _, change := range []*time.Time{period.From, period.To} {
+			if change != nil && !seen[*change] {
+				seen[*change] = true
+				times = append(times, *change)
+			}
+		}
+	}
+	if len(times) == 0 {
+		return time.Time{}, false
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	// The state before the first change is dated just before it.
+	times = append([]time.Time{times[0].Add(-time.Nanosecond)}, times...)
+
+	for i := len(times) - 1; i >= 0; i-- {
+		if h.versionAt(dataset, times[i]) == version {
+			return times[i], true
+		}
+	}
+	return time.Time{}, false
+}
+
+// versionAt returns the version the dataset had at t. h.mu must be held.
+func (h *rowHistory) versionAt(dataset *Dataset, t time.Time) string {
+	var rows []PostcodeResult
+	for _, row := range dataset.Rows {
+		if period, ok := h.periods[rowKey(row)]; !ok || period.effectiveAt(t) {
+			rows = append(rows, row)
+		}
+	}
+	for _, period := range h.periods {
+		if period.To != nil && period.effectiveAt(t) {
+			rows = append(rows, period.Row)
+		}
+	}
+	return versionOfRows(rows)
+}
+
+// delta returns the rows added to and removed from the dataset since t. A row
+// removed and re-added since, or modified since, counts as added only, which
+// clients applying the delta as upserts take in their stride.
+func (h *rowHistory) delta(dataset *Dataset, t time.Time) (added, removed []PostcodeResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	added, removed = []PostcodeResult{}, []PostcodeResult{}
+	upserted := map[string]bool{}
+	for _, row := range dataset.Rows {
+		if period, ok := h.periods[rowKey(row)]; ok && !period.effectiveAt(t) {
+			added = append(added, row)
+			upserted[rowKey(row)] = true
+		}
+	}
+	for _, period := range h.periods {
+		if period.To != nil && period.effectiveAt(t) && !upserted[rowKey(period.Row)] {
+			row := period.Row
+			row.EffectiveTo = period.To.Format(time.DateOnly)
+			removed = append(removed, row)
+		}
+	}
+	sortRows(added)
+	sortRows(removed)
+	return added, removed
+}
+
+// parseSince reads a ?since= time: an RFC 3339 time or a date, meaning the start
+// of that day in UTC.
+func parseSince(raw string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.UTC(), true
+	}
+	if t, err := time.Parse(time.DateOnly, raw); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// datasetChangesHandler handles GET /dataset/changes?since=<version|time>,
+// returning only the rows added and removed since the client's copy was current.
+// since is the version of an earlier response (or of GET /status), or a time.
+// Without since, or when the version is unknown, the time is before the history
+// started, or the server keeps no history, it returns every row with full set.
+// A -read-only replica doesn't record changes, so it always does.
+func datasetChangesHandler(w http.ResponseWriter, r *http.Request) {
+	dataset := requireDataset(w)
+	if dataset == nil {
+		return
+	}
+	delta := datasetDelta{Version: datasetVersion(dataset), Added: []PostcodeResult{}, Removed: []PostcodeResult{}}
+	since := strings.TrimSpace(r.URL.Query().Get("since"))
+
+	history := datasetHistory
+	if readOnly {
+		history = nil
+	}
+	t, ok := parseSince(since)
+	switch {
+	case since == delta.Version:
+		writeJSON(w, http.StatusOK, delta)
+		return
+	case ok:
+		ok = history.covers(t)
+	case since != "":
+		t, ok = history.versionTime(dataset, since)
+	}
+
+	if ok {
+		delta.Added, delta.Removed = history.delta(dataset, t)
+	} else {
+		delta.Full = true
+		delta.Added = append(delta.Added, dataset.Rows...)
+		sortRows(delta.Added)
+	}
+	delta.Added = stampDatasetVerified(delta.Added)
+	writeJSON(w, http.StatusOK, delta)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DeliveryDetail is the parcel-routing information for one locality in a postcode.
+type DeliveryDetail struct {
+	Suburb         string `json:"suburb"`
+	State          string `json:"state"`
+	DeliveryOffice string `json:"delivery_office"`
+	BSPNumber      string `json:"bsp_number"`
+	BSPName        string `json:"bsp_name"`
+	// Deliverable says whether the locality has street delivery points, when the
+	// server has delivery point data.
+	Deliverable *bool `json:"deliverable,omitempty"`
+}
+
+// PostcodeDelivery is the response of GET /postcode/{code}/delivery.
+type PostcodeDelivery struct {
+	Postcode string           `json:"postcode"`
+	Delivery []DeliveryDetail `json:"delivery"`
+}
+
+// RowsForPostcode returns every row with the given postcode.
+func (d *Dataset) RowsForPostcode(postcode string) []PostcodeResult {
+	rows := []PostcodeResult{}
+	if !d.mayHavePostcode(postcode) {
+		return rows
+	}
+	for _, row := range d.Rows {
+		if row.Postcode == postcode {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// deliveryHandler handles GET /postcode/{code}/delivery, listing the delivery office
+// and barcode sort plan (BSP) of each locality in the postcode. The details come
+// from the official datafile's DeliveryOffice, BSPnumber and BSPname columns.
+func deliveryHandler(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimSpace(r.PathValue("code"))
+
+	dataset := requireDataset(w)
+	if dataset == nil {
+		return
+	}
+
+	rows := dataset.RowsForPostcode(code)
+	if len(rows) == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Postcode %s is not in the dataset", code))
+		return
+	}
+
+	response := PostcodeDelivery{Postcode: code, Delivery: []DeliveryDetail{}}
+	for _, row := range rows {
+		response.Delivery = append(response.Delivery, DeliveryDetail{
+			Suburb:         row.Suburb,
+			State:          row.State,
+			DeliveryOffice: row.DeliveryOffice,
+			BSPNumber:      row.BSPNumber,
+			BSPName:        row.BSPName,
+			Deliverable:    activeDeliveryPoints.deliverable(row.Postcode, row.Suburb, row.State),
+		})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// deliveryPoints counts the street-addressed delivery points (DPIDs) of each
+// locality, from delivery point data such as the Postal Address File. A locality
+// with none has no deliverable street addresses, whatever the postcode data says.
+type deliveryPoints struct {
+	counts map[string]int // deliveryPointKey -> street delivery points
+}
+
+// activeDeliveryPoints is the loaded data, or nil when -delivery-points is not set.
+var activeDeliveryPoints *deliveryPoints
+
+// deliveryPointKey identifies a postcode, a locality within it, or a locality in a
+// state. Empty parts widen the key, so counts can be looked up without them.
+func deliveryPointKey(postcode, suburb, state string) string {
+	return postcode + "|" + canonicalSuburb(suburb) + "|" + strings.ToUpper(strings.TrimSpace(state))
+}
+
+// loadDeliveryPoints reads a delivery point CSV with postcode, locality (or suburb)
+// and state columns. With a street_points or delivery_points column each row gives
+// a locality's count; otherwise each row is one delivery point, as in a raw DPID
+// extract, and rows with an empty street_name column are postal deliveries rather
+// than street addresses.
+func loadDeliveryPoints(path string) (*deliveryPoints, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open delivery points: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read delivery points header: %w", err)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if field, ok := datasetColumns[name]; ok {
+			name = field
+		}
+		if _, seen := index[name]; !seen {
+			index[name] = i
+		}
+	}
+	for _, required := range []string{"postcode", "suburb", "state"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("delivery points header is missing a %s column", required)
+		}
+	}
+	countColumn := ""
+	for _, name := range []string{"street_points", "delivery_points"} {
+		if _, ok := index[name]; ok {
+			countColumn = name
+			break
+		}
+	}
+
+	cell := func(record []string, column string) (string, bool) {
+		i, ok := index[column]
+		if !ok || i >= len(record) {
+			return "", ok
+		}
+		return strings.TrimSpace(record[i]), true
+	}
+
+	points := &deliveryPoints{counts: map[string]int{}}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read delivery points: %w", err)
+		}
+		postcode, _ := cell(record, "postcode")
+		suburb, _ := cell(record, "suburb")
+		state, _ := cell(record, "state")
+		if postcode == "" || suburb == "" {
+			continue
+		}
+
+		count := 1
+		if countColumn != "" {
+			raw, _ := cell(record, countColumn)
+			if count, err = strconv.Atoi(raw); err != nil || count < 0 {
+				line, _ := reader.FieldPos(0)
+				return nil, fmt.Errorf("read delivery points: line %d: invalid %s %q", line, countColumn, raw)
+			}
+		} else if street, ok := cell(record, "street_name"); ok && street == "" {
+			count = 0
+		}
+
+		for _, key := range []string{
+			deliveryPointKey(postcode, suburb, state),
+			deliveryPointKey(postcode, suburb, ""),
+			deliveryPointKey(postcode, "", ""),
+		} {
+			points.counts[key] += count
+		}
+	}
+	return points, nil
+}
+
+// streetPoints returns the number of street delivery points in a postcode, or in
+// a locality of it when suburb is given, and whether delivery point data is loaded.
+// An empty state counts the locality in every state.
+func (p *deliveryPoints) streetPoints(postcode, suburb, state string) (int, bool) {
+	if p == nil {
+		return 0, false
+	}
+	if suburb == "" {
+		state = ""
+	}
+	return p.counts[deliveryPointKey(postcode, suburb, state)], true
+}
+
+// deliverable reports whether a locality has street delivery points, or nil when
+// no delivery point data is loaded.
+func (p *deliveryPoints) deliverable(postcode, suburb, state string) *bool {
+	count, ok := p.streetPoints(postcode, suburb, state)
+	if !ok {
+		return nil
+	}
+	deliverable := count > 0
+	return &deliverable
+}
+
+// Deliverability is the response of GET /deliverable.
+type Deliverability struct {
+	Postcode             string `json:"postcode"`
+	Suburb               string `json:"suburb,omitempty"`
+	State                string `json:"state,omitempty"`
+	Deliverable          bool   `json:"deliverable"`
+	StreetDeliveryPoints int    `json:"street_delivery_points"`
+}
+
+// deliverableHandler handles GET /deliverable?postcode=3000&suburb=Melbourne&state=VIC,
+// reporting whether the postcode, or the locality within it, has any deliverable
+// street addresses, so carriers can turn away box-only or unpopulated combinations.
+func deliverableHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	postcode := strings.TrimSpace(query.Get("postcode"))
+	if !fourDigits.MatchString(postcode) {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid 'postcode' parameter: expected four digits")
+		return
+	}
+	if activeDeliveryPoints == nil {
+		writeError(w, http.StatusServiceUnavailable, codeDatasetUnavailable, "This endpoint requires delivery point data; start the server with -delivery-points")
+		return
+	}
+
+	suburb, state := strings.TrimSpace(query.Get("suburb")), strings.ToUpper(strings.TrimSpace(query.Get("state")))
+	count, _ := activeDeliveryPoints.streetPoints(postcode, suburb, state)
+	writeJSON(w, http.StatusOK, Deliverability{
+		Postcode:             postcode,
+		Suburb:               suburb,
+		State:                state,
+		Deliverable:          count > 0,
+		StreetDeliveryPoints: count,
+	})
+}
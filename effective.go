@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rowHistoryBucket holds the effective dates of rows added or removed by a
+	// dataset refresh, keyed by rowKey. Rows loaded before history was kept have
+	// no entry and count as effective from the beginning. The versions a
+	// refresh modified are kept under their rowKey, "@" and the time they were
+	// replaced.
+	rowHistoryBucket = "row_history"
+
+	// datasetRowsBucket holds the rows of the last dataset seen, under
+	// datasetRowsKey, so a refresh, or a restart with a new file, can be diffed
+	// against it, and under baselineKey the time history started being kept.
+	datasetRowsBucket = "dataset_rows"
+	datasetRowsKey    = "current"
+	baselineKey       = "baseline"
+
+	// maxAsOfDatasets is how many past datasets ?as_of= keeps built at once.
+	maxAsOfDatasets = 8
+)
+
+// rowPeriod is when a row was in the dataset. A nil From means since before
+// history was kept; a nil To means it is still in the dataset.
+type rowPeriod struct {
+	Row  PostcodeResult `json:"row"`
+	From *time.Time     `json:"effective_from,omitempty"`
+	To   *time.Time     `json:"effective_to,omitempty"`
+}
+
+// effectiveAt reports whether the row was in the dataset at t.
+func (p rowPeriod) effectiveAt(t time.Time) bool {
+	return (p.From == nil || !p.From.After(t)) && (p.To == nil || p.To.After(t))
+}
+
+// rowKey identifies a row across datasets by its postcode, suburb and state.
+func rowKey(row PostcodeResult) string {
+	return row.Postcode + "|" + strings.ToUpper(row.Suburb) + "|" + strings.ToUpper(row.State)
+}
+
+// rowHistory soft-deletes rows a refresh removes, keeping them with the date they
+// stopped being effective, and dates the rows it adds, so addresses recorded in
+// the past can be checked against the data that was current at the time.
+type rowHistory struct {
+	store Store
+
+	mu      sync.Mutex
+	periods map[string]rowPeriod
+	// baseline is when history started being kept; changes before it are
+	// unknown. It is zero until the first dataset is tracked.
+	baseline time.Time
+	// built caches the datasets of recent ?as_of= queries by the current dataset
+	// and the last change at or before the queried time.
+	built map[asOfKey]*Dataset
+}
+
+type asOfKey struct {
+	dataset *Dataset
+	change  time.Time
+}
+
+// datasetHistory is the configured history; main points it at the store.
+var datasetHistory *rowHistory
+
+// newRowHistory loads the row history kept in store.
+func newRowHistory(store Store) (*rowHistory, error) {
+	h := &rowHistory{store: store, periods: map[string]rowPeriod{}, built: map[asOfKey]*Dataset{}}
+	err := store.ForEach(rowHistoryBucket, func(key string, raw []byte) error {
+		var period rowPeriod
+		if err := json.Unmarshal(raw, &period); err != nil {
+			return fmt.Errorf("corrupt row history %s: %w", key, err)
+		}
+		h.periods[key] = period
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	raw, ok, err := store.Get(datasetRowsBucket, baselineKey)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if h.baseline, err = time.Parse(time.RFC3339Nano, string(raw)); err != nil {
+			return nil, fmt.Errorf("corrupt row history baseline: %w", err)
+		}
+	}
+	return h, nil
+}
+
+// track diffs dataset against the last one seen and records the rows it removes,
+// adds and modifies as of now. The first dataset seen only becomes the baseline.
+func (h *rowHistory) track(dataset *Dataset) error {
+	if h == nil || dataset == nil || readOnly {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var previous map[string]PostcodeResult
+	raw, ok, err := h.store.Get(datasetRowsBucket, datasetRowsKey)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := json.Unmarshal(raw, &previous); err != nil {
+			return fmt.Errorf("corrupt dataset rows: %w", err)
+		}
+	}
+	current := make(map[string]PostcodeResult, len(dataset.Rows))
+	for _, row := range dataset.Rows {
+		current[rowKey(row)] = row
+	}
+
+	now := time.Now().UTC()
+	if h.baseline.IsZero() {
+		// Stores from before the baseline was recorded get one now: the changes
+		// they hold are kept, but earlier times can't be relied on.
+		if err := h.store.Put(datasetRowsBucket, baselineKey, []byte(now.Format(time.RFC3339Nano))); err != nil {
+			return err
+		}
+		h.baseline = now
+	}
+	if previous != nil {
+		for key, row := range previous {
+			updated, kept := current[key]
+			switch {
+			case !kept:
+				period := h.periods[key]
+				period.Row, period.To = row, &now
+				if err := h.put(key, period); err != nil {
+					return err
+				}
+			case rowFingerprint(updated) != rowFingerprint(row):
+				// The old version ends now under a key of its own; the new one
+				// starts a period under the row's key, as an added row would.
+				period := h.periods[key]
+				period.Row, period.To = row, &now
+				if err := h.put(key+"@"+now.Format(time.RFC3339Nano), period); err != nil {
+					return err
+				}
+				if err := h.put(key, rowPeriod{Row: detachRow(updated), From: &now}); err != nil {
+					return err
+				}
+			}
+		}
+		for key, row := range current {
+			if _, existed := previous[key]; !existed {
+				// A row that comes back starts a new period; its earlier one is lost.
+				if err := h.put(key, rowPeriod{Row: detachRow(row), From: &now}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	raw, err = json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	clear(h.built)
+	return h.store.Put(datasetRowsBucket, datasetRowsKey, raw)
+}
+
+func (h *rowHistory) put(key string, period rowPeriod) error {
+	raw, err := json.Marshal(period)
+	if err != nil {
+		return err
+	}
+	if err := h.store.Put(rowHistoryBucket, key, raw); err != nil {
+		return err
+	}
+	h.periods[key] = period
+	return nil
+}
+
+// rowFingerprint identifies a row's content, so a refresh that changes any of
+// its fields, not only its postcode, suburb or state, is seen as a change.
+func rowFingerprint(row PostcodeResult) string {
+	raw, _ := json.Marshal(row)
+	sum := sha256.Sum256(raw)
+	return string(sum[:])
+}
+
+// covers reports whether the history knows every change since t: t is no
+// earlier than when it started being kept. It is safe to call on a nil history.
+func (h *rowHistory) covers(t time.Time) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.baseline.IsZero() && !t.Before(h.baseline)
+}
+
+// detachRow copies row's strings, so a row kept in the history doesn't hold on to
+// the mapping of a packed dataset a reload will release.
+func detachRow(row PostcodeResult) PostcodeResult {
+	for _, field := range packedRowFields(&row) {
+		*field = strings.Clone(*field)
+	}
+	return row
+}
+
+// forget drops the ?as_of= datasets built from dataset, once it has been replaced.
+func (h *rowHistory) forget(dataset *Dataset) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key := range h.built {
+		if key.dataset == dataset {
+			delete(h.built, key)
+		}
+	}
+}
+
+// trackDataset records dataset's changes in the history, logging failures: a
+// history that can't be written must not stop a refresh.
+func trackDataset(dataset *Dataset) {
+	if err := datasetHistory.track(dataset); err != nil {
+		log.Printf("Warning: failed to record dataset row history: %v", err)
+	}
+}
+
+// asOf returns the dataset as it was at t: without the rows added since, and with
+// the rows removed since brought back with their EffectiveTo date. It returns
+// dataset itself when nothing has changed since t.
+func (h *rowHistory) asOf(dataset *Dataset, t time.Time) *Dataset {
+	if h == nil {
+		return dataset
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Two times with the same last change before them see the same rows.
+	var last time.Time
+	changedSince := false
+	for _, period := range h.periods {
+		for _, change := range []*time.Time{period.From, period.To} {
+			switch {
+			case change == nil:
+			case change.After(t):
+				changedSince = true
+			case change.After(last):
+				last = *change
+			}
+		}
+	}
+	if !changedSince {
+		return dataset
+	}
+	key := asOfKey{dataset: dataset, change: last}
+	if built, ok := h.built[key]; ok {
+		return built
+	}
+
+	rows := make([]PostcodeResult, 0, len(dataset.Rows))
+	for _, row := range dataset.Rows {
+		if period, ok := h.periods[rowKey(row)]; !ok || period.effectiveAt(t) {
+			rows = append(rows, row)
+		}
+	}
+	for _, period := range h.periods {
+		if period.To != nil && period.effectiveAt(t) {
+			row := period.Row
+			row.EffectiveTo = period.To.Format(time.DateOnly)
+			rows = append(rows, row)
+		}
+	}
+	built := newDataset(rows)
+	if len(h.built) >= maxAsOfDatasets {
+		clear(h.built)
+	}
+	h.built[key] = built
+	return built
+}
+
+// parseAsOf reads the optional as_of query parameter: a date, meaning the start
+// of that day in UTC, or an RFC 3339 time. The zero time means it was not given.
+func parseAsOf(query url.Values) (time.Time, error) {
+	raw := strings.TrimSpace(query.Get("as_of"))
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid 'as_of' parameter: expected a date such as 2024-01-31 or an RFC 3339 time")
+	}
+	return t.UTC(), nil
+}
+
+// datasetAsOf returns dataset as it was at the request's ?as_of= time, or dataset
+// itself without one. It writes a 400 and returns false when as_of is invalid or
+// there is no local dataset to date.
+func datasetAsOf(w http.ResponseWriter, query url.Values, dataset *Dataset) (*Dataset, bool) {
+	asOf, err := parseAsOf(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return nil, false
+	}
+	if asOf.IsZero() {
+		return dataset, true
+	}
+	if dataset == nil {
+		writeError(w, http.StatusBadRequest, codeDatasetUnavailable, "as_of requires a local dataset; start the server with -dataset")
+		return nil, false
+	}
+	return datasetHistory.asOf(dataset, asOf), true
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// errorCode is a stable, machine-readable identifier included in every error body,
+// so clients can branch on it instead of matching the English message text.
+type errorCode string
+
+const (
+	codeKeywordMissing     errorCode = "KEYWORD_MISSING"
+	codeKeywordInvalid     errorCode = "KEYWORD_INVALID"
+	codeParameterInvalid   errorCode = "PARAMETER_INVALID"
+	codeBodyInvalid        errorCode = "BODY_INVALID"
+	codeBodyTooLarge       errorCode = "BODY_TOO_LARGE"
+	codeBatchTooLarge      errorCode = "BATCH_TOO_LARGE"
+	codeNotFound           errorCode = "NOT_FOUND"
+	codeRateLimited        errorCode = "RATE_LIMITED"
+	codePatternTimeout     errorCode = "PATTERN_TIMEOUT"
+	codeDatasetUnavailable errorCode = "DATASET_UNAVAILABLE"
+	codeUpstreamTimeout    errorCode = "UPSTREAM_TIMEOUT"
+	codeUpstreamError      errorCode = "UPSTREAM_ERROR"
+	codeRobotsDisallowed   errorCode = "ROBOTS_DISALLOWED"
+	codeRequestTimeout     errorCode = "REQUEST_TIMEOUT"
+	codeNotAcceptable      errorCode = "NOT_ACCEPTABLE"
+	codeInternalError      errorCode = "INTERNAL_ERROR"
+	codeAPIKeyMissing      errorCode = "API_KEY_MISSING"
+	codeAPIKeyInvalid      errorCode = "API_KEY_INVALID"
+	codeQuotaExceeded      errorCode = "QUOTA_EXCEEDED"
+	codeTokenMissing       errorCode = "TOKEN_MISSING"
+	codeTokenInvalid       errorCode = "TOKEN_INVALID"
+	codeInsufficientScope  errorCode = "INSUFFICIENT_SCOPE"
+	codeIPForbidden        errorCode = "IP_FORBIDDEN"
+	codeZoneExists         errorCode = "ZONE_EXISTS"
+	codeServerDraining     errorCode = "SERVER_DRAINING"
+	codeAdminTokenInvalid  errorCode = "ADMIN_TOKEN_INVALID"
+	codeReadOnly           errorCode = "READ_ONLY"
+	codeScrapeQueueFull    errorCode = "SCRAPE_QUEUE_FULL"
+	codeCanaryFailing      errorCode = "CANARY_FAILING"
+	codeClientTimeout      errorCode = "CLIENT_TIMEOUT"
+)
+
+// catalogEntry documents one error code for the GET /errors catalog.
+type catalogEntry struct {
+	Code        errorCode `json:"code"`
+	Status      int       `json:"status"`
+	Description string    `json:"description"`
+}
+
+// errorCatalog lists every error code the API can return. Codes are never renamed
+// or reused; new failure modes get new codes.
+var errorCatalog = []catalogEntry{
+	{codeKeywordMissing, http.StatusBadRequest, "The 'keyword' query parameter is missing."},
+	{codeKeywordInvalid, http.StatusBadRequest, "The keyword is too long or is not a valid pattern."},
+	{codeParameterInvalid, http.StatusBadRequest, "A query parameter is missing or has an invalid value."},
+	{codeBodyInvalid, http.StatusBadRequest, "The request body is not valid JSON of the expected shape."},
+	{codeBodyTooLarge, http.StatusRequestEntityTooLarge, "The request body exceeds the configured size limit."},
+	{codeBatchTooLarge, http.StatusBadRequest, "A batch request contains more items than allowed."},
+	{codeNotFound, http.StatusNotFound, "Nothing matched the request. Scrapes with no results report status 500."},
+	{codeRateLimited, http.StatusTooManyRequests, "The upstream scrape budget is exhausted; retry after the Retry-After delay."},
+	{codePatternTimeout, http.StatusBadRequest, "A regex or wildcard pattern took too long to evaluate."},
+	{codeDatasetUnavailable, http.StatusServiceUnavailable, "The request needs a local dataset, but none is loaded."},
+	{codeUpstreamTimeout, http.StatusInternalServerError, "The upstream site did not respond in time."},
+	{codeUpstreamError, http.StatusInternalServerError, "The upstream site returned an error or an unparseable page."},
+	{codeRobotsDisallowed, http.StatusInternalServerError, "The upstream robots.txt disallows fetching the page this request needs."},
+	{codeRequestTimeout, http.StatusServiceUnavailable, "The request exceeded its handler timeout."},
+	{codeNotAcceptable, http.StatusNotAcceptable, "The response is not available in the format requested by the Accept header."},
+	{codeInternalError, http.StatusInternalServerError, "An unexpected server error occurred."},
+	{codeAPIKeyMissing, http.StatusUnauthorized, "API keys are enabled and the request has no X-API-Key header."},
+	{codeAPIKeyInvalid, http.StatusUnauthorized, "The X-API-Key header does not match a configured key."},
+	{codeQuotaExceeded, http.StatusTooManyRequests, "The API key's daily or monthly quota is used up; retry after the Retry-After delay."},
+	{codeTokenMissing, http.StatusUnauthorized, "JWT authentication is enabled and the request has no bearer token."},
+	{codeTokenInvalid, http.StatusUnauthorized, "The bearer token is malformed, expired, or not signed by the configured issuer."},
+	{codeInsufficientScope, http.StatusForbidden, "The bearer token is valid but lacks a required scope."},
+	{codeIPForbidden, http.StatusForbidden, "The client address is outside the allow list or inside the deny list."},
+	{codeZoneExists, http.StatusConflict, "A zone with the requested ID already exists."},
+	{codeServerDraining, http.StatusServiceUnavailable, "The server is draining before a restart; /healthz reports it so load balancers stop routing to it."},
+	{codeAdminTokenInvalid, http.StatusUnauthorized, "ADMIN_TOKEN is set and the admin request has no matching bearer token."},
+	{codeReadOnly, http.StatusForbidden, "The server runs with -read-only and does not accept requests that change data."},
+	{codeScrapeQueueFull, http.StatusServiceUnavailable, "Too many identical searches are waiting on an upstream scrape of the same keyword; retry after the Retry-After delay."},
+	{codeCanaryFailing, http.StatusServiceUnavailable, "Known-good canary lookups return unexpected results, so /healthz reports the server unready."},
+	{codeClientTimeout, http.StatusGatewayTimeout, "The deadline the client set with X-Timeout-Ms passed before the upstream answered, and no cached result was available."},
+}
+
+// upstreamErrorCode classifies a scrape failure as a timeout, a page robots.txt
+// disallows, or a general upstream error.
+func upstreamErrorCode(err error) errorCode {
+	if errors.Is(err, errRobotsDisallowed) {
+		return codeRobotsDisallowed
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return codeUpstreamTimeout
+	}
+	return codeUpstreamError
+}
+
+// errorsHandler handles GET /errors, publishing the error catalog.
+func errorsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, errorCatalog)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFeedEntries = 20
+	maxFeedEntries     = 100
+
+	feedTitle = "Australian postcode dataset changes"
+)
+
+// datasetChange is one refresh's changes to the dataset's rows.
+type datasetChange struct {
+	Time    time.Time
+	Added   []PostcodeResult
+	Removed []PostcodeResult
+}
+
+// changes groups the history's row periods into the refreshes that started or
+// ended them, newest first. Rows within a change are sorted by postcode.
+func (h *rowHistory) changes() []datasetChange {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	byTime := map[time.Time]*datasetChange{}
+	change := func(t time.Time) *datasetChange {
+		c := byTime[t]
+		if c == nil {
+			c = &datasetChange{Time: t}
+			byTime[t] = c
+		}
+		return c
+	}
+	for _, period := range h.periods {
+		if period.From != nil {
+			c := change(*period.From)
+			c.Added = append(c.Added, period.Row)
+		}
+		if period.To != nil {
+			c := change(*period.To)
+			c.Removed = append(c.Removed, period.Row)
+		}
+	}
+
+	changes := make([]datasetChange, 0, len(byTime))
+	for _, c := range byTime {
+		sortRows(c.Added)
+		sortRows(c.Removed)
+		changes = append(changes, *c)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Time.After(changes[j].Time) })
+	return changes
+}
+
+// sortRows orders rows by postcode, then suburb.
+func sortRows(rows []PostcodeResult) {
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Postcode != rows[j].Postcode {
+			return rows[i].Postcode < rows[j].Postcode
+		}
+		return rows[i].Suburb < rows[j].Suburb
+	})
+}
+
+// id is a stable identifier for the change, for feed readers to spot new entries.
+func (c datasetChange) id() string {
+	return "urn:postcode-check:dataset-change:" + c.Time.Format(time.RFC3339Nano)
+}
+
+func (c datasetChange) title() string {
+	return fmt.Sprintf("Dataset refresh: %d added, %d removed", len(c.Added), len(c.Removed))
+}
+
+// text lists the change's rows, one per line.
+func (c datasetChange) text() string {
+	var b strings.Builder
+	for _, section := range []struct {
+		verb string
+		rows []PostcodeResult
+	}{{"Added", c.Added}, {"Removed", c.Removed}} {
+		for _, row := range section.rows {
+			fmt.Fprintf(&b, "%s: %s %s %s\n", section.verb, row.Postcode, row.Suburb, row.State)
+		}
+	}
+	return b.String()
+}
+
+// feedURL returns the absolute URL of path on the host r was sent to.
+func feedURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
+
+// feedChanges returns the changes a feed request lists, or writes a 400 and
+// returns false when ?limit= is invalid.
+func feedChanges(w http.ResponseWriter, r *http.Request) ([]datasetChange, bool) {
+	limit, err := parseLimitParam(r.URL.Query(), defaultFeedEntries, maxFeedEntries)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return nil, false
+	}
+	changes := datasetHistory.changes()
+	return changes[:min(len(changes), limit)], true
+}
+
+// atomFeed is an Atom (RFC 4287) feed document.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// changesAtomHandler handles GET /dataset/changes.atom?limit=20, publishing the
+// rows each dataset refresh added and removed as an Atom feed, so downstream
+// teams can follow changes with standard feed tooling instead of webhooks.
+func changesAtomHandler(w http.ResponseWriter, r *http.Request) {
+	changes, ok := feedChanges(w, r)
+	if !ok {
+		return
+	}
+	self := feedURL(r, "/dataset/changes.atom")
+	feed := atomFeed{
+		ID:      self,
+		Title:   feedTitle,
+		Author:  atomAuthor{Name: "postcode_scraper"},
+		Links:   []atomLink{{Rel: "self", Type: "application/atom+xml", Href: self}},
+		Entries: make([]atomEntry, len(changes)),
+	}
+	// A feed without entries is dated to the epoch, as Atom requires a date.
+	updated := time.Unix(0, 0).UTC()
+	for i, c := range changes {
+		feed.Entries[i] = atomEntry{
+			ID:      c.id(),
+			Title:   c.title(),
+			Updated: c.Time.Format(time.RFC3339),
+			Content: atomContent{Type: "text", Body: c.text()},
+		}
+		if c.Time.After(updated) {
+			updated = c.Time
+		}
+	}
+	feed.Updated = updated.Format(time.RFC3339)
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		log.Printf("Warning: failed to write the changes feed: %v", err)
+	}
+}
+
+// jsonFeedItem is an item of a JSON Feed 1.1 document. The changed rows are
+// attached under the "_changes" extension for clients that want them structured.
+type jsonFeedItem struct {
+	ID            string         `json:"id"`
+	Title         string         `json:"title"`
+	ContentText   string         `json:"content_text"`
+	DatePublished time.Time      `json:"date_published"`
+	Changes       map[string]any `json:"_changes"`
+}
+
+// changesJSONFeedHandler handles GET /dataset/changes.json?limit=20, the JSON Feed
+// version of /dataset/changes.atom.
+func changesJSONFeedHandler(w http.ResponseWriter, r *http.Request) {
+	changes, ok := feedChanges(w, r)
+	if !ok {
+		return
+	}
+	items := make([]jsonFeedItem, len(changes))
+	for i, c := range changes {
+		items[i] = jsonFeedItem{
+			ID:            c.id(),
+			Title:         c.title(),
+			ContentText:   c.text(),
+			DatePublished: c.Time,
+			Changes:       map[string]any{"added": nonNil(c.Added), "removed": nonNil(c.Removed)},
+		}
+	}
+	feed := map[string]any{
+		"version":  "https://jsonfeed.org/version/1.1",
+		"title":    feedTitle,
+		"feed_url": feedURL(r, "/dataset/changes.json"),
+		"items":    items,
+	}
+
+	w.Header().Set("Content-Type", "application/feed+json")
+	if err := json.NewEncoder(w).Encode(feed); err != nil {
+		log.Printf("Warning: failed to write the changes feed: %v", err)
+	}
+}
+
+// nonNil returns rows, or an empty slice for nil, so it encodes as [] not null.
+func nonNil(rows []PostcodeResult) []PostcodeResult {
+	if rows == nil {
+		return []PostcodeResult{}
+	}
+	return rows
+}
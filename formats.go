@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// responseFormat is the body encoding negotiated from the request's Accept header,
+// or from ?format=jsonapi for JSON:API documents.
+type responseFormat int
+
+const (
+	formatJSON responseFormat = iota
+	formatProtobuf
+	formatMsgpack
+	formatJSONAPI
+	formatHAL
+)
+
+// protobufContentType is the media type for protobuf responses; the message
+// definitions are published in proto/postcode.proto.
+const protobufContentType = "application/x-protobuf"
+
+// formatWriter carries the negotiated format down to writeJSON and writeError,
+// along with the request URL that JSON:API documents link back to.
+type formatWriter struct {
+	http.ResponseWriter
+	format  responseFormat
+	url     *url.URL
+	pretty  bool // ?pretty=true: indent JSON bodies
+	compact bool // ?compact=true: results as positional arrays
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (fw *formatWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
+
+// negotiatedFormat returns the format negotiated for w, defaulting to JSON.
+func negotiatedFormat(w http.ResponseWriter) responseFormat {
+	if fw, ok := w.(*formatWriter); ok {
+		return fw.format
+	}
+	return formatJSON
+}
+
+// prettyJSON reports whether the client asked for indented JSON with ?pretty=true.
+func prettyJSON(w http.ResponseWriter) bool {
+	fw, ok := w.(*formatWriter)
+	return ok && fw.pretty
+}
+
+// requestURL returns the request URL recorded by withNegotiation, if any.
+func requestURL(w http.ResponseWriter) *url.URL {
+	if fw, ok := w.(*formatWriter); ok {
+		return fw.url
+	}
+	return nil
+}
+
+// marshalLinked encodes v as JSON with HTML escaping off, so the query strings in
+// JSON:API and HAL links stay readable. pretty indents it.
+func marshalLinked(v any, pretty bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if pretty {
+		enc.SetIndent("", "    ")
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parseAccept picks a response format from an Accept header. JSON wins unless the
+// client explicitly lists a protobuf or MessagePack media type with a non-zero
+// quality; the first one listed is used.
+func parseAccept(accept string) responseFormat {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if params["q"] == "0" {
+			continue
+		}
+		switch mediaType {
+		case protobufContentType, "application/protobuf":
+			return formatProtobuf
+		case msgpackContentType, "application/x-msgpack":
+			return formatMsgpack
+		case jsonapiContentType:
+			return formatJSONAPI
+		case halContentType:
+			return formatHAL
+		}
+	}
+	return formatJSON
+}
+
+// withNegotiation records the response format requested by the client. An explicit
+// ?format=jsonapi takes precedence over the Accept header.
+func withNegotiation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := parseAccept(r.Header.Get("Accept"))
+		if strings.EqualFold(r.URL.Query().Get("format"), "jsonapi") {
+			format = formatJSONAPI
+		}
+		pretty, _ := strconv.ParseBool(r.URL.Query().Get("pretty"))
+		compact, _ := strconv.ParseBool(r.URL.Query().Get("compact"))
+		next.ServeHTTP(&formatWriter{ResponseWriter: w, format: format, url: r.URL, pretty: pretty, compact: compact}, r)
+	})
+}
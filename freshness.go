@@ -0,0 +1,46 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// datasetVerifiedAt is when the served dataset's data was current at its source,
+// or nil when that isn't known.
+var datasetVerifiedAt atomic.Pointer[time.Time]
+
+// markDatasetVerified records the source time of the dataset being served; see
+// Dataset.sourceTime. Loading a file doesn't make its data any newer, so the
+// time the server read it plays no part.
+func markDatasetVerified(dataset *Dataset) {
+	if dataset == nil || dataset.sourceTime.IsZero() {
+		datasetVerifiedAt.Store(nil)
+		return
+	}
+	at := dataset.sourceTime.UTC()
+	datasetVerifiedAt.Store(&at)
+}
+
+// formatVerifiedAt renders a LastVerifiedAt time.
+func formatVerifiedAt(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// stampDatasetVerified returns a copy of dataset results with LastVerifiedAt set
+// to the dataset's source time, leaving the dataset's own rows untouched. Rows
+// with a time of their own, such as crawled rows stamped when their page was
+// scraped, keep it.
+func stampDatasetVerified(results []PostcodeResult) []PostcodeResult {
+	verified := datasetVerifiedAt.Load()
+	if verified == nil {
+		return results
+	}
+	stamped := make([]PostcodeResult, len(results))
+	for i, result := range results {
+		if result.LastVerifiedAt == "" {
+			result.LastVerifiedAt = formatVerifiedAt(*verified)
+		}
+		stamped[i] = result
+	}
+	return stamped
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// geoIPRange maps one network to the state its addresses are in.
+type geoIPRange struct {
+	prefix netip.Prefix
+	state  string
+}
+
+// geoIPTable infers a client's state from its address, so ambiguous suburb names
+// can list the client's own state first.
+type geoIPTable struct {
+	ranges []geoIPRange // sorted by first address; networks don't overlap
+	// trustedProxies are load balancers whose X-Forwarded-For entries are believed.
+	trustedProxies []netip.Prefix
+}
+
+// activeGeoIP is the loaded GeoIP table, or nil when -geoip-db is not set.
+var activeGeoIP *geoIPTable
+
+// loadGeoIP reads a CSV with "network" and "state" columns, e.g. "1.120.0.0/13,VIC".
+// State codes may carry the ISO 3166-2 "AU-" prefix, so the subdivision codes of
+// common GeoIP exports can be used as they are. Rows outside Australia's states are
+// skipped.
+func loadGeoIP(path string, trustedProxies []netip.Prefix) (*geoIPTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open GeoIP database: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read GeoIP header: %w", err)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"network", "state"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("GeoIP header is missing a %s column", required)
+		}
+	}
+
+	cell := func(record []string, column string) string {
+		if i := index[column]; i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	table := &geoIPTable{trustedProxies: trustedProxies}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read GeoIP database: %w", err)
+		}
+		state := strings.TrimPrefix(strings.ToUpper(cell(record, "state")), "AU-")
+		if stateRanges[state] == nil {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cell(record, "network"))
+		if err != nil {
+			return nil, fmt.Errorf("GeoIP database line %d: invalid network %q", line, cell(record, "network"))
+		}
+		table.ranges = append(table.ranges, geoIPRange{prefix: prefix.Masked(), state: state})
+	}
+
+	sort.Slice(table.ranges, func(i, j int) bool {
+		return table.ranges[i].prefix.Addr().Less(table.ranges[j].prefix.Addr())
+	})
+	return table, nil
+}
+
+// state returns the state addr is in, or "" when no network contains it.
+func (t *geoIPTable) state(addr netip.Addr) string {
+	// The candidate is the last network starting at or before addr.
+	i := sort.Search(len(t.ranges), func(i int) bool {
+		return addr.Less(t.ranges[i].prefix.Addr())
+	})
+	if i > 0 && t.ranges[i-1].prefix.Contains(addr) {
+		return t.ranges[i-1].state
+	}
+	return ""
+}
+
+// clientState infers the state of the client behind r, or returns "" when there is
+// no GeoIP table or the address isn't in it.
+func (t *geoIPTable) clientState(r *http.Request) string {
+	if t == nil {
+		return ""
+	}
+	addr, ok := clientAddr(r, t.trustedProxies)
+	if !ok {
+		return ""
+	}
+	return t.state(addr)
+}
+
+// preferState moves results in state ahead of the others within each relevance
+// tier, so an exact match elsewhere still outranks a prefix match in state.
+func preferState(results []PostcodeResult, keyword, state string) {
+	rank := func(result PostcodeResult) int {
+		rank := matchTier(result.Suburb, keyword) * 2
+		if strings.EqualFold(result.State, state) {
+			rank++
+		}
+		return rank
+	}
+	sort.SliceStable(results, func(a, b int) bool {
+		return rank(results[a]) > rank(results[b])
+	})
+}
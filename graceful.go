@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// systemdListenFD is the first file descriptor systemd passes to a socket-activated
+// service.
+const systemdListenFD = 3
+
+// draining is set once the server has been told to stop taking traffic, so
+// /healthz starts failing and load balancers move new requests elsewhere.
+var draining atomic.Bool
+
+// inheritedListener returns the listening socket passed by systemd socket
+// activation, or nil when the process wasn't started that way. With a socket unit
+// the socket outlives the process, so a restart queues new connections instead of
+// refusing them.
+func inheritedListener() (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	if n, err := strconv.Atoi(os.Getenv("LISTEN_FDS")); err != nil || n < 1 {
+		return nil, nil
+	}
+	// Keep the variables from leaking into processes we start.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(systemdListenFD, "systemd-socket")
+	listener, err := net.FileListener(f)
+	f.Close() // FileListener holds its own copy of the descriptor
+	if err != nil {
+		return nil, fmt.Errorf("inherited socket: %w", err)
+	}
+	return listener, nil
+}
+
+// listen opens the API's listening socket: the one inherited from systemd if there
+// is one, otherwise a new socket on addr.
+func listen(addr string, reusePort bool) (net.Listener, error) {
+	listener, err := inheritedListener()
+	if listener != nil || err != nil {
+		return listener, err
+	}
+	return openListener(addr, reusePort)
+}
+
+// openListener listens on addr, a TCP address such as ":8080" or
+// "unix:/path/to.sock" for a Unix domain socket. With reusePort a TCP socket is
+// opened with SO_REUSEPORT, so a new process can bind the same address and start
+// serving before the old one stops.
+func openListener(addr string, reusePort bool) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if reusePort {
+			return nil, errors.New("-reuse-port applies only to TCP addresses")
+		}
+		return listenUnix(path)
+	}
+	var config net.ListenConfig
+	if reusePort {
+		config.Control = setReusePort
+	}
+	return config.Listen(context.Background(), "tcp", addr)
+}
+
+// listenUnix listens on a Unix domain socket at path. A socket file left behind by
+// a process that didn't exit cleanly is replaced, but any other file at path is an
+// error rather than being deleted. The socket file is removed when the listener
+// closes.
+func listenUnix(path string) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("missing socket path after unix:")
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		// Only remove the socket if nothing is serving on it.
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// serveUntilSignal serves on listener until SIGTERM or SIGINT, then drains: it
+// fails /healthz for drainDelay so load balancers stop sending requests, stops
+// accepting connections, and waits up to grace for in-flight requests to finish.
+func serveUntilSignal(server *http.Server, listener net.Listener, drainDelay, grace time.Duration) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(stop)
+
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+
+	select {
+	case err := <-served:
+		return err
+	case sig := <-stop:
+		log.Printf("Received %s; draining for %s before shutting down", sig, drainDelay)
+	}
+
+	draining.Store(true)
+	time.Sleep(drainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Printf("Server stopped; all in-flight requests finished")
+	return nil
+}
+
+// healthHandler handles GET /healthz: 200 while the server takes traffic, 503 once
+// it is draining or while canary checks fail.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if draining.Load() {
+		writeError(w, http.StatusServiceUnavailable, codeServerDraining, "The server is draining before a restart")
+		return
+	}
+	if canary.failing() {
+		writeError(w, http.StatusServiceUnavailable, codeCanaryFailing, "Canary checks are failing; see GET /status")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// drainHandler handles POST /admin/drain, failing /healthz from now on so a load
+// balancer takes the instance out of rotation ahead of a deploy. Requests keep
+// being served; stop the process with SIGTERM once traffic has moved.
+func drainHandler(w http.ResponseWriter, r *http.Request) {
+	already := draining.Swap(true)
+	auditTrail.recordRequest(r, "server.drain", map[string]any{"already_draining": already})
+	writeJSON(w, http.StatusOK, map[string]any{"draining": true})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"reflect"
+)
+
+// halContentType is the HAL media type; clients that send it in Accept get
+// responses with _links to the related endpoints.
+const halContentType = "application/hal+json"
+
+// linkedResource is implemented by response types that link to related endpoints.
+// Links are relative to the API root and ready to use as-is.
+type linkedResource interface {
+	resourceLinks() map[string]string
+}
+
+func postcodeLink(postcode string) string {
+	return "/postcodes?" + url.Values{"from": {postcode}, "to": {postcode}}.Encode()
+}
+
+func deliveryLink(postcode string) string {
+	return "/postcode/" + url.PathEscape(postcode) + "/delivery"
+}
+
+func nearbyLink(suburb, state string) string {
+	return "/suburb/" + url.PathEscape(suburb) + "/nearby?" + url.Values{"state": {state}}.Encode()
+}
+
+func statePostcodesLink(state string) string {
+	return "/states/" + url.PathEscape(state) + "/postcodes"
+}
+
+func (r PostcodeResult) resourceLinks() map[string]string {
+	return map[string]string{
+		"postcode": postcodeLink(r.Postcode),
+		"delivery": deliveryLink(r.Postcode),
+		"nearby":   nearbyLink(r.Suburb, r.State),
+		"state":    statePostcodesLink(r.State),
+	}
+}
+
+func (g PostcodeGroup) resourceLinks() map[string]string {
+	return map[string]string{
+		"self":     postcodeLink(g.Postcode),
+		"delivery": deliveryLink(g.Postcode),
+	}
+}
+
+func (s StateSummary) resourceLinks() map[string]string {
+	return map[string]string{
+		"postcodes": statePostcodesLink(s.State),
+		"suburbs":   "/suburbs?" + url.Values{"state": {s.State}}.Encode(),
+	}
+}
+
+func (s SuburbListing) resourceLinks() map[string]string {
+	return map[string]string{
+		"nearby": nearbyLink(s.Suburb, s.State),
+		"state":  statePostcodesLink(s.State),
+	}
+}
+
+func (p PostcodeDelivery) resourceLinks() map[string]string {
+	return map[string]string{
+		"self":     deliveryLink(p.Postcode),
+		"postcode": postcodeLink(p.Postcode),
+	}
+}
+
+func (n NearbySuburbs) resourceLinks() map[string]string {
+	links := map[string]string{
+		"self":  nearbyLink(n.Suburb, n.State),
+		"state": statePostcodesLink(n.State),
+	}
+	if n.Postcode != "" {
+		links["postcode"] = postcodeLink(n.Postcode)
+	}
+	return links
+}
+
+// halLink is a link object in a HAL _links map.
+type halLink struct {
+	Href string `json:"href"`
+}
+
+func halLinks(links map[string]string) map[string]halLink {
+	out := make(map[string]halLink, len(links))
+	for rel, href := range links {
+		out[rel] = halLink{Href: href}
+	}
+	return out
+}
+
+// halObject returns the JSON object form of v with a _links member added. Values
+// whose JSON form is not an object are returned under "value".
+func halObject(v any, links map[string]string) (map[string]any, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	object := map[string]any{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&object); err != nil {
+		object = map[string]any{"value": json.RawMessage(raw)}
+	}
+	if linked, ok := v.(linkedResource); ok {
+		for rel, href := range linked.resourceLinks() {
+			if _, taken := links[rel]; !taken {
+				links[rel] = href
+			}
+		}
+	}
+	if len(links) > 0 {
+		object["_links"] = halLinks(links)
+	}
+	return object, nil
+}
+
+// halEmbedded converts a slice of response items into HAL objects with their links.
+func halEmbedded(items reflect.Value) ([]map[string]any, error) {
+	objects := make([]map[string]any, 0, items.Len())
+	for i := range items.Len() {
+		object, err := halObject(items.Index(i).Interface(), map[string]string{})
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// buildHALDocument wraps a response value in a HAL document. Lists and pages
+// become an envelope whose items are under _embedded.results; pages also carry
+// first, last, prev and next links, and parse warnings are a top-level
+// warnings property. Every object gets its related links.
+func buildHALDocument(v any, self *url.URL) (map[string]any, error) {
+	links := map[string]string{}
+	if self != nil {
+		links["self"] = self.RequestURI()
+	}
+
+	if warned, ok := v.(warnedResults); ok {
+		doc, err := buildHALDocument(warned.Results, self)
+		if err != nil {
+			return nil, err
+		}
+		doc["warnings"] = warned.Warnings
+		return doc, nil
+	}
+
+	if paged, ok := v.(pagedResponse); ok {
+		page, perPage, total := paged.pageInfo()
+		if self != nil {
+			for rel, href := range pageLinks(self, page, perPage, total) {
+				links[rel] = href
+			}
+		}
+		results, err := halEmbedded(reflect.ValueOf(paged.pageItems()))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{
+			"page":      page,
+			"per_page":  perPage,
+			"total":     total,
+			"_links":    halLinks(links),
+			"_embedded": map[string]any{"results": results},
+		}, nil
+	}
+
+	if items := reflect.ValueOf(v); items.Kind() == reflect.Slice {
+		results, err := halEmbedded(items)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{
+			"total":     items.Len(),
+			"_links":    halLinks(links),
+			"_embedded": map[string]any{"results": results},
+		}, nil
+	}
+
+	return halObject(v, links)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyBucket is the store bucket holding the search history. Keys start with
+// the search time, so the store's key order is chronological.
+const historyBucket = "history"
+
+// SearchRecord is one logged search.
+type SearchRecord struct {
+	Time    time.Time `json:"time"`
+	Keyword string    `json:"keyword"`
+	Results int       `json:"results"`
+	// Client is a keyed hash of the client's network (its /24 or /48), enough to
+	// tell repeated searches from one place apart from many without keeping addresses.
+	Client string `json:"client"`
+}
+
+// searchHistory persists searches to the store for analysing failed searches, and
+// forgets them after the retention period.
+type searchHistory struct {
+	store          Store
+	retention      time.Duration
+	salt           []byte
+	trustedProxies []netip.Prefix
+}
+
+// searchLog is the configured history, or nil when -search-history is off.
+var searchLog *searchHistory
+
+// newSearchHistory returns a history kept for retention. The client hash is keyed
+// with $SEARCH_HISTORY_SALT, or with a random key when it is unset, in which case
+// clients can't be linked across restarts.
+func newSearchHistory(store Store, retention time.Duration, trustedProxies []netip.Prefix) *searchHistory {
+	salt := []byte(os.Getenv("SEARCH_HISTORY_SALT"))
+	if len(salt) == 0 {
+		salt = make([]byte, 32)
+		rand.Read(salt)
+	}
+	return &searchHistory{store: store, retention: retention, salt: salt, trustedProxies: trustedProxies}
+}
+
+// anonymizeClient hashes the network of the client behind r.
+func (h *searchHistory) anonymizeClient(r *http.Request) string {
+	addr, ok := clientAddr(r, h.trustedProxies)
+	if !ok {
+		return ""
+	}
+	bits := 24
+	if addr.Is6() {
+		bits = 48
+	}
+	network, _ := addr.Prefix(bits)
+	mac := hmac.New(sha256.New, h.salt)
+	mac.Write([]byte(network.String()))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// record logs a search that returned results rows. Failures are logged rather
+// than failing the search.
+func (h *searchHistory) record(r *http.Request, keyword string, results int) {
+	if h == nil {
+		return
+	}
+	entry := SearchRecord{Time: time.Now().UTC(), Keyword: keyword, Results: results, Client: h.anonymizeClient(r)}
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	// The random suffix keeps searches in the same nanosecond apart.
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	key := entry.Time.Format(time.RFC3339Nano) + "-" + hex.EncodeToString(suffix)
+	if err := h.store.Put(historyBucket, key, raw); err != nil {
+		logf(r.Context(), "Warning: failed to record search history: %v", err)
+	}
+}
+
+// each calls fn for every logged search at or after since, oldest first.
+func (h *searchHistory) each(since time.Time, fn func(SearchRecord) error) error {
+	return h.store.ForEach(historyBucket, func(key string, raw []byte) error {
+		var entry SearchRecord
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			log.Printf("Warning: skipping corrupt search history entry %s: %v", key, err)
+			return nil
+		}
+		if entry.Time.Before(since) {
+			return nil
+		}
+		return fn(entry)
+	})
+}
+
+// historyExportBatch is how many searches an export reads from the store at a
+// time. Each batch is written out after the read has finished, so a slow client
+// doesn't hold a bbolt read transaction open, which would stop the database
+// reusing freed pages while the history keeps growing.
+const historyExportBatch = 1000
+
+// errBatchFull ends a store iteration once a batch is complete.
+var errBatchFull = errors.New("batch full")
+
+// eachBatch calls fn with the logged searches at or after since, oldest first,
+// up to n at a time, reading each batch from the store before calling fn.
+func (h *searchHistory) eachBatch(since time.Time, n int, fn func([]SearchRecord) error) error {
+	// Keys start with the search time, so the scan can start a second before
+	// since: RFC3339Nano drops trailing zeros, which only reorders keys within
+	// a second.
+	start := since.UTC().Add(-time.Second).Truncate(time.Second).Format(time.RFC3339)
+	for {
+		var batch []SearchRecord
+		var last string
+		err := h.store.ForEachFrom(historyBucket, start, func(key string, raw []byte) error {
+			if len(batch) == n {
+				return errBatchFull
+			}
+			last = key
+			var entry SearchRecord
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				log.Printf("Warning: skipping corrupt search history entry %s: %v", key, err)
+				return nil
+			}
+			if !entry.Time.Before(since) {
+				batch = append(batch, entry)
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errBatchFull) {
+			return err
+		}
+		if len(batch) > 0 {
+			if err := fn(batch); err != nil {
+				return err
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		// The smallest key after the last one read.
+		start = last + "\x00"
+	}
+}
+
+// prune deletes searches older than the retention period.
+func (h *searchHistory) prune() (int, error) {
+	cutoff := time.Now().UTC().Add(-h.retention)
+	var expired []string
+	err := h.store.ForEach(historyBucket, func(key string, raw []byte) error {
+		var entry SearchRecord
+		if json.Unmarshal(raw, &entry) != nil || entry.Time.Before(cutoff) {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// Delete outside ForEach: bbolt cannot modify a bucket while iterating it.
+	for i, key := range expired {
+		if err := h.store.Delete(historyBucket, key); err != nil {
+			return i, err
+		}
+	}
+	return len(expired), nil
+}
+
+// pruneLoop prunes the history every hour until ctx is done.
+func (h *searchHistory) pruneLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if n, err := h.prune(); err != nil {
+			log.Printf("Warning: search history pruning failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Pruned %d searches older than %s from the history", n, h.retention)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// historyExportHandler handles GET /admin/history/export?since=2024-01-01T00:00:00Z,
+// streaming the search history as CSV, historyExportBatch searches at a time.
+func historyExportHandler(w http.ResponseWriter, r *http.Request) {
+	if searchLog == nil {
+		writeError(w, http.StatusServiceUnavailable, codeDatasetUnavailable, "Search history is not enabled; start the server with -search-history")
+		return
+	}
+	var since time.Time
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, raw); err != nil {
+			writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid 'since' parameter: expected an RFC 3339 time such as 2024-01-01T00:00:00Z")
+			return
+		}
+	}
+	auditTrail.recordRequest(r, "history.export", map[string]any{"since": since})
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="search-history.csv"`)
+	out := csv.NewWriter(w)
+	out.Write([]string{"time", "keyword", "results", "client"})
+	err := searchLog.eachBatch(since, historyExportBatch, func(batch []SearchRecord) error {
+		for _, entry := range batch {
+			if err := out.Write([]string{entry.Time.Format(time.RFC3339Nano), entry.Keyword, strconv.Itoa(entry.Results), entry.Client}); err != nil {
+				return err
+			}
+		}
+		out.Flush()
+		return out.Error()
+	})
+	out.Flush()
+	if err == nil {
+		err = out.Error()
+	}
+	if err != nil {
+		// The status line is already sent; all that can be done is to log and stop.
+		log.Printf("Warning: search history export failed: %v", err)
+	}
+}
+
+const (
+	defaultFailedQueries = 50
+	maxFailedQueries     = 500
+)
+
+// FailedQuery is a keyword that returned no results every time it was searched.
+type FailedQuery struct {
+	// Keyword is the spelling searched most often; spellings that normalise alike
+	// ("St Kilda", "st. kilda") are counted together.
+	Keyword  string    `json:"keyword"`
+	Searches int       `json:"searches"`
+	Clients  int       `json:"clients"` // distinct anonymised client networks
+	LastSeen time.Time `json:"last_seen"`
+	// Suggestions are official suburbs the keyword may be meant to name, when a
+	// local dataset is loaded: candidates for an alias.
+	Suggestions []Suggestion `json:"suggestions,omitempty"`
+}
+
+// failedQueries returns the keywords searched at least minSearches times since
+// since that never returned a result, most searched first. A keyword that found
+// results even once is left out, since it is not missing from the data.
+func (h *searchHistory) failedQueries(since time.Time, minSearches int) ([]FailedQuery, error) {
+	type tally struct {
+		FailedQuery
+		succeeded bool
+		clients   map[string]bool
+		spellings map[string]int
+	}
+	tallies := map[string]*tally{}
+	err := h.each(since, func(entry SearchRecord) error {
+		key := normalizeName(entry.Keyword)
+		if key == "" {
+			return nil
+		}
+		t := tallies[key]
+		if t == nil {
+			t = &tally{clients: map[string]bool{}, spellings: map[string]int{}}
+			tallies[key] = t
+		}
+		t.Searches++
+		t.succeeded = t.succeeded || entry.Results > 0
+		t.clients[entry.Client] = true
+		t.spellings[strings.TrimSpace(entry.Keyword)]++
+		if entry.Time.After(t.LastSeen) {
+			t.LastSeen = entry.Time
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	failed := []FailedQuery{}
+	for _, t := range tallies {
+		if t.succeeded || t.Searches < minSearches {
+			continue
+		}
+		for spelling, n := range t.spellings {
+			if n > t.spellings[t.Keyword] || (n == t.spellings[t.Keyword] && spelling < t.Keyword) {
+				t.Keyword = spelling
+			}
+		}
+		t.Clients = len(t.clients)
+		failed = append(failed, t.FailedQuery)
+	}
+	sort.Slice(failed, func(i, j int) bool {
+		if failed[i].Searches != failed[j].Searches {
+			return failed[i].Searches > failed[j].Searches
+		}
+		if failed[i].Clients != failed[j].Clients {
+			return failed[i].Clients > failed[j].Clients
+		}
+		return failed[i].Keyword < failed[j].Keyword
+	})
+	return failed, nil
+}
+
+// failedQueriesHandler handles GET /admin/history/failed?since=...&min_searches=2&limit=50,
+// reporting the keywords that keep returning nothing so maintainers can add aliases
+// or find suburbs missing from the dataset.
+func failedQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	if searchLog == nil {
+		writeError(w, http.StatusServiceUnavailable, codeDatasetUnavailable, "Search history is not enabled; start the server with -search-history")
+		return
+	}
+	query := r.URL.Query()
+
+	var since time.Time
+	if raw := query.Get("since"); raw != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, raw); err != nil {
+			writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid 'since' parameter: expected an RFC 3339 time such as 2024-01-01T00:00:00Z")
+			return
+		}
+	}
+	minSearches := 1
+	if raw := query.Get("min_searches"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid 'min_searches' parameter: expected a positive integer")
+			return
+		}
+		minSearches = n
+	}
+	limit := defaultFailedQueries
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxFailedQueries {
+			writeError(w, http.StatusBadRequest, codeParameterInvalid, fmt.Sprintf("Invalid 'limit' parameter: expected an integer between 1 and %d", maxFailedQueries))
+			return
+		}
+		limit = n
+	}
+
+	failed, err := searchLog.failedQueries(since, minSearches)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to read the search history: %s", err))
+		return
+	}
+	failed = failed[:min(len(failed), limit)]
+	if dataset := currentDataset(); dataset != nil {
+		for i := range failed {
+			failed[i].Suggestions = dataset.Suggest(failed[i].Keyword, 3)
+		}
+	}
+	response := map[string]any{"failed": failed}
+	if !since.IsZero() {
+		response["since"] = since
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// storedAtHeader records when a cached upstream response was stored or last revalidated.
+const storedAtHeader = "X-Cache-Stored-At"
+
+// httpCache is an on-disk cache of raw upstream pages, keyed by URL. It sits below
+// the parsed-result cache: after a selector fix the results cache can be flushed
+// and every page re-parsed from disk, revalidating stale pages with If-None-Match
+// and If-Modified-Since instead of downloading them again.
+//
+// Freshness follows RFC 7234 for a private cache: Cache-Control max-age, then
+// Expires, then 10% of the time since Last-Modified. minTTL keeps every page fresh
+// for at least that long, whatever its headers say.
+type httpCache struct {
+	dir    string
+	minTTL time.Duration
+	next   http.RoundTripper
+}
+
+func newHTTPCache(dir string, minTTL time.Duration, next http.RoundTripper) (*httpCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create HTTP cache directory: %w", err)
+	}
+	return &httpCache{dir: dir, minTTL: minTTL, next: next}, nil
+}
+
+func (c *httpCache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".http")
+}
+
+// RoundTrip serves GET requests from the cache when the stored page is fresh, and
+// otherwise forwards them upstream, conditionally when the page has validators.
+func (c *httpCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return c.next.RoundTrip(req)
+	}
+	key := req.URL.String()
+
+	cached, err := c.load(key, req)
+	if err != nil {
+		logf(req.Context(), "Warning: discarding unreadable HTTP cache entry for %s: %v", key, err)
+	}
+	// A forced refresh still revalidates, but never takes a fresh page on trust.
+	if cached != nil && c.fresh(cached.Header) && !liveFetch(req.Context()) {
+		cached.Header.Set("X-Cache", "HIT")
+		return cached, nil
+	}
+
+	if cached != nil {
+		req = req.Clone(req.Context())
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if modified := cached.Header.Get("Last-Modified"); modified != "" {
+			req.Header.Set("If-Modified-Since", modified)
+		}
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		if cached != nil {
+			cached.Body.Close()
+		}
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		// A 304 carries the updated freshness headers for the stored page.
+		for _, name := range []string{"Cache-Control", "Date", "ETag", "Expires", "Last-Modified"} {
+			if value := resp.Header.Get(name); value != "" {
+				cached.Header.Set(name, value)
+			}
+		}
+		body, err := io.ReadAll(cached.Body)
+		cached.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		// DumpResponse reads the body and puts back an equivalent one.
+		cached.Body = io.NopCloser(bytes.NewReader(body))
+		c.store(key, cached, body)
+		cached.Header.Set("X-Cache", "REVALIDATED")
+		return cached, nil
+	}
+	if cached != nil {
+		cached.Body.Close()
+	}
+
+	if resp.StatusCode != http.StatusOK || strings.Contains(strings.ToLower(resp.Header.Get("Cache-Control")), "no-store") {
+		return resp, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	c.store(key, resp, body)
+	resp.Header.Set("X-Cache", "MISS")
+	return resp, nil
+}
+
+// load reads the stored response for key, or returns nil if there is none.
+func (c *httpCache) load(key string, req *http.Request) (*http.Response, error) {
+	raw, err := os.ReadFile(c.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), req)
+}
+
+// store writes resp to disk atomically, stamped with the current time. Failures
+// are logged; the response is still served.
+func (c *httpCache) store(key string, resp *http.Response, body []byte) {
+	resp.Header.Set(storedAtHeader, time.Now().UTC().Format(http.TimeFormat))
+	resp.Header.Del("X-Cache")
+	// The body was read in full, so store it with a plain Content-Length.
+	resp.TransferEncoding = nil
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	raw, err := httputil.DumpResponse(resp, true)
+	if err == nil {
+		err = writeFileAtomic(c.path(key), raw)
+	}
+	if err != nil {
+		log.Printf("Warning: HTTP cache write failed for %s: %v", key, err)
+	}
+}
+
+// fresh reports whether a stored response may be served without revalidation.
+func (c *httpCache) fresh(header http.Header) bool {
+	storedAt, err := http.ParseTime(header.Get(storedAtHeader))
+	if err != nil {
+		return false
+	}
+	age := time.Since(storedAt)
+	if age < c.minTTL {
+		return true
+	}
+	return age < freshnessLifetime(header)
+}
+
+// freshnessLifetime returns how long a response stays fresh after it was received.
+func freshnessLifetime(header http.Header) time.Duration {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directive)), "=")
+		switch name {
+		case "no-cache", "no-store":
+			return 0
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return 0
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		// An invalid Expires, such as "0", means already expired.
+		at, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return at.Sub(date)
+	}
+	if modified, err := http.ParseTime(header.Get("Last-Modified")); err == nil && date.After(modified) {
+		return date.Sub(modified) / 10
+	}
+	return 0
+}
+
+// writeFileAtomic writes data to path via a temporary file and rename, so readers
+// never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// searchIndex is an inverted index from name tokens to dataset row numbers. Each row
+// is indexed under the words of its suburb, its state and its postcode, so multi-word
+// queries like "surfers paradise qld" can be answered without scanning every row.
+type searchIndex struct {
+	postings map[string][]int // token -> ascending row numbers
+	tokens   []string         // every indexed token, sorted, for prefix lookups
+}
+
+// tokenize splits text into lower-case words, treating anything other than letters
+// and digits as a separator. Apostrophes are dropped rather than splitting, so
+// "O'Connor" and "oconnor" are the same word, and text is folded to plain letters
+// first, so "Cañada" and "canada" are too.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(apostrophes.Replace(strings.ToLower(foldText(text))), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// foldText normalises text pasted from other systems to the form official names use:
+// NFKC turns compatibility characters such as full-width letters and ligatures into
+// their plain equivalents, and diacritics are stripped, so "Ｃａñａｄａ" reads "Canada".
+func foldText(text string) string {
+	ascii := true
+	for i := 0; i < len(text) && ascii; i++ {
+		ascii = text[i] < utf8.RuneSelf
+	}
+	if ascii {
+		return text
+	}
+	folded, _, err := transform.String(transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFKC), text)
+	if err != nil {
+		return text
+	}
+	return folded
+}
+
+// apostrophes removes straight and typographic apostrophes and backticks.
+var apostrophes = strings.NewReplacer("'", "", "\u2019", "", "\u2018", "", "`", "")
+
+// buildSearchIndex indexes every row of the dataset.
+func buildSearchIndex(rows []PostcodeResult) *searchIndex {
+	idx := &searchIndex{postings: make(map[string][]int)}
+
+	for i, row := range rows {
+		tokens := append(tokenize(row.Suburb), tokenize(row.State)...)
+		tokens = append(tokens, row.Postcode)
+		for _, token := range tokens {
+			postings := idx.postings[token]
+			// Rows are visited in order, so a duplicate can only be the last entry.
+			if len(postings) > 0 && postings[len(postings)-1] == i {
+				continue
+			}
+			idx.postings[token] = append(postings, i)
+		}
+	}
+
+	idx.tokens = make([]string, 0, len(idx.postings))
+	for token := range idx.postings {
+		idx.tokens = append(idx.tokens, token)
+	}
+	sort.Strings(idx.tokens)
+
+	return idx
+}
+
+// lookup returns the ascending row numbers that contain every query token. Each
+// query token matches any indexed token it is a prefix of, so "rich" finds RICHMOND.
+func (idx *searchIndex) lookup(query string) []int {
+	queryTokens := tokenize(query)
+	if len(queryTokens) == 0 {
+		return nil
+	}
+
+	var matched []int
+	for i, token := range queryTokens {
+		rows := idx.prefixRows(token)
+		if i == 0 {
+			matched = rows
+		} else {
+			matched = intersectSorted(matched, rows)
+		}
+		if len(matched) == 0 {
+			return nil
+		}
+	}
+	return matched
+}
+
+// prefixRows returns the ascending, de-duplicated rows of every token starting with prefix.
+func (idx *searchIndex) prefixRows(prefix string) []int {
+	start := sort.SearchStrings(idx.tokens, prefix)
+
+	var rows []int
+	expansions := 0
+	for _, token := range idx.tokens[start:] {
+		if !strings.HasPrefix(token, prefix) {
+			break
+		}
+		rows = append(rows, idx.postings[token]...)
+		expansions++
+	}
+
+	// A single exact token's postings are already sorted and unique.
+	if expansions > 1 {
+		sort.Ints(rows)
+		rows = dedupeSorted(rows)
+	}
+	return rows
+}
+
+// intersectSorted returns the values present in both ascending slices.
+func intersectSorted(a, b []int) []int {
+	var out []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// dedupeSorted removes adjacent duplicates from an ascending slice in place.
+func dedupeSorted(values []int) []int {
+	if len(values) == 0 {
+		return values
+	}
+	out := values[:1]
+	for _, v := range values[1:] {
+		if v != out[len(out)-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
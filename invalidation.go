@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// invalidationChannel is the Redis pub/sub channel replicas announce cache
+// invalidations on.
+const invalidationChannel = "postcode_scraper:invalidate"
+
+// Kinds of invalidation.
+const (
+	// invalidateKeyword: a keyword was refreshed; drop its cached results.
+	invalidateKeyword = "keyword"
+	// invalidateCaches: the scrape caches were flushed; flush them everywhere.
+	invalidateCaches = "flush"
+	// invalidateDataset: the dataset was replaced; reload it from its source.
+	invalidateDataset = "dataset"
+)
+
+// invalidation is a message on invalidationChannel.
+type invalidation struct {
+	// Origin is the node ID of the sender, which ignores its own messages.
+	Origin  string `json:"origin"`
+	Kind    string `json:"kind"`
+	Keyword string `json:"keyword,omitempty"`
+}
+
+// invalidationMetrics count invalidations sent and received, under
+// "invalidations" on /debug/vars.
+var invalidationMetrics = expvar.NewMap("invalidations")
+
+// invalidationBus tells the other replicas sharing -redis-url when this one has
+// refreshed a keyword, flushed its caches or replaced its dataset, so they drop
+// what they hold in memory promptly instead of serving it until it expires.
+// Delivery is best effort: a replica that is disconnected when a message is
+// sent misses it and catches up as its entries expire.
+type invalidationBus struct {
+	client *redis.Client
+	nodeID string
+}
+
+// invalidations is the bus when -redis-url is set, and nil otherwise.
+var invalidations *invalidationBus
+
+func newInvalidationBus(client *redis.Client) *invalidationBus {
+	return &invalidationBus{client: client, nodeID: newNodeID()}
+}
+
+// publish announces an invalidation to the other replicas. Failures are logged;
+// the change has already been made here.
+func (b *invalidationBus) publish(kind, keyword string) {
+	if b == nil {
+		return
+	}
+	payload, err := json.Marshal(invalidation{Origin: b.nodeID, Kind: kind, Keyword: keyword})
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = b.client.Publish(ctx, invalidationChannel, payload).Err()
+		cancel()
+	}
+	if err != nil {
+		log.Printf("Warning: failed to broadcast %s invalidation: %v", kind, err)
+		return
+	}
+	invalidationMetrics.Add("sent", 1)
+}
+
+// listen applies invalidations from other replicas until ctx is done. The
+// subscription reconnects by itself when the connection to Redis drops.
+func (b *invalidationBus) listen(ctx context.Context) {
+	subscription := b.client.Subscribe(ctx, invalidationChannel)
+	defer subscription.Close()
+	messages := subscription.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			var event invalidation
+			if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+				log.Printf("Warning: ignoring malformed invalidation: %v", err)
+				continue
+			}
+			if event.Origin != b.nodeID {
+				b.apply(ctx, event)
+			}
+		}
+	}
+}
+
+// apply carries out an invalidation received from another replica.
+func (b *invalidationBus) apply(ctx context.Context, event invalidation) {
+	switch event.Kind {
+	case invalidateKeyword:
+		scrapeCache.forget(ctx, event.Keyword)
+		renderedResponses.invalidate()
+	case invalidateCaches:
+		if !readOnly {
+			for _, flush := range []func() (int, error){scrapeCache.flush, nearbyCache.flush} {
+				if _, err := flush(); err != nil {
+					log.Printf("Warning: failed to flush caches on invalidation from %s: %v", event.Origin, err)
+				}
+			}
+		}
+		renderedResponses.invalidate()
+	case invalidateDataset:
+		if datasetReloader == nil {
+			break
+		}
+		reloadCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		dataset, source, err := datasetReloader(reloadCtx)
+		cancel()
+		switch {
+		case err != nil:
+			log.Printf("Warning: failed to reload dataset from %s on invalidation from %s: %v", source, event.Origin, err)
+		case dataset == nil:
+			// Unchanged at the source, so its source time stands.
+		default:
+			// The replica that made the change has notified the webhooks, and only
+			// admin reloads and crawls are announced, so this is neither repeated
+			// nor echoed.
+			swapDataset(dataset, "broadcast", "system")
+		}
+	default:
+		log.Printf("Warning: ignoring invalidation of unknown kind %q from %s", event.Kind, event.Origin)
+		return
+	}
+	invalidationMetrics.Add("received", 1)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ipFilter decides which client addresses may call the API. Deny rules win over
+// allow rules, and an empty allow list admits every address not denied.
+type ipFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+	// trustedProxies are load balancers whose X-Forwarded-For entries are believed.
+	trustedProxies []netip.Prefix
+}
+
+// activeIPFilter is the configured filter, or nil when no lists are set.
+var activeIPFilter *ipFilter
+
+// parseCIDRList parses a comma-separated list of CIDR ranges. Bare addresses are
+// accepted as single-host ranges.
+func parseCIDRList(spec string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// newIPFilter builds a filter from the -allow-cidrs, -deny-cidrs and
+// -trusted-proxies flags. It returns nil when neither list is set.
+func newIPFilter(allowSpec, denySpec, proxySpec string) (*ipFilter, error) {
+	allow, err := parseCIDRList(allowSpec)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %w", err)
+	}
+	deny, err := parseCIDRList(denySpec)
+	if err != nil {
+		return nil, fmt.Errorf("deny list: %w", err)
+	}
+	proxies, err := parseCIDRList(proxySpec)
+	if err != nil {
+		return nil, fmt.Errorf("trusted proxies: %w", err)
+	}
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	return &ipFilter{allow: allow, deny: deny, trustedProxies: proxies}, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether addr may call the API.
+func (f *ipFilter) allows(addr netip.Addr) bool {
+	if containsAddr(f.deny, addr) {
+		return false
+	}
+	return len(f.allow) == 0 || containsAddr(f.allow, addr)
+}
+
+// clientAddr returns the address of the client behind r, believing the filter's
+// trusted proxies.
+func (f *ipFilter) clientAddr(r *http.Request) (netip.Addr, bool) {
+	return clientAddr(r, f.trustedProxies)
+}
+
+// clientAddr returns the address of the client behind r. The connection's peer is
+// used unless it is a trusted proxy, in which case X-Forwarded-For is walked from
+// the right, skipping further trusted proxies, so a client cannot spoof its address
+// by sending its own header.
+func clientAddr(r *http.Request, trustedProxies []netip.Prefix) (netip.Addr, bool) {
+	forwarded := r.Header.Values("X-Forwarded-For")
+	var addr netip.Addr
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+		// A peer on a Unix domain socket (-listen unix:...) has no address. Only a
+		// local reverse proxy can reach the socket, so its X-Forwarded-For is believed.
+		if len(forwarded) == 0 {
+			return netip.Addr{}, false
+		}
+	} else {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		addr, err = netip.ParseAddr(host)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = addr.Unmap()
+		if len(forwarded) == 0 || !containsAddr(trustedProxies, addr) {
+			return addr, true
+		}
+	}
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = hop.Unmap()
+		if !containsAddr(trustedProxies, addr) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// withIPFilter rejects requests from addresses outside the configured allow list
+// or inside the deny list before they reach authentication or the handler.
+func withIPFilter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if activeIPFilter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		addr, ok := activeIPFilter.clientAddr(r)
+		if !ok || !activeIPFilter.allows(addr) {
+			writeError(w, http.StatusForbidden, codeIPForbidden, "Requests from this address are not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
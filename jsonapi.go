@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// jsonapiContentType is the JSON:API media type, used for ?format=jsonapi responses.
+const jsonapiContentType = "application/vnd.api+json"
+
+// jsonapiResource is implemented by response types that map onto a JSON:API
+// resource object. The attributes are the type's regular JSON fields.
+type jsonapiResource interface {
+	jsonapiType() string
+	jsonapiID() string
+}
+
+func (r PostcodeResult) jsonapiType() string { return "localities" }
+func (r PostcodeResult) jsonapiID() string {
+	return strings.Join([]string{r.Postcode, r.State, r.Suburb}, ":")
+}
+
+func (g PostcodeGroup) jsonapiType() string { return "postcodes" }
+func (g PostcodeGroup) jsonapiID() string   { return g.Postcode }
+
+func (s StateSummary) jsonapiType() string { return "states" }
+func (s StateSummary) jsonapiID() string   { return s.State }
+
+func (s SuburbListing) jsonapiType() string { return "suburbs" }
+func (s SuburbListing) jsonapiID() string   { return s.Suburb + ":" + s.State }
+
+func (p PostcodeDelivery) jsonapiType() string { return "deliveries" }
+func (p PostcodeDelivery) jsonapiID() string   { return p.Postcode }
+
+func (n NearbySuburbs) jsonapiType() string { return "nearby-suburbs" }
+func (n NearbySuburbs) jsonapiID() string   { return n.Suburb + ":" + n.State }
+
+func (e catalogEntry) jsonapiType() string { return "error-codes" }
+func (e catalogEntry) jsonapiID() string   { return string(e.Code) }
+
+// jsonapiObject is a resource object in a JSON:API document.
+type jsonapiObject struct {
+	Type       string            `json:"type"`
+	ID         string            `json:"id"`
+	Attributes json.RawMessage   `json:"attributes"`
+	Links      map[string]string `json:"links,omitempty"`
+}
+
+// jsonapiError is one entry of a JSON:API errors array.
+type jsonapiError struct {
+	Status string `json:"status"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// jsonapiDocument is a top-level JSON:API document. Data is a single resource
+// object or an array of them; errors replace data on failure.
+type jsonapiDocument struct {
+	Data   any               `json:"data,omitempty"`
+	Errors []jsonapiError    `json:"errors,omitempty"`
+	Meta   any               `json:"meta,omitempty"`
+	Links  map[string]string `json:"links,omitempty"`
+}
+
+// newJSONAPIObject converts a resource into a JSON:API resource object.
+func newJSONAPIObject(resource jsonapiResource) (jsonapiObject, error) {
+	attributes, err := json.Marshal(resource)
+	if err != nil {
+		return jsonapiObject{}, err
+	}
+	object := jsonapiObject{Type: resource.jsonapiType(), ID: resource.jsonapiID(), Attributes: attributes}
+	if linked, ok := resource.(linkedResource); ok {
+		object.Links = linked.resourceLinks()
+	}
+	return object, nil
+}
+
+// newJSONAPIErrors builds an error document for writeError.
+func newJSONAPIErrors(status int, code errorCode, message string) jsonapiDocument {
+	return jsonapiDocument{Errors: []jsonapiError{{
+		Status: strconv.Itoa(status),
+		Code:   string(code),
+		Detail: message,
+	}}}
+}
+
+// buildJSONAPIDocument wraps a response value in a JSON:API document. Resources and
+// slices of resources become data, pages additionally get pagination links, and
+// values with no resource form (counts, groupings, validation results) are carried
+// in meta. Error responses sent through writeJSON become an errors array.
+func buildJSONAPIDocument(status int, v any, self *url.URL) (jsonapiDocument, error) {
+	doc := jsonapiDocument{}
+	if self != nil {
+		doc.Links = map[string]string{"self": self.RequestURI()}
+	}
+
+	if status >= 400 {
+		if body, ok := v.(map[string]string); ok {
+			errDoc := newJSONAPIErrors(status, errorCode(body["code"]), body["message"])
+			errDoc.Links = doc.Links
+			return errDoc, nil
+		}
+	}
+
+	// Parse warnings belong in meta, beside the results they qualify.
+	if warned, ok := v.(warnedResults); ok {
+		doc.Meta = map[string]any{"warnings": warned.Warnings}
+		v = warned.Results
+	}
+
+	if paged, ok := v.(pagedResponse); ok {
+		page, perPage, total := paged.pageInfo()
+		doc.Meta = map[string]int{"page": page, "per_page": perPage, "total": total}
+		if self != nil {
+			for rel, href := range pageLinks(self, page, perPage, total) {
+				doc.Links[rel] = href
+			}
+		}
+		v = paged.pageItems()
+	}
+
+	if resource, ok := v.(jsonapiResource); ok {
+		object, err := newJSONAPIObject(resource)
+		if err != nil {
+			return doc, err
+		}
+		doc.Data = object
+		return doc, nil
+	}
+
+	if items := reflect.ValueOf(v); items.Kind() == reflect.Slice &&
+		items.Type().Elem().Implements(reflect.TypeFor[jsonapiResource]()) {
+		objects := make([]jsonapiObject, 0, items.Len())
+		for i := range items.Len() {
+			object, err := newJSONAPIObject(items.Index(i).Interface().(jsonapiResource))
+			if err != nil {
+				return doc, err
+			}
+			objects = append(objects, object)
+		}
+		doc.Data = objects
+		return doc, nil
+	}
+
+	doc.Meta = v
+	return doc, nil
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwtLeeway absorbs clock skew between us and the identity provider.
+	jwtLeeway = time.Minute
+	// jwksTTL is how long fetched signing keys are trusted before re-fetching.
+	jwksTTL = time.Hour
+	// jwksMinRefresh limits re-fetches triggered by tokens with unknown key IDs.
+	jwksMinRefresh = time.Minute
+)
+
+// errInsufficientScope is returned for valid tokens missing a required scope.
+var errInsufficientScope = errors.New("token is missing a required scope")
+
+// jwtVerifier validates bearer tokens issued by the configured identity provider.
+type jwtVerifier struct {
+	issuer   string
+	audience string   // optional
+	scopes   []string // every one must be granted
+	jwksURL  string
+	client   *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	refresh   *jwksCall // the key set fetch in flight, if any
+}
+
+// jwksCall is a fetch of the key set, shared by the requests waiting on it.
+type jwksCall struct {
+	done chan struct{}
+	keys map[string]crypto.PublicKey
+	err  error
+}
+
+// jwtAuth is the configured JWT verifier, or nil when JWT authentication is off.
+var jwtAuth *jwtVerifier
+
+// newJWTVerifier configures a verifier. requiredScopes is a comma-separated list.
+func newJWTVerifier(issuer, jwksURL, audience, requiredScopes string) (*jwtVerifier, error) {
+	if issuer == "" || jwksURL == "" {
+		return nil, errors.New("both an issuer and a JWKS URL are required")
+	}
+	v := &jwtVerifier{
+		issuer:   issuer,
+		audience: audience,
+		jwksURL:  jwksURL,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, scope := range strings.Split(requiredScopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			v.scopes = append(v.scopes, scope)
+		}
+	}
+	return v, nil
+}
+
+// jwtClaims are the registered and scope claims the verifier checks.
+type jwtClaims struct {
+	Issuer    string          `json:"iss"`
+	Subject   string          `json:"sub"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Scope     string          `json:"scope"` // space-separated, as in OAuth 2.0
+	Scp       []string        `json:"scp"`   // array form used by some providers
+	// ClientID is the OAuth client the token was issued to (RFC 9068); some
+	// providers name it azp instead.
+	ClientID        string `json:"client_id"`
+	AuthorizedParty string `json:"azp"`
+}
+
+// hasAudience reports whether aud, a string or array of strings, contains want.
+func (c jwtClaims) hasAudience(want string) bool {
+	var single string
+	if json.Unmarshal(c.Audience, &single) == nil {
+		return single == want
+	}
+	var many []string
+	if json.Unmarshal(c.Audience, &many) == nil {
+		return slices.Contains(many, want)
+	}
+	return false
+}
+
+func (c jwtClaims) grantedScopes() []string {
+	return append(strings.Fields(c.Scope), c.Scp...)
+}
+
+// verify checks the token's signature and claims, returning the claims if valid.
+func (v *jwtVerifier) verify(ctx context.Context, token string) (jwtClaims, error) {
+	var claims jwtClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return claims, fmt.Errorf("malformed token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errors.New("malformed token signature")
+	}
+
+	key, err := v.signingKey(ctx, header.Kid)
+	if err != nil {
+		return claims, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return claims, err
+	}
+
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return claims, fmt.Errorf("malformed token claims: %w", err)
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != v.issuer:
+		return claims, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	case claims.ExpiresAt == nil:
+		return claims, errors.New("token has no expiry")
+	case now.After(unixTime(*claims.ExpiresAt).Add(jwtLeeway)):
+		return claims, errors.New("token has expired")
+	case claims.NotBefore != nil && now.Add(jwtLeeway).Before(unixTime(*claims.NotBefore)):
+		return claims, errors.New("token is not valid yet")
+	case v.audience != "" && !claims.hasAudience(v.audience):
+		return claims, errors.New("token is not issued for this audience")
+	}
+
+	granted := claims.grantedScopes()
+	for _, scope := range v.scopes {
+		if !slices.Contains(granted, scope) {
+			return claims, fmt.Errorf("%w: %s", errInsufficientScope, scope)
+		}
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, dst any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, dst)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(int64(seconds), 0)
+}
+
+// verifyJWTSignature checks signature over signed with the algorithm named in the
+// token header. Only asymmetric algorithms are accepted, so a token cannot be
+// forged with "none" or by signing with the public key as an HMAC secret.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	digest := jwtDigest(hash, signed)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm %s does not match an RSA key", alg)
+		}
+		if rsa.VerifyPKCS1v15(key, hash, digest, signature) != nil {
+			return errors.New("invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			return fmt.Errorf("algorithm %s does not match an EC key", alg)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+	default:
+		return errors.New("unsupported signing key")
+	}
+	return nil
+}
+
+func jwtDigest(hash crypto.Hash, signed string) []byte {
+	switch hash {
+	case crypto.SHA384:
+		sum := sha512.Sum384([]byte(signed))
+		return sum[:]
+	case crypto.SHA512:
+		sum := sha512.Sum512([]byte(signed))
+		return sum[:]
+	default:
+		sum := sha256.Sum256([]byte(signed))
+		return sum[:]
+	}
+}
+
+// signingKey returns the JWKS key with the given ID, re-fetching the key set when
+// it is stale or the ID is unknown (the provider may have rotated keys). Requests
+// arriving during a fetch share it, and a cached key keeps being used while its
+// stale set is re-fetched, so the network call never holds up requests that
+// don't need it.
+func (v *jwtVerifier) signingKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	stale := time.Since(v.fetchedAt) > jwksTTL
+	if (ok && !stale) || (!stale && time.Since(v.fetchedAt) <= jwksMinRefresh) {
+		v.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	call := v.refresh
+	if call == nil {
+		call = &jwksCall{done: make(chan struct{})}
+		v.refresh = call
+		go v.fetch(call)
+	}
+	v.mu.Unlock()
+
+	if ok {
+		// Keep using the cached key until the new set arrives, or while the
+		// provider is unreachable.
+		return key, nil
+	}
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		return nil, fmt.Errorf("fetch JWKS: %w", call.err)
+	}
+	if key, ok = call.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// fetch gets the key set for everyone waiting on call, and swaps it in when it
+// was fetched successfully. It outlives the request that started it.
+func (v *jwtVerifier) fetch(call *jwksCall) {
+	call.keys, call.err = v.fetchKeys(context.Background())
+
+	v.mu.Lock()
+	if call.err == nil {
+		v.keys, v.fetchedAt = call.keys, time.Now()
+	}
+	v.refresh = nil
+	v.mu.Unlock()
+	close(call.done)
+}
+
+// jsonWebKey is an RSA or EC public key in a JWKS document.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys downloads the JWKS document and parses its signing keys. Keys of
+// unsupported types are skipped.
+func (v *jwtVerifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		raw, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(raw), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+type jwtContextKey struct{}
+
+// requestJWT returns the claims of the bearer token r was authenticated with.
+func requestJWT(r *http.Request) (jwtClaims, bool) {
+	claims, ok := r.Context().Value(jwtContextKey{}).(jwtClaims)
+	return claims, ok
+}
+
+// serveWithJWT verifies a bearer token and calls next with its claims in the
+// request context, or writes a 401/403 with a WWW-Authenticate challenge.
+func serveWithJWT(w http.ResponseWriter, r *http.Request, token string, next http.Handler) {
+	claims, err := jwtAuth.verify(r.Context(), token)
+	if errors.Is(err, errInsufficientScope) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, strings.Join(jwtAuth.scopes, " ")))
+		writeError(w, http.StatusForbidden, codeInsufficientScope, "The token does not grant the required scopes")
+		return
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeError(w, http.StatusUnauthorized, codeTokenInvalid, fmt.Sprintf("Invalid bearer token: %s", err))
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), jwtContextKey{}, claims)))
+}
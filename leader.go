@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// leaderLease is how long leadership lasts without renewal. Leaders renew at a
+// third of this, so a crashed leader is replaced within one lease.
+const leaderLease = 30 * time.Second
+
+// leaderElector decides which replica runs scheduled jobs.
+type leaderElector interface {
+	// Acquire takes leadership, or renews it if this node already holds it, for
+	// the given lease. It reports whether this node is now the leader.
+	Acquire(ctx context.Context, lease time.Duration) (bool, error)
+	// Release gives up leadership if this node holds it.
+	Release(ctx context.Context) error
+}
+
+// newNodeID returns a random identifier for this process's leadership claims.
+func newNodeID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 6)
+	rand.Read(b)
+	return host + "-" + hex.EncodeToString(b)
+}
+
+// redisElector holds leadership as a Redis key containing the leader's node ID.
+type redisElector struct {
+	client *redis.Client
+	key    string
+	nodeID string
+}
+
+func newRedisElector(client *redis.Client, name string) *redisElector {
+	return &redisElector{client: client, key: "postcode_scraper:leader:" + name, nodeID: newNodeID()}
+}
+
+// renewScript extends the lease only if this node still holds it.
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseScript deletes the key only if this node still holds it.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (e *redisElector) Acquire(ctx context.Context, lease time.Duration) (bool, error) {
+	acquired, err := e.client.SetNX(ctx, e.key, e.nodeID, lease).Result()
+	if err != nil || acquired {
+		return acquired, err
+	}
+	renewed, err := renewScript.Run(ctx, e.client, []string{e.key}, e.nodeID, lease.Milliseconds()).Int()
+	return renewed == 1, err
+}
+
+func (e *redisElector) Release(ctx context.Context) error {
+	return releaseScript.Run(ctx, e.client, []string{e.key}, e.nodeID).Err()
+}
+
+// fileElector holds leadership in a lease file on storage shared by every replica,
+// for deployments without Redis. The file holds "<node ID> <expiry unix nanos>".
+type fileElector struct {
+	path   string
+	nodeID string
+}
+
+func newFileElector(path string) *fileElector {
+	return &fileElector{path: path, nodeID: newNodeID()}
+}
+
+// readLease returns the current holder and expiry, or an empty holder if there is no lease.
+func (e *fileElector) readLease() (string, time.Time, error) {
+	raw, err := os.ReadFile(e.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", time.Time{}, nil
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	fields := strings.Fields(string(raw))
+	if len(fields) != 2 {
+		// A corrupt lease is treated as expired.
+		return "", time.Time{}, nil
+	}
+	nanos, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, nil
+	}
+	return fields[0], time.Unix(0, nanos), nil
+}
+
+func (e *fileElector) Acquire(ctx context.Context, lease time.Duration) (bool, error) {
+	holder, expiry, err := e.readLease()
+	if err != nil {
+		return false, err
+	}
+	if holder != "" && holder != e.nodeID && time.Now().Before(expiry) {
+		return false, nil
+	}
+
+	// Write via rename so readers never see a half-written lease.
+	tmp := fmt.Sprintf("%s.%s.tmp", e.path, e.nodeID)
+	content := fmt.Sprintf("%s %d\n", e.nodeID, time.Now().Add(lease).UnixNano())
+	if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, e.path); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+
+	// Two nodes can race past the expiry check; whichever rename landed last wins.
+	// Wait briefly, then confirm the lease is still ours.
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-time.After(100 * time.Millisecond):
+	}
+	holder, _, err = e.readLease()
+	return holder == e.nodeID, err
+}
+
+func (e *fileElector) Release(ctx context.Context) error {
+	holder, _, err := e.readLease()
+	if err != nil || holder != e.nodeID {
+		return err
+	}
+	return os.Remove(e.path)
+}
+
+// soloElector always grants leadership, for single-instance deployments.
+type soloElector struct{}
+
+func (soloElector) Acquire(context.Context, time.Duration) (bool, error) { return true, nil }
+func (soloElector) Release(context.Context) error                        { return nil }
+
+// newLeaderElector picks the elector for a scheduled job: a Redis lock when Redis is
+// configured, a lease file when leasePath is set, and otherwise none at all.
+func newLeaderElector(redisClient *redis.Client, leasePath, name string) leaderElector {
+	switch {
+	case redisClient != nil:
+		return newRedisElector(redisClient, name)
+	case leasePath != "":
+		return newFileElector(filepath.Clean(leasePath))
+	default:
+		return soloElector{}
+	}
+}
+
+// runScheduled runs job every interval until ctx is cancelled, but only on the node
+// holding leadership. With immediate set, the first run starts straight away rather
+// than after one interval. Leadership is renewed while the job runs; if it is lost,
+// the job's context is cancelled so two nodes never run it at once for long.
+func runScheduled(ctx context.Context, name string, interval time.Duration, immediate bool, elector leaderElector, job func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for waiting := !immediate; ; waiting = true {
+		if waiting {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+
+		leader, err := elector.Acquire(ctx, leaderLease)
+		if err != nil {
+			log.Printf("Warning: leader election for %s failed: %v", name, err)
+			continue
+		}
+		if !leader {
+			log.Printf("Skipping scheduled %s: another node is leader", name)
+			continue
+		}
+
+		jobCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			renew := time.NewTicker(leaderLease / 3)
+			defer renew.Stop()
+			for {
+				select {
+				case <-jobCtx.Done():
+					return
+				case <-renew.C:
+					if ok, err := elector.Acquire(jobCtx, leaderLease); !ok {
+						log.Printf("Warning: lost leadership during %s, stopping (err: %v)", name, err)
+						cancel()
+						return
+					}
+				}
+			}
+		}()
+
+		if err := job(jobCtx); err != nil {
+			log.Printf("Warning: scheduled %s failed: %v", name, err)
+		}
+		cancel()
+		if err := elector.Release(ctx); err != nil {
+			log.Printf("Warning: releasing leadership for %s failed: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// budgetWindow is the period over which the scrape budget is counted.
+const budgetWindow = time.Minute
+
+// scrapeLimiter caps how many upstream scrapes may start per budgetWindow.
+type scrapeLimiter interface {
+	// Allow reserves one scrape. When the budget is spent it returns false and how
+	// long until the next window opens.
+	Allow(ctx context.Context) (bool, time.Duration)
+}
+
+// windowStart returns the start of the budget window containing t, and how long remains in it.
+func windowStart(t time.Time) (time.Time, time.Duration) {
+	start := t.Truncate(budgetWindow)
+	return start, start.Add(budgetWindow).Sub(t)
+}
+
+// localLimiter enforces the budget for this instance only.
+type localLimiter struct {
+	budget int
+
+	mu     sync.Mutex
+	window time.Time
+	used   int
+}
+
+func newLocalLimiter(budget int) *localLimiter {
+	return &localLimiter{budget: budget}
+}
+
+func (l *localLimiter) Allow(ctx context.Context) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	start, remaining := windowStart(time.Now())
+	if !start.Equal(l.window) {
+		l.window = start
+		l.used = 0
+	}
+	if l.used >= l.budget {
+		return false, remaining
+	}
+	l.used++
+	return true, 0
+}
+
+// redisLimiter shares one budget across every replica by counting scrapes in a
+// Redis key per window. If Redis is unreachable it falls back to enforcing the
+// budget locally, which over-admits across the fleet but still protects the upstream.
+type redisLimiter struct {
+	client   *redis.Client
+	key      string
+	budget   int
+	fallback *localLimiter
+}
+
+func newRedisLimiter(client *redis.Client, budget int) *redisLimiter {
+	return &redisLimiter{
+		client:   client,
+		key:      "postcode_scraper:scrapes",
+		budget:   budget,
+		fallback: newLocalLimiter(budget),
+	}
+}
+
+func (l *redisLimiter) Allow(ctx context.Context) (bool, time.Duration) {
+	start, remaining := windowStart(time.Now())
+	key := fmt.Sprintf("%s:%d", l.key, start.Unix())
+
+	// INCR and EXPIRE run atomically; the key outlives its window slightly so
+	// replicas with skewed clocks still see it.
+	var used *redis.IntCmd
+	_, err := l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		used = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, 2*budgetWindow)
+		return nil
+	})
+	if err != nil {
+		logf(ctx, "Warning: shared rate limiter unavailable, limiting locally: %v", err)
+		return l.fallback.Allow(ctx)
+	}
+
+	if used.Val() > int64(l.budget) {
+		return false, remaining
+	}
+	return true, 0
+}
+
+// budgetExhaustedError reports that the scrape budget is spent, and how long
+// until the next window opens.
+type budgetExhaustedError struct {
+	retryAfter time.Duration
+}
+
+func (e *budgetExhaustedError) Error() string {
+	return "upstream scrape budget exhausted"
+}
+
+// reserveScrape reserves a scrape from the budget, if one is configured, for a
+// request that needs another upstream fetch part way through. When the budget
+// is spent it returns a *budgetExhaustedError.
+func reserveScrape(ctx context.Context) error {
+	if scrapeBudget == nil {
+		return nil
+	}
+	if ok, retryAfter := scrapeBudget.Allow(ctx); !ok {
+		return &budgetExhaustedError{retryAfter: retryAfter}
+	}
+	return nil
+}
+
+// allowScrape reserves a scrape from the budget, if one is configured. When the
+// budget is spent it writes a 429 with Retry-After and returns false.
+func allowScrape(w http.ResponseWriter, r *http.Request) bool {
+	var exhausted *budgetExhaustedError
+	if err := reserveScrape(r.Context()); errors.As(err, &exhausted) {
+		writeBudgetExhausted(w, exhausted)
+		return false
+	}
+	return true
+}
+
+// writeBudgetExhausted answers 429 with a Retry-After for when the budget frees up.
+func writeBudgetExhausted(w http.ResponseWriter, err *budgetExhaustedError) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(err.retryAfter.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, codeRateLimited, "Upstream scrape budget exhausted; please retry later")
+}
+
+// waitForBudget reserves a scrape from the budget, if one is configured, for
+// background jobs such as crawls and canary checks. When the budget is spent it
+// waits for the next window instead of failing, and returns ctx's error if ctx
+// is done first.
+func waitForBudget(ctx context.Context) error {
+	if scrapeBudget == nil {
+		return nil
+	}
+	for {
+		ok, retryAfter := scrapeBudget.Allow(ctx)
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryAfter):
+		}
+	}
+}
+
+// newRedisClient connects to the Redis server described by a redis:// URL.
+func newRedisClient(rawURL string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	return redis.NewClient(opts), nil
+}
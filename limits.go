@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+)
+
+// maxHeaderBytes bounds the request line and headers, which includes the query string.
+const maxHeaderBytes = 64 << 10
+
+// requestLimits are the per-request size limits; main fills them from flags.
+var requestLimits = struct {
+	KeywordLength int
+	BodyBytes     int64
+	BatchSize     int
+}{
+	KeywordLength: 100,
+	BodyBytes:     1 << 20,
+	BatchSize:     1000,
+}
+
+// checkKeywordLength rejects keywords longer than the configured limit.
+func checkKeywordLength(keyword string) error {
+	if n := utf8.RuneCountInString(keyword); n > requestLimits.KeywordLength {
+		return fmt.Errorf("'keyword' is too long: %d characters, maximum is %d", n, requestLimits.KeywordLength)
+	}
+	return nil
+}
+
+// checkBatchSize rejects batch requests with more items than the configured limit.
+func checkBatchSize(n int) error {
+	if n > requestLimits.BatchSize {
+		return fmt.Errorf("Batch is too large: %d items, maximum is %d", n, requestLimits.BatchSize)
+	}
+	return nil
+}
+
+// withBodyLimit caps how much of a request body handlers can read.
+func withBodyLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, requestLimits.BodyBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeJSONBody decodes a JSON request body into dst. On failure it writes a 413 for
+// oversized bodies or a 400 for malformed ones, and returns false.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst any) bool {
+	err := json.NewDecoder(r.Body).Decode(dst)
+	if err == nil {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeBodyTooLarge, fmt.Sprintf("Request body is too large: maximum is %d bytes", tooLarge.Limit))
+	} else {
+		writeError(w, http.StatusBadRequest, codeBodyInvalid, fmt.Sprintf("Invalid JSON body: %s", err))
+	}
+	return false
+}
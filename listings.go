@@ -0,0 +1,423 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SuburbEntry is a suburb listed under a postcode.
+type SuburbEntry struct {
+	Suburb   string `json:"suburb"`
+	State    string `json:"state"`
+	Category string `json:"category"`
+}
+
+// PostcodeGroup lists every suburb sharing a single postcode.
+type PostcodeGroup struct {
+	Postcode string        `json:"postcode"`
+	Suburbs  []SuburbEntry `json:"suburbs"`
+}
+
+// groupByPostcode collects rows into one group per postcode, ordered numerically.
+// Suburbs within a group keep the order of the input rows.
+func groupByPostcode(rows []PostcodeResult) []PostcodeGroup {
+	groups := []PostcodeGroup{}
+	positions := map[string]int{}
+	for _, row := range rows {
+		pos, ok := positions[row.Postcode]
+		if !ok {
+			pos = len(groups)
+			positions[row.Postcode] = pos
+			groups = append(groups, PostcodeGroup{Postcode: row.Postcode})
+		}
+		groups[pos].Suburbs = append(groups[pos].Suburbs, SuburbEntry{
+			Suburb:   row.Suburb,
+			State:    row.State,
+			Category: row.Category,
+		})
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return postcodeNumber(groups[i].Postcode) < postcodeNumber(groups[j].Postcode)
+	})
+	return groups
+}
+
+// postcodeNumber returns the numeric value of a postcode, or -1 if it is not numeric.
+func postcodeNumber(postcode string) int {
+	n, err := strconv.Atoi(postcode)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// PostcodesInRange returns the rows whose numeric postcode lies within [from, to].
+func (d *Dataset) PostcodesInRange(from, to int) []PostcodeResult {
+	rows := []PostcodeResult{}
+	for _, row := range d.Rows {
+		if n := postcodeNumber(row.Postcode); n >= from && n <= to {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// postcodePrefix matches a keyword that is the start of a postcode, such as "30".
+var postcodePrefix = regexp.MustCompile(`^\d{1,3}$`)
+
+// PostcodesWithPrefix returns the rows whose postcode starts with prefix.
+func (d *Dataset) PostcodesWithPrefix(prefix string) []PostcodeResult {
+	rows := []PostcodeResult{}
+	for _, i := range d.postcodes.withPrefix(prefix) {
+		rows = append(rows, d.Rows[i])
+	}
+	return rows
+}
+
+// requireDataset returns the loaded dataset, or writes a 503 response and returns
+// nil when the server is running without one.
+func requireDataset(w http.ResponseWriter) *Dataset {
+	dataset := currentDataset()
+	if dataset == nil {
+		writeError(w, http.StatusServiceUnavailable, codeDatasetUnavailable, "This endpoint requires a local dataset; start the server with -dataset")
+	}
+	return dataset
+}
+
+// parsePostcodeParam reads a required query parameter holding a postcode number (0-9999).
+func parsePostcodeParam(query url.Values, name string) (int, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return 0, fmt.Errorf("Missing '%s' parameter", name)
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 || n > 9999 {
+		return 0, fmt.Errorf("Invalid '%s' parameter: expected a postcode between 0000 and 9999", name)
+	}
+	return n, nil
+}
+
+// postcodesHandler handles GET /postcodes?from=3000&to=3200, listing every postcode
+// in the range together with its suburbs.
+func postcodesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	from, err := parsePostcodeParam(query, "from")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+	to, err := parsePostcodeParam(query, "to")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+	if from > to {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid range: 'from' must not be greater than 'to'")
+		return
+	}
+
+	dataset := requireDataset(w)
+	if dataset == nil {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, groupByPostcode(dataset.PostcodesInRange(from, to)))
+}
+
+// Pagination defaults for listing endpoints.
+const (
+	defaultPerPage = 100
+	maxPerPage     = 1000
+)
+
+// pagination is a validated ?page=&per_page= request. Pages are numbered from 1.
+type pagination struct {
+	Page    int
+	PerPage int
+}
+
+// Page is the response envelope for paginated listings.
+type Page[T any] struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+	Results []T `json:"results"`
+}
+
+// pagedResponse is implemented by Page so response encoders can reach the page's
+// items and emit pagination links without knowing the item type.
+type pagedResponse interface {
+	pageItems() any
+	pageInfo() (page, perPage, total int)
+}
+
+func (p Page[T]) pageItems() any { return p.Results }
+func (p Page[T]) pageInfo() (int, int, int) {
+	return p.Page, p.PerPage, p.Total
+}
+
+// pageLinks returns first, last, prev and next links for a page of a listing,
+// keeping the rest of the request's query string.
+func pageLinks(self *url.URL, page, perPage, total int) map[string]string {
+	pageURL := func(n int) string {
+		u := *self
+		query := u.Query()
+		query.Set("page", strconv.Itoa(n))
+		query.Set("per_page", strconv.Itoa(perPage))
+		u.RawQuery = query.Encode()
+		return u.RequestURI()
+	}
+
+	last := max((total+perPage-1)/perPage, 1)
+	links := map[string]string{
+		"first": pageURL(1),
+		"last":  pageURL(last),
+	}
+	if page > 1 {
+		links["prev"] = pageURL(min(page-1, last))
+	}
+	if page < last {
+		links["next"] = pageURL(page + 1)
+	}
+	return links
+}
+
+// parsePagination reads the optional page and per_page query parameters.
+func parsePagination(query url.Values) (pagination, error) {
+	p := pagination{Page: 1, PerPage: defaultPerPage}
+
+	if raw := query.Get("page"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("Invalid 'page' parameter: expected a positive integer")
+		}
+		p.Page = n
+	}
+	if raw := query.Get("per_page"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxPerPage {
+			return p, fmt.Errorf("Invalid 'per_page' parameter: expected an integer between 1 and %d", maxPerPage)
+		}
+		p.PerPage = n
+	}
+	return p, nil
+}
+
+// paginate slices items down to the requested page. Pages past the end are empty.
+func paginate[T any](items []T, p pagination) Page[T] {
+	start := min((p.Page-1)*p.PerPage, len(items))
+	end := min(start+p.PerPage, len(items))
+	return Page[T]{
+		Page:    p.Page,
+		PerPage: p.PerPage,
+		Total:   len(items),
+		Results: items[start:end],
+	}
+}
+
+// StateSummary describes how many postcodes and suburbs a state has in the dataset.
+type StateSummary struct {
+	State     string `json:"state"`
+	Postcodes int    `json:"postcodes"`
+	Suburbs   int    `json:"suburbs"`
+}
+
+// States summarises every state present in the dataset, ordered by state code.
+func (d *Dataset) States() []StateSummary {
+	postcodes := map[string]map[string]bool{}
+	suburbs := map[string]int{}
+	for _, row := range d.Rows {
+		if postcodes[row.State] == nil {
+			postcodes[row.State] = map[string]bool{}
+		}
+		postcodes[row.State][row.Postcode] = true
+		suburbs[row.State]++
+	}
+
+	summaries := []StateSummary{}
+	for state, codes := range postcodes {
+		summaries = append(summaries, StateSummary{
+			State:     state,
+			Postcodes: len(codes),
+			Suburbs:   suburbs[state],
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].State < summaries[j].State
+	})
+	return summaries
+}
+
+// RowsInState returns the rows belonging to a state (case-insensitive).
+func (d *Dataset) RowsInState(state string) []PostcodeResult {
+	rows := []PostcodeResult{}
+	for _, row := range d.Rows {
+		if strings.EqualFold(row.State, state) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// statesHandler handles GET /states.
+func statesHandler(w http.ResponseWriter, r *http.Request) {
+	dataset := requireDataset(w)
+	if dataset == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, dataset.States())
+}
+
+// statePostcodesHandler handles GET /states/{state}/postcodes, listing the state's
+// postcodes with their suburbs one page at a time.
+func statePostcodesHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePagination(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+
+	dataset := requireDataset(w)
+	if dataset == nil {
+		return
+	}
+
+	state := r.PathValue("state")
+	rows := dataset.RowsInState(state)
+	if len(rows) == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("No postcodes found for state '%s'", state))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, paginate(groupByPostcode(rows), page))
+}
+
+// SuburbListing is one suburb in the alphabetical index, with every postcode it uses.
+type SuburbListing struct {
+	Suburb    string   `json:"suburb"`
+	State     string   `json:"state"`
+	Postcodes []string `json:"postcodes"`
+}
+
+// Suburbs returns the dataset's distinct suburbs in alphabetical order, optionally
+// limited to names starting with prefix and to a single state (both case-insensitive).
+func (d *Dataset) Suburbs(prefix, state string) []SuburbListing {
+	prefix = normalizeName(prefix)
+
+	listings := []SuburbListing{}
+	positions := map[[2]string]int{}
+	for _, i := range d.suburbs.withPrefix(prefix) {
+		row := d.Rows[i]
+		if state != "" && !strings.EqualFold(row.State, state) {
+			continue
+		}
+
+		key := [2]string{row.Suburb, row.State}
+		pos, ok := positions[key]
+		if !ok {
+			pos = len(listings)
+			positions[key] = pos
+			listings = append(listings, SuburbListing{Suburb: row.Suburb, State: row.State})
+		}
+		if !slices.Contains(listings[pos].Postcodes, row.Postcode) {
+			listings[pos].Postcodes = append(listings[pos].Postcodes, row.Postcode)
+		}
+	}
+
+	for _, listing := range listings {
+		slices.Sort(listing.Postcodes)
+	}
+	sort.Slice(listings, func(i, j int) bool {
+		if listings[i].Suburb != listings[j].Suburb {
+			return listings[i].Suburb < listings[j].Suburb
+		}
+		return listings[i].State < listings[j].State
+	})
+	return listings
+}
+
+// suburbsHandler handles GET /suburbs?starts_with=A&state=QLD, an alphabetical,
+// paginated suburb index for directory-style browsing.
+func suburbsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page, err := parsePagination(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+
+	dataset := requireDataset(w)
+	if dataset == nil {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, paginate(dataset.Suburbs(query.Get("starts_with"), query.Get("state")), page))
+}
+
+// maxRandomCount caps how many rows one GET /random call returns.
+const maxRandomCount = 1000
+
+// Sample returns count distinct rows chosen at random, optionally from a single
+// state (case-insensitive). Fewer are returned when the dataset has fewer rows.
+func (d *Dataset) Sample(state string, count int, rng *rand.Rand) []PostcodeResult {
+	rows := d.Rows
+	if state != "" {
+		rows = d.RowsInState(state)
+	}
+	// Draw positions rather than shuffling, so the dataset itself is left alone.
+	sample := []PostcodeResult{}
+	for _, i := range rng.Perm(len(rows))[:min(count, len(rows))] {
+		sample = append(sample, rows[i])
+	}
+	return sample
+}
+
+// randomHandler handles GET /random?state=WA&count=10, returning real
+// postcode/suburb pairs for test fixtures. Passing seed makes the choice repeatable
+// for a given dataset.
+func randomHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	count := 1
+	if raw := query.Get("count"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxRandomCount {
+			writeError(w, http.StatusBadRequest, codeParameterInvalid, fmt.Sprintf("Invalid 'count' parameter: expected an integer between 1 and %d", maxRandomCount))
+			return
+		}
+		count = n
+	}
+	seed := rand.Int64()
+	if raw := query.Get("seed"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid 'seed' parameter: expected an integer")
+			return
+		}
+		seed = n
+	}
+
+	dataset := requireDataset(w)
+	if dataset == nil {
+		return
+	}
+
+	state := query.Get("state")
+	sample := dataset.Sample(state, count, rand.New(rand.NewPCG(uint64(seed), 0)))
+	if len(sample) == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("No postcodes found for state '%s'", state))
+		return
+	}
+	// Echo the seed so a fixture drawn without one can be drawn again.
+	w.Header().Set("X-Random-Seed", strconv.FormatInt(seed, 10))
+	writeJSON(w, http.StatusOK, sample)
+}
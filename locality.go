@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Locality types reported in the locality_type field.
+const (
+	localitySuburb   = "suburb"   // gazetted suburb of a city or town
+	localityTown     = "town"     // gazetted town
+	localityLocality = "locality" // gazetted locality, or any delivery area not classified further
+	localityPOBox    = "po_box"   // PO Box or large-volume-receiver only; no street addresses
+)
+
+var localityTypes = []string{localitySuburb, localityTown, localityLocality, localityPOBox}
+
+// poBoxRanges are postcode ranges allocated exclusively to PO Boxes and large-volume
+// receivers, which never have residential street addresses.
+var poBoxRanges = []postcodeRange{
+	{200, 299}, {900, 999}, {1000, 1999}, {5800, 5999}, {6800, 6999}, {7800, 7999}, {8000, 8999}, {9000, 9999},
+}
+
+// classifyLocality infers a row's locality type. The official datafile and the
+// upstream pages don't distinguish suburbs from towns, so unless the dataset says
+// otherwise every delivery area is a generic "locality"; PO Box-only entries are
+// recognised by their category or postcode range.
+func classifyLocality(row PostcodeResult) string {
+	if poBoxCategory(row.Category) || inPOBoxRange(row.Postcode) {
+		return localityPOBox
+	}
+	return localityLocality
+}
+
+// poBoxCategory reports whether a row's category marks it as PO Boxes or a large
+// volume receiver.
+func poBoxCategory(category string) bool {
+	category = strings.ToLower(category)
+	return strings.Contains(category, "box") || strings.Contains(category, "large volume") || strings.Contains(category, "lvr")
+}
+
+// inPOBoxRange reports whether postcode lies in one of the poBoxRanges.
+func inPOBoxRange(postcode string) bool {
+	n := postcodeNumber(postcode)
+	for _, r := range poBoxRanges {
+		if n >= r.From && n <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeLocalityType maps a dataset's locality type column onto the known types,
+// accepting a few spellings used by gazetteer exports. Unknown values return "".
+func normalizeLocalityType(raw string) string {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "suburb":
+		return localitySuburb
+	case "town":
+		return localityTown
+	case "locality":
+		return localityLocality
+	case "po_box", "po box", "pobox", "po boxes", "post office boxes":
+		return localityPOBox
+	default:
+		return ""
+	}
+}
+
+// parseLocalityTypes parses a comma-separated locality type filter like "suburb,town".
+func parseLocalityTypes(raw string) (map[string]bool, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	wanted := map[string]bool{}
+	for _, t := range strings.Split(raw, ",") {
+		t = strings.TrimSpace(t)
+		known := false
+		for _, valid := range localityTypes {
+			known = known || t == valid
+		}
+		if !known {
+			return nil, fmt.Errorf("Invalid 'locality_type' parameter: %q is not one of %s", t, strings.Join(localityTypes, ", "))
+		}
+		wanted[t] = true
+	}
+	return wanted, nil
+}
+
+// filterLocalityTypes keeps only results whose locality type is wanted.
+// A nil filter keeps everything.
+func filterLocalityTypes(results []PostcodeResult, wanted map[string]bool) []PostcodeResult {
+	if wanted == nil {
+		return results
+	}
+	kept := []PostcodeResult{}
+	for _, result := range results {
+		if wanted[result.LocalityType] {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
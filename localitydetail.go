@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// localityRegions are the local government areas and federal electorates a
+// locality lies in. Localities on a boundary can be in more than one of each.
+type localityRegions struct {
+	LGAs        []string
+	Electorates []string
+}
+
+// regionTable maps localities to their regions, from -regions.
+type regionTable struct {
+	regions map[string]*localityRegions // suburbStateKey -> regions
+}
+
+// activeRegions is the loaded region data, or nil when -regions is not set.
+var activeRegions *regionTable
+
+// suburbStateKey identifies a locality by its canonical name and state.
+func suburbStateKey(suburb, state string) string {
+	return canonicalSuburb(suburb) + "|" + strings.ToUpper(strings.TrimSpace(state))
+}
+
+// loadRegions reads a CSV with suburb (or locality), state, lga and electorate
+// columns. A locality in several regions has a row for each.
+func loadRegions(path string) (*regionTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open regions: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read regions header: %w", err)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if field, ok := datasetColumns[name]; ok {
+			name = field
+		}
+		if _, seen := index[name]; !seen {
+			index[name] = i
+		}
+	}
+	for _, required := range []string{"suburb", "state"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("regions header is missing a %s column", required)
+		}
+	}
+	_, hasLGA := index["lga"]
+	_, hasElectorate := index["electorate"]
+	if !hasLGA && !hasElectorate {
+		return nil, errors.New("regions header needs an lga or electorate column")
+	}
+
+	cell := func(record []string, column string) string {
+		if i, ok := index[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	table := &regionTable{regions: map[string]*localityRegions{}}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read regions: %w", err)
+		}
+		suburb := cell(record, "suburb")
+		if suburb == "" {
+			continue
+		}
+		key := suburbStateKey(suburb, cell(record, "state"))
+		regions := table.regions[key]
+		if regions == nil {
+			regions = &localityRegions{}
+			table.regions[key] = regions
+		}
+		if lga := cell(record, "lga"); lga != "" && !slices.Contains(regions.LGAs, lga) {
+			regions.LGAs = append(regions.LGAs, lga)
+		}
+		if electorate := cell(record, "electorate"); electorate != "" && !slices.Contains(regions.Electorates, electorate) {
+			regions.Electorates = append(regions.Electorates, electorate)
+		}
+	}
+	return table, nil
+}
+
+// lookup returns the regions of a locality, or nil when none are known.
+func (t *regionTable) lookup(suburb, state string) *localityRegions {
+	if t == nil {
+		return nil
+	}
+	return t.regions[suburbStateKey(suburb, state)]
+}
+
+// LocalityPostcode is one of a locality's postcodes, with what is known about it.
+type LocalityPostcode struct {
+	Postcode       string `json:"postcode"`
+	Category       string `json:"category"`
+	LocalityType   string `json:"locality_type,omitempty"`
+	DeliveryOffice string `json:"delivery_office,omitempty"`
+	BSPNumber      string `json:"bsp_number,omitempty"`
+	BSPName        string `json:"bsp_name,omitempty"`
+	// Deliverable says whether the locality has street delivery points in the
+	// postcode, when the server has delivery point data.
+	Deliverable *bool `json:"deliverable,omitempty"`
+}
+
+// LocalityLocation is a locality's centre.
+type LocalityLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// LocalityDetail is the response of GET /locality/{state}/{suburb}.
+type LocalityDetail struct {
+	Suburb    string             `json:"suburb"`
+	State     string             `json:"state"`
+	Postcodes []LocalityPostcode `json:"postcodes"`
+	// Aliases are the former names and alternative spellings that resolve to
+	// the locality.
+	Aliases     []string          `json:"aliases"`
+	Location    *LocalityLocation `json:"location,omitempty"`
+	LGAs        []string          `json:"lgas,omitempty"`
+	Electorates []string          `json:"electorates,omitempty"`
+}
+
+// localityHandler handles GET /locality/{state}/{suburb}, gathering everything
+// the server knows about one locality: its postcodes with their category and
+// delivery details, its aliases, its centre, and its local government areas and
+// electorates when -regions is loaded. The suburb may be spelled as in URLs
+// (st-kilda-east) or be one of the locality's aliases.
+func localityHandler(w http.ResponseWriter, r *http.Request) {
+	dataset := requireDataset(w)
+	if dataset == nil {
+		return
+	}
+	state := strings.ToUpper(strings.TrimSpace(r.PathValue("state")))
+	if stateRanges[state] == nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid state: expected a state code such as VIC")
+		return
+	}
+	name := strings.ReplaceAll(r.PathValue("suburb"), "-", " ")
+
+	aliases := requestAliases(r)
+	rows := dataset.rowsForLocality(name, state)
+	if len(rows) == 0 {
+		if alias, ok := aliases.Resolve(name, state); ok {
+			rows = dataset.rowsForLocality(alias.Suburb, state)
+		}
+	}
+	if len(rows) == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("No locality %s in %s is in the dataset", strings.ToUpper(name), state))
+		return
+	}
+
+	detail := LocalityDetail{Suburb: rows[0].Suburb, State: rows[0].State, Postcodes: []LocalityPostcode{}, Aliases: []string{}}
+	for _, row := range rows {
+		detail.Postcodes = append(detail.Postcodes, LocalityPostcode{
+			Postcode:       row.Postcode,
+			Category:       row.Category,
+			LocalityType:   row.LocalityType,
+			DeliveryOffice: row.DeliveryOffice,
+			BSPNumber:      row.BSPNumber,
+			BSPName:        row.BSPName,
+			Deliverable:    activeDeliveryPoints.deliverable(row.Postcode, row.Suburb, row.State),
+		})
+		if detail.Location == nil && row.hasLocation() {
+			detail.Location = &LocalityLocation{Latitude: row.Latitude, Longitude: row.Longitude}
+		}
+	}
+	if aliases != nil {
+		for _, alias := range aliases.aliases {
+			if suburbStateKey(alias.Suburb, alias.State) == suburbStateKey(detail.Suburb, detail.State) {
+				detail.Aliases = append(detail.Aliases, alias.Name)
+			}
+		}
+	}
+	if regions := activeRegions.lookup(detail.Suburb, detail.State); regions != nil {
+		detail.LGAs, detail.Electorates = regions.LGAs, regions.Electorates
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// rowsForLocality returns the rows of a locality, comparing names leniently.
+func (d *Dataset) rowsForLocality(suburb, state string) []PostcodeResult {
+	key := suburbStateKey(suburb, state)
+	rows := []PostcodeResult{}
+	for _, row := range d.Rows {
+		if suburbStateKey(row.Suburb, row.State) == key {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the minimum level logged. It can be changed while the server runs
+// through PUT /admin/log-level.
+var logLevel = new(slog.LevelVar)
+
+// levelPrefixes map the prefixes log lines are written with to their level. Lines
+// without one are informational. log.Fatalf messages start with "Error: ", so
+// no -log-level hides why the server stopped.
+var levelPrefixes = []struct {
+	prefix string
+	level  slog.Level
+}{
+	{"Debug: ", slog.LevelDebug},
+	{"Warning: ", slog.LevelWarn},
+	{"Error: ", slog.LevelError},
+	{"Alert: ", slog.LevelError},
+}
+
+// levelWriter turns lines from the standard logger into records for a slog
+// handler, taking the level from the line's prefix and the request ID from logf's
+// "[id] " prefix, so existing log.Printf calls honour -log-level and -log-format.
+// The prefix is dropped from the message; alerts are marked with alert=true
+// instead.
+type levelWriter struct {
+	handler slog.Handler
+}
+
+func (lw levelWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	var requestID string
+	if strings.HasPrefix(msg, "[") {
+		if id, rest, ok := strings.Cut(msg[1:], "] "); ok && validRequestID(id) {
+			requestID, msg = id, rest
+		}
+	}
+	level, prefix := slog.LevelInfo, ""
+	for _, p := range levelPrefixes {
+		if strings.HasPrefix(msg, p.prefix) {
+			level, prefix = p.level, p.prefix
+			msg = strings.TrimPrefix(msg, p.prefix)
+			break
+		}
+	}
+	if !lw.handler.Enabled(context.Background(), level) {
+		return len(p), nil
+	}
+	record := slog.NewRecord(time.Now(), level, msg, 0)
+	if requestID != "" {
+		record.AddAttrs(slog.String("request_id", requestID))
+	}
+	if prefix == "Alert: " {
+		record.AddAttrs(slog.Bool("alert", true))
+	}
+	return len(p), lw.handler.Handle(context.Background(), record)
+}
+
+// parseLogLevel accepts debug, info, warn (or warning) and error.
+func parseLogLevel(name string) (slog.Level, error) {
+	if strings.EqualFold(name, "warning") {
+		name = "warn"
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q: use debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// setupLogging sends the standard logger's output through a text or JSON handler
+// that drops lines below the level.
+func setupLogging(level, format string) error {
+	parsed, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(parsed)
+
+	options := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("unknown log format %q: use text or json", format)
+	}
+	log.SetFlags(0)
+	log.SetOutput(levelWriter{handler: handler})
+	return nil
+}
+
+// debugf logs scrape internals at debug level. The arguments aren't formatted
+// unless debug logging is on.
+func debugf(ctx context.Context, format string, args ...any) {
+	if logLevel.Level() > slog.LevelDebug {
+		return
+	}
+	logf(ctx, "Debug: "+format, args...)
+}
+
+// levelReset reverts a temporary log level change when its time is up.
+var levelReset struct {
+	sync.Mutex
+	timer     *time.Timer
+	restoreTo slog.Level
+}
+
+// logLevelRequest is the body of PUT /admin/log-level.
+type logLevelRequest struct {
+	Level string `json:"level"`
+	// For, when set, is how long the level applies before the previous one is
+	// restored, e.g. "15m".
+	For string `json:"for"`
+}
+
+// logLevelHandler handles GET and PUT /admin/log-level. PUT sets the level from a
+// body like {"level": "debug", "for": "15m"}; without "for" the change lasts until
+// the next one or a restart.
+func logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, map[string]string{"level": strings.ToLower(logLevel.Level().String())})
+		return
+	}
+
+	var req logLevelRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	level, err := parseLogLevel(req.Level)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBodyInvalid, err.Error())
+		return
+	}
+	var duration time.Duration
+	if req.For != "" {
+		if duration, err = time.ParseDuration(req.For); err != nil || duration <= 0 {
+			writeError(w, http.StatusBadRequest, codeBodyInvalid, "Invalid 'for': expected a positive duration such as 15m")
+			return
+		}
+	}
+
+	levelReset.Lock()
+	defer levelReset.Unlock()
+	previous := logLevel.Level()
+	base := previous // the level to go back to after a temporary change
+	if levelReset.timer != nil {
+		levelReset.timer.Stop()
+		levelReset.timer = nil
+		base = levelReset.restoreTo
+	}
+	logLevel.Set(level)
+	details := map[string]any{"from": strings.ToLower(previous.String()), "to": strings.ToLower(level.String())}
+	if duration > 0 {
+		levelReset.restoreTo = base
+		var timer *time.Timer
+		timer = time.AfterFunc(duration, func() {
+			levelReset.Lock()
+			defer levelReset.Unlock()
+			if levelReset.timer != timer {
+				return // superseded by a later change
+			}
+			logLevel.Set(base)
+			levelReset.timer = nil
+			log.Printf("Log level restored to %s", strings.ToLower(base.String()))
+		})
+		levelReset.timer = timer
+		details["for"] = duration.String()
+	}
+	auditTrail.recordRequest(r, "log.level", details)
+	response := map[string]string{"level": strings.ToLower(level.String())}
+	if duration > 0 {
+		response["for"] = duration.String()
+	}
+	writeJSON(w, http.StatusOK, response)
+}
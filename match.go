@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"time"
+)
+
+// Pattern match modes accepted by the ?match= parameter.
+const (
+	matchModeRegex    = "regex"
+	matchModeWildcard = "wildcard"
+)
+
+// Guardrails for user-supplied patterns. Go's regexp engine runs in linear time, so
+// these bound the size of the compiled program and the total scan time rather than
+// guarding against backtracking.
+const (
+	maxPatternLength   = 100
+	maxPatternInsts    = 2000
+	patternScanTimeout = 250 * time.Millisecond
+)
+
+// errPatternTimeout is returned when matching a pattern takes longer than patternScanTimeout.
+var errPatternTimeout = errors.New("Pattern took too long to evaluate; please use a simpler pattern")
+
+// compileSuburbPattern compiles a regex or wildcard pattern into a case-insensitive
+// regular expression for matching suburb names. Wildcard patterns must match the
+// whole name: '*' matches any run of characters and '?' matches exactly one.
+func compileSuburbPattern(mode, pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > maxPatternLength {
+		return nil, fmt.Errorf("Pattern is too long: maximum length is %d characters", maxPatternLength)
+	}
+
+	expr := pattern
+	if mode == matchModeWildcard {
+		expr = wildcardToRegex(pattern)
+	}
+	expr = "(?i)" + expr
+
+	// Reject patterns whose compiled program is unreasonably large, e.g. nested
+	// counted repetitions like (a{100}){100}.
+	parsed, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid pattern: %s", err)
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid pattern: %s", err)
+	}
+	if len(prog.Inst) > maxPatternInsts {
+		return nil, errors.New("Pattern is too complex")
+	}
+
+	return regexp.Compile(expr)
+}
+
+// wildcardToRegex translates a wildcard pattern such as "north*" into an anchored
+// regular expression, escaping every other character literally.
+func wildcardToRegex(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// Match returns the rows whose suburb matches the pattern. It gives up with
+// errPatternTimeout once ctx is done.
+func (d *Dataset) Match(ctx context.Context, pattern *regexp.Regexp) ([]PostcodeResult, error) {
+	results := []PostcodeResult{}
+	for i, row := range d.Rows {
+		// Checking the context on every row is wasteful; every few hundred is plenty.
+		if i%256 == 0 && ctx.Err() != nil {
+			return nil, errPatternTimeout
+		}
+		if pattern.MatchString(row.Suburb) {
+			results = append(results, row)
+		}
+	}
+	return results, nil
+}
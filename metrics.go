@@ -0,0 +1,42 @@
+package main
+
+import "expvar"
+
+// parseMetrics count how well upstream results pages parse. They are published
+// under "parse" on the admin listener's /debug/vars, so dashboards can follow
+// parser health apart from HTTP error rates.
+var parseMetrics = expvar.NewMap("parse")
+
+// selectorHits counts the pages each table selector found the results table on.
+var selectorHits = new(expvar.Map)
+
+func init() {
+	parseMetrics.Set("selectors", selectorHits)
+}
+
+// lookupMetrics count dataset lookups answered without scanning the data, under
+// "lookups" on /debug/vars. bloom_rejections is the number turned away by the
+// dataset's Bloom filter.
+var lookupMetrics = expvar.NewMap("lookups")
+
+// recordParse adds one parsed search page to the parse metrics.
+func recordParse(results, warnings int, parse tableParse) {
+	parseMetrics.Add("pages", 1)
+	parseMetrics.Add("rows_matched", int64(results))
+	parseMetrics.Add("rows_skipped", int64(parse.Skipped))
+	parseMetrics.Add("warnings", int64(warnings))
+	if results == 0 {
+		parseMetrics.Add("zero_match_pages", 1)
+	}
+	switch parse.Selector {
+	case "":
+		if !parse.NoResults {
+			parseMetrics.Add("table_missing", 1)
+		}
+	case postcodeTableSelector:
+		selectorHits.Add(parse.Selector, 1)
+	default:
+		parseMetrics.Add("selector_fallbacks", 1)
+		selectorHits.Add(parse.Selector, 1)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsNamespace prefixes every metric on /metrics.
+const metricsNamespace = "postcode_scraper"
+
+// statsdPacketSize keeps statsd datagrams within a typical Ethernet MTU.
+const statsdPacketSize = 1432
+
+// labelledMetrics are the expvar maps exported as one metric with a label per
+// key, rather than one metric per key, because their keys aren't names.
+var labelledMetrics = map[string]string{"parse_selectors": "selector"}
+
+// metricSample is one numeric value published on /debug/vars.
+type metricSample struct {
+	name    string // e.g. "parse_pages"
+	label   string // the label name for labelledMetrics, otherwise empty
+	value   string // the label value
+	number  float64
+	counter bool // counters only ever grow; everything else is a gauge
+}
+
+// collectMetrics gathers the numeric expvar values, plus a few runtime gauges.
+// expvar.Int and expvar.Float values are counters; numbers from expvar.Func are
+// gauges. Non-numeric vars such as memstats and cmdline are left out.
+func collectMetrics() []metricSample {
+	var samples []metricSample
+	expvar.Do(func(kv expvar.KeyValue) {
+		samples = appendMetric(samples, metricName(kv.Key), kv.Value)
+	})
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return append(samples,
+		metricSample{name: "go_goroutines", number: float64(runtime.NumGoroutine())},
+		metricSample{name: "go_heap_alloc_bytes", number: float64(mem.HeapAlloc)},
+	)
+}
+
+func appendMetric(samples []metricSample, name string, v expvar.Var) []metricSample {
+	switch v := v.(type) {
+	case *expvar.Int:
+		return append(samples, metricSample{name: name, number: float64(v.Value()), counter: true})
+	case *expvar.Float:
+		return append(samples, metricSample{name: name, number: v.Value(), counter: true})
+	case *expvar.Map:
+		label, labelled := labelledMetrics[name]
+		v.Do(func(kv expvar.KeyValue) {
+			if !labelled {
+				samples = appendMetric(samples, name+"_"+metricName(kv.Key), kv.Value)
+				return
+			}
+			for _, sample := range appendMetric(nil, name, kv.Value) {
+				sample.label, sample.value = label, kv.Key
+				samples = append(samples, sample)
+			}
+		})
+	case expvar.Func:
+		switch n := v.Value().(type) {
+		case int:
+			return append(samples, metricSample{name: name, number: float64(n)})
+		case int64:
+			return append(samples, metricSample{name: name, number: float64(n)})
+		case float64:
+			return append(samples, metricSample{name: name, number: n})
+		}
+	}
+	return samples
+}
+
+// metricName turns an expvar key into a metric name: lower case letters, digits
+// and underscores.
+func metricName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, key)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetricsText writes samples in the Prometheus text format, or in
+// OpenMetrics when openMetrics is set. The two differ only in how counters are
+// typed and in OpenMetrics' closing "# EOF".
+func writeMetricsText(w io.Writer, samples []metricSample, openMetrics bool) {
+	typed := map[string]bool{}
+	for _, sample := range samples {
+		name := metricsNamespace + "_" + sample.name
+		family, kind := name, "gauge"
+		if sample.counter {
+			kind = "counter"
+			if name += "_total"; !openMetrics {
+				family = name
+			}
+		}
+		if !typed[family] {
+			typed[family] = true
+			fmt.Fprintf(w, "# TYPE %s %s\n", family, kind)
+		}
+		if sample.label != "" {
+			name += fmt.Sprintf(`{%s="%s"}`, sample.label, labelValueEscaper.Replace(sample.value))
+		}
+		fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(sample.number, 'f', -1, 64))
+	}
+	if openMetrics {
+		io.WriteString(w, "# EOF\n")
+	}
+}
+
+// metricsHandler handles GET /metrics on the admin listener: the counters of
+// /debug/vars for a Prometheus scrape. Scrapers that ask for OpenMetrics get it.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	writeMetricsText(w, collectMetrics(), openMetrics)
+}
+
+// statsdPusher pushes the metrics to a statsd agent over UDP, for environments
+// without a Prometheus server. Counters are sent as the increase since the last
+// push and gauges as their value. With tags set it speaks DogStatsD, sending
+// labels as tags; otherwise labels are folded into the metric name.
+type statsdPusher struct {
+	conn   net.Conn
+	prefix string
+	tags   []string
+	last   map[string]float64 // counter values at the last push, by statsd name and tags
+}
+
+func newStatsdPusher(addr, prefix, tags string) (*statsdPusher, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial statsd: %w", err)
+	}
+	pusher := &statsdPusher{conn: conn, prefix: prefix, last: map[string]float64{}}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			pusher.tags = append(pusher.tags, tag)
+		}
+	}
+	return pusher, nil
+}
+
+// push sends the current metrics, batched into as few datagrams as fit.
+func (p *statsdPusher) push() error {
+	var packet bytes.Buffer
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := p.conn.Write(bytes.TrimSuffix(packet.Bytes(), []byte("\n")))
+		packet.Reset()
+		return err
+	}
+
+	for _, sample := range collectMetrics() {
+		name, tags := sample.name, p.tags
+		if p.prefix != "" {
+			name = p.prefix + "." + name
+		}
+		if sample.label != "" {
+			if p.tags != nil {
+				tags = append(tags[:len(tags):len(tags)], sample.label+":"+sample.value)
+			} else {
+				name += "." + metricName(sample.value)
+			}
+		}
+		series := name + "|" + strings.Join(tags, ",")
+
+		value, kind := sample.number, "g"
+		if sample.counter {
+			value, kind = sample.number-p.last[series], "c"
+			p.last[series] = sample.number
+			if value == 0 {
+				continue
+			}
+		}
+		line := fmt.Sprintf("%s:%s|%s", name, strconv.FormatFloat(value, 'f', -1, 64), kind)
+		if len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+
+		if packet.Len()+len(line) >= statsdPacketSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		packet.WriteString(line + "\n")
+	}
+	return flush()
+}
+
+// loop pushes the metrics every interval until ctx is done.
+func (p *statsdPusher) loop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.push(); err != nil {
+				log.Printf("Warning: failed to push metrics to statsd: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Server-level timeouts. Handler timeouts are configured per endpoint; these stop
+// slow or idle clients from holding connections open.
+const (
+	readHeaderTimeout = 5 * time.Second
+	readTimeout       = 30 * time.Second
+	idleTimeout       = 60 * time.Second
+	// writeTimeoutSlack is added to the longest handler timeout so the server never
+	// cuts off a response that the handler timeout would have allowed.
+	writeTimeoutSlack = 5 * time.Second
+)
+
+// endpointTimeouts holds the handler timeout for each endpoint, falling back to a
+// global default.
+type endpointTimeouts struct {
+	fallback  time.Duration
+	overrides map[string]time.Duration
+}
+
+// parseEndpointTimeouts parses overrides like "/search=20s,/postcodes=5s".
+func parseEndpointTimeouts(fallback time.Duration, spec string) (endpointTimeouts, error) {
+	t := endpointTimeouts{fallback: fallback, overrides: map[string]time.Duration{}}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		path, raw, ok := strings.Cut(entry, "=")
+		if !ok {
+			return t, fmt.Errorf("invalid endpoint timeout %q: expected PATH=DURATION", entry)
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return t, fmt.Errorf("invalid endpoint timeout %q: %q is not a positive duration", entry, raw)
+		}
+		t.overrides[path] = d
+	}
+	return t, nil
+}
+
+// forPattern returns the timeout for a mux pattern such as "GET /states/{state}/postcodes".
+// Overrides are keyed by the pattern's path.
+func (t endpointTimeouts) forPattern(pattern string) time.Duration {
+	path := pattern
+	if i := strings.Index(pattern, " "); i >= 0 {
+		path = pattern[i+1:]
+	}
+	if d, ok := t.overrides[path]; ok {
+		return d
+	}
+	return t.fallback
+}
+
+// longest returns the largest configured handler timeout.
+func (t endpointTimeouts) longest() time.Duration {
+	longest := t.fallback
+	for _, d := range t.overrides {
+		longest = max(longest, d)
+	}
+	return longest
+}
+
+// withTimeout bounds how long next may take. When the deadline passes the client
+// gets a 503 with a JSON error body, and the request context is cancelled, which
+// aborts any upstream fetch still in flight. A shorter X-Timeout-Ms from the
+// client becomes the context's deadline, for handlers to answer early.
+func withTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	body := timeoutBody(timeout)
+	// The client's deadline is applied inside TimeoutHandler, which would otherwise
+	// answer for the handler as soon as it passed.
+	timed := http.TimeoutHandler(withClientTimeout(timeout, next), timeout, body)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// TimeoutHandler writes its timeout body straight to w, so set the content
+		// type up front; responses that complete in time set their own headers.
+		w.Header().Set("Content-Type", "application/json")
+		timed.ServeHTTP(w, r)
+	})
+}
+
+// timeoutBody is the JSON error body of a request that ran past its timeout.
+func timeoutBody(timeout time.Duration) string {
+	return fmt.Sprintf(`{"error":"Request timed out after %s","code":"%s"}`, timeout, codeRequestTimeout)
+}
+
+// withDeadline bounds next with a context deadline instead of TimeoutHandler,
+// which holds a copy of the whole response until the handler returns, so listing
+// endpoints' bodies go to the client as they are written. A handler that only starts
+// writing after the deadline gets withTimeout's 503 instead; one already
+// streaming is left to finish, bounded by the server's WriteTimeout.
+func withDeadline(timeout time.Duration, next http.Handler) http.Handler {
+	body := timeoutBody(timeout)
+	next = withClientTimeout(timeout, next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(&deadlineWriter{ResponseWriter: w, ctx: ctx, body: body}, r.WithContext(ctx))
+	})
+}
+
+// deadlineWriter replaces a response begun after its context's deadline with a
+// timeout error, discarding whatever the handler writes.
+type deadlineWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	body    string
+	started bool
+	expired bool
+}
+
+func (w *deadlineWriter) WriteHeader(status int) {
+	if w.start() {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	if !w.start() {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// start is called before the response is first written, and reports whether the
+// handler's response may go out.
+func (w *deadlineWriter) start() bool {
+	if w.started {
+		return !w.expired
+	}
+	w.started = true
+	if errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		w.expired = true
+		header := w.ResponseWriter.Header()
+		header.Del("Content-Length")
+		header.Set("Content-Type", "application/json")
+		w.ResponseWriter.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w.ResponseWriter, w.body)
+	}
+	return !w.expired
+}
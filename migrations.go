@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+const (
+	// metaBucket holds the store's own bookkeeping, such as its schema version.
+	metaBucket       = "meta"
+	schemaVersionKey = "schema_version"
+)
+
+// migration upgrades the store's data from the previous schema version to
+// version. Migrations run in order, once each, and must leave the store usable
+// if the process dies partway through, as the version is only recorded after
+// apply returns.
+type migration struct {
+	version     int
+	description string
+	apply       func(Store) error
+}
+
+// migrations are the schema changes in release order. Append new ones with the
+// next version; never edit or reorder one that has shipped.
+var migrations = []migration{
+	{1, "baseline: buckets as of the first versioned release", func(Store) error { return nil }},
+}
+
+// schemaVersion returns the version recorded in the store, 0 for a store that
+// predates versioning or is new.
+func schemaVersion(store Store) (int, error) {
+	raw, ok, err := store.Get(metaBucket, schemaVersionKey)
+	if err != nil || !ok {
+		return 0, err
+	}
+	version, err := strconv.Atoi(string(raw))
+	if err != nil {
+		return 0, fmt.Errorf("corrupt schema version %q", raw)
+	}
+	return version, nil
+}
+
+// migrateStore applies the migrations the store hasn't had yet and returns how
+// many ran. A store written by a newer release is refused rather than risked.
+func migrateStore(store Store) (int, error) {
+	current, err := schemaVersion(store)
+	if err != nil {
+		return 0, err
+	}
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return 0, fmt.Errorf("store schema version %d is newer than this release supports (%d)", current, latest)
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		log.Printf("Migrating store to schema version %d: %s", m.version, m.description)
+		if err := m.apply(store); err != nil {
+			return applied, fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+		if err := store.Put(metaBucket, schemaVersionKey, []byte(strconv.Itoa(m.version))); err != nil {
+			return applied, fmt.Errorf("record schema version %d: %w", m.version, err)
+		}
+		applied++
+	}
+	return applied, nil
+}
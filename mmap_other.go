@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// mapFile reads a file into memory on platforms without mmap support here; the
+// garbage collector frees it, so unmap does nothing.
+func mapFile(path string) (data []byte, unmap func() error, err error) {
+	data, err = os.ReadFile(path)
+	return data, func() error { return nil }, err
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// mockFixtures are the canned upstream pages the mockserver command serves by
+// default: search pages named by their keyword slug (sydney.html, 2000.html) and
+// locality detail pages by state and slug (nsw/sydney.html).
+//
+//go:embed fixtures
+var mockFixtures embed.FS
+
+// maxMockNearby is how many nearby suburbs a generated detail page lists.
+const maxMockNearby = 8
+
+// mockPages renders auspost-style pages from a dataset, for keywords and
+// localities without a fixture.
+var mockPages = template.Must(template.New("search").Funcs(template.FuncMap{"localityPath": mockLocalityPath}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>{{.Keyword}} - Postcode search | Australia Post</title></head>
+<body>
+<main>
+<h1>Postcode results for '{{.Keyword}}'</h1>
+{{if .Rows}}<table class="resultsList fn_tableResultsList fn_tablePostcodeList">
+<thead><tr><th>Postcode</th><th>Suburb</th><th>Category</th></tr></thead>
+<tbody>
+{{range .Rows}}<tr><td>{{.Postcode}}</td><td><a href="{{localityPath .}}">{{.Suburb}}, {{.State}}</a></td><td>{{.Category}}</td></tr>
+{{end}}</tbody>
+</table>{{else}}<p>Sorry, we couldn't find any results.</p>{{end}}
+</main>
+</body>
+</html>
+{{define "locality"}}<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>{{.Locality.Suburb}}, {{.Locality.State}} {{.Locality.Postcode}} - Postcode | Australia Post</title></head>
+<body>
+<main>
+<h1>{{.Locality.Suburb}}, {{.Locality.State}} {{.Locality.Postcode}}</h1>
+<section>
+<h2>Nearby suburbs</h2>
+<ul>
+{{range .Nearby}}<li><a href="{{localityPath .}}">{{.Suburb}}, {{.State}} {{.Postcode}}</a></li>
+{{end}}</ul>
+</section>
+</main>
+</body>
+</html>
+{{end}}`))
+
+// mockLocalityPath is the detail page link of a row, like /postcode/vic/st-kilda-east.
+func mockLocalityPath(row PostcodeResult) string {
+	return "/postcode/" + strings.ToLower(row.State) + "/" + strings.Join(tokenize(row.Suburb), "-")
+}
+
+// mockUpstream serves canned pages in place of the upstream site.
+type mockUpstream struct {
+	fixtures fs.FS
+	dataset  *Dataset // generates pages without a fixture; may be nil
+	latency  time.Duration
+}
+
+func (m *mockUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(m.latency)
+	log.Printf("%s %s", r.Method, r.URL.Path)
+
+	if r.URL.Path == "/robots.txt" {
+		m.serveFixture(w, "robots.txt", "text/plain; charset=utf-8")
+		return
+	}
+	slug, ok := strings.CutPrefix(path.Clean(r.URL.Path), "/postcode/")
+	if !ok || slug == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if m.serveFixture(w, slug+".html", "text/html; charset=utf-8") {
+		return
+	}
+	if m.dataset == nil {
+		// The upstream answers unknown keywords with a page that has no results.
+		m.render(w, "search", map[string]any{"Keyword": slug})
+		return
+	}
+
+	if state, suburb, isLocality := strings.Cut(slug, "/"); isLocality {
+		m.serveLocality(w, r, strings.ToUpper(state), strings.ReplaceAll(suburb, "-", " "))
+		return
+	}
+	keyword := strings.ReplaceAll(slug, "-", " ")
+	rows := m.dataset.Search(keyword)
+	if fourDigits.MatchString(keyword) {
+		rows = m.dataset.RowsForPostcode(keyword)
+	}
+	m.render(w, "search", map[string]any{"Keyword": keyword, "Rows": rows})
+}
+
+// serveFixture writes the named fixture, reporting false when there is none.
+func (m *mockUpstream) serveFixture(w http.ResponseWriter, name, contentType string) bool {
+	page, err := fs.ReadFile(m.fixtures, name)
+	if err != nil {
+		return false
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(page)
+	return true
+}
+
+// serveLocality renders a detail page for a dataset locality, listing the
+// nearest localities when the dataset has coordinates and others sharing its
+// postcode otherwise.
+func (m *mockUpstream) serveLocality(w http.ResponseWriter, r *http.Request, state, suburb string) {
+	var locality *PostcodeResult
+	for _, row := range m.dataset.Rows {
+		if row.State == state && strings.Join(tokenize(row.Suburb), " ") == suburb {
+			locality = &row
+			break
+		}
+	}
+	if locality == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	nearby := []PostcodeResult{}
+	if locality.hasLocation() && m.dataset.locations != nil {
+		for _, hit := range m.dataset.Nearest(locality.Latitude, locality.Longitude, maxMockNearby+1) {
+			if hit.Suburb != locality.Suburb {
+				nearby = append(nearby, hit.PostcodeResult)
+			}
+		}
+	} else {
+		for _, row := range m.dataset.RowsForPostcode(locality.Postcode) {
+			if row.Suburb != locality.Suburb && len(nearby) < maxMockNearby {
+				nearby = append(nearby, row)
+			}
+		}
+	}
+	m.render(w, "locality", map[string]any{"Locality": locality, "Nearby": nearby})
+}
+
+func (m *mockUpstream) render(w http.ResponseWriter, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := mockPages.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("Warning: failed to render mock %s page: %v", name, err)
+	}
+}
+
+// runMockServer implements the mockserver command, which serves auspost-style
+// pages so the full scrape path can be exercised without touching the real site:
+//
+//	mockserver -addr localhost:8090 [-fixtures DIR] [-dataset FILE]
+//
+// Point the API at it with -upstream-url http://localhost:8090/postcode/.
+func runMockServer(args []string) error {
+	fset := flag.NewFlagSet("mockserver", flag.ExitOnError)
+	addr := fset.String("addr", "localhost:8090", "Address to serve the mock upstream on")
+	fixturesDir := fset.String("fixtures", "", "Directory of fixture pages to serve instead of the built-in ones")
+	datasetPath := fset.String("dataset", "", "Postcode CSV to generate pages from for keywords without a fixture")
+	latency := fset.Duration("latency", 0, "Delay before each response, to mimic a slow upstream")
+	fset.Parse(args)
+	if fset.NArg() != 0 {
+		return errors.New("usage: mockserver [-addr HOST:PORT] [-fixtures DIR] [-dataset FILE] [-latency DURATION]")
+	}
+
+	mock := &mockUpstream{latency: *latency}
+	if *fixturesDir != "" {
+		mock.fixtures = os.DirFS(*fixturesDir)
+	} else {
+		mock.fixtures, _ = fs.Sub(mockFixtures, "fixtures")
+	}
+	if *datasetPath != "" {
+		dataset, err := loadDataset(*datasetPath)
+		if err != nil {
+			return err
+		}
+		mock.dataset = dataset
+		log.Printf("Generating pages from %d rows of %s", len(dataset.Rows), *datasetPath)
+	}
+
+	log.Printf("Mock upstream listening on http://%s/postcode/", *addr)
+	if err := http.ListenAndServe(*addr, mock); err != nil {
+		return fmt.Errorf("serve: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// privateAliasesBucket is the store bucket holding each API key's private aliases,
+// keyed by the key's name.
+const privateAliasesBucket = "aliases"
+
+// requestNamespace returns the namespace r's private zones and aliases live in:
+// the name of the API key it was made with or, for a JWT, "jwt:" and the client
+// the token was issued to, or its subject when it names no client. Requests with
+// neither, because authentication is off, get "", the shared namespace.
+func requestNamespace(r *http.Request) string {
+	if client, ok := requestAPIKey(r); ok {
+		return client.Name
+	}
+	if claims, ok := requestJWT(r); ok {
+		for _, id := range []string{claims.ClientID, claims.AuthorizedParty, claims.Subject} {
+			if id != "" {
+				return "jwt:" + id
+			}
+		}
+	}
+	return ""
+}
+
+// privateAliasTable is a key's private aliases combined with the shared table they
+// were merged into.
+type privateAliasTable struct {
+	shared *aliasTable
+	merged *aliasTable
+}
+
+// aliasRegistry persists each API key's private aliases in the store and caches
+// their merged tables, rebuilding one when its aliases or the shared table change.
+type aliasRegistry struct {
+	store  Store
+	mu     sync.Mutex
+	tables map[string]privateAliasTable
+}
+
+// privateAliases is the configured registry; main points it at the store.
+var privateAliases *aliasRegistry
+
+func (a *aliasRegistry) get(namespace string) ([]Alias, error) {
+	raw, ok, err := a.store.Get(privateAliasesBucket, namespace)
+	if err != nil || !ok {
+		return []Alias{}, err
+	}
+	var aliases []Alias
+	if err := json.Unmarshal(raw, &aliases); err != nil {
+		return nil, fmt.Errorf("corrupt aliases for %s: %w", namespace, err)
+	}
+	return aliases, nil
+}
+
+// put replaces a namespace's aliases; an empty list deletes them.
+func (a *aliasRegistry) put(namespace string, aliases []Alias) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.tables, namespace)
+	if len(aliases) == 0 {
+		return a.store.Delete(privateAliasesBucket, namespace)
+	}
+	raw, err := json.Marshal(aliases)
+	if err != nil {
+		return err
+	}
+	return a.store.Put(privateAliasesBucket, namespace, raw)
+}
+
+// table returns the aliases a namespace searches with: its private aliases ahead
+// of the shared ones, so a private alias wins when both name the same suburb. A
+// namespace without private aliases gets the shared table itself.
+func (a *aliasRegistry) table(namespace string, shared *aliasTable) (*aliasTable, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cached, ok := a.tables[namespace]; ok && cached.shared == shared {
+		return cached.merged, nil
+	}
+
+	aliases, err := a.get(namespace)
+	if err != nil {
+		return nil, err
+	}
+	merged := shared
+	if len(aliases) > 0 {
+		if shared != nil {
+			aliases = append(aliases, shared.aliases...)
+		}
+		merged = newAliasTable(aliases)
+	}
+	a.tables[namespace] = privateAliasTable{shared: shared, merged: merged}
+	return merged, nil
+}
+
+// requestAliases returns the alias table for r: the shared one, with the
+// namespace's private aliases added when it has any. Store failures are logged and fall back
+// to the shared table.
+func requestAliases(r *http.Request) *aliasTable {
+	shared := currentAliases()
+	namespace := requestNamespace(r)
+	if namespace == "" || privateAliases == nil {
+		return shared
+	}
+	table, err := privateAliases.table(namespace, shared)
+	if err != nil {
+		logf(r.Context(), "Warning: failed to load private aliases for '%s': %v", namespace, err)
+		return shared
+	}
+	return table
+}
+
+// requireNamespace returns r's namespace, or writes a 404 and returns false for
+// requests in the shared one, which have no private aliases.
+func requireNamespace(w http.ResponseWriter, r *http.Request) (string, bool) {
+	namespace := requestNamespace(r)
+	if namespace == "" {
+		writeError(w, http.StatusNotFound, codeNotFound, "Private aliases belong to an API key or token; send the request with X-API-Key or a bearer token")
+		return "", false
+	}
+	return namespace, true
+}
+
+// listAliasesHandler handles GET /aliases, listing the calling key's private aliases.
+func listAliasesHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := requireNamespace(w, r)
+	if !ok {
+		return
+	}
+	aliases, err := privateAliases.get(namespace)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"namespace": namespace, "aliases": aliases})
+}
+
+// replaceAliasesHandler handles PUT /aliases, replacing the calling key's private
+// aliases with a body like {"aliases": [{"alias": "Fishermens Bend", "suburb":
+// "Port Melbourne", "state": "VIC"}]}. Searches and validation made with the key
+// resolve them alongside the shared -aliases file.
+func replaceAliasesHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := requireNamespace(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Aliases []Alias `json:"aliases"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if err := checkBatchSize(len(req.Aliases)); err != nil {
+		writeError(w, http.StatusBadRequest, codeBatchTooLarge, err.Error())
+		return
+	}
+	for i, alias := range req.Aliases {
+		alias = Alias{Name: strings.TrimSpace(alias.Name), Suburb: strings.TrimSpace(alias.Suburb), State: strings.ToUpper(strings.TrimSpace(alias.State))}
+		if alias.Name == "" || alias.Suburb == "" {
+			writeError(w, http.StatusBadRequest, codeBodyInvalid, fmt.Sprintf("Alias %d needs an 'alias' and a 'suburb'", i+1))
+			return
+		}
+		if stateRanges[alias.State] == nil {
+			writeError(w, http.StatusBadRequest, codeBodyInvalid, fmt.Sprintf("Alias %d has an invalid 'state': expected a state code such as VIC", i+1))
+			return
+		}
+		req.Aliases[i] = alias
+	}
+	if req.Aliases == nil {
+		req.Aliases = []Alias{}
+	}
+
+	if err := privateAliases.put(namespace, req.Aliases); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to save the aliases: %s", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"namespace": namespace, "aliases": req.Aliases})
+}
+
+// deleteAliasesHandler handles DELETE /aliases, removing the calling key's private
+// aliases.
+func deleteAliasesHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, ok := requireNamespace(w, r)
+	if !ok {
+		return
+	}
+	if err := privateAliases.put(namespace, nil); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to delete the aliases: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// NearbySuburbs is the response of GET /suburb/{name}/nearby.
+type NearbySuburbs struct {
+	Suburb   string           `json:"suburb"`
+	State    string           `json:"state"`
+	Postcode string           `json:"postcode"`
+	Nearby   []PostcodeResult `json:"nearby"`
+}
+
+// nearbyCache holds recent nearby-suburb lookups; it is configured in main.
+var nearbyCache *resultCache[NearbySuburbs]
+
+// errLocalityNotFound is returned when no search result matches the requested suburb.
+var errLocalityNotFound = errors.New("locality not found")
+
+// localityTextPattern parses list items such as "RICHMOND NORTH, VIC 3121" or
+// "Cremorne VIC 3121"; the state and postcode are optional.
+var localityTextPattern = regexp.MustCompile(`(?i)^\s*(.+?)[,\s]+(ACT|NSW|NT|QLD|SA|TAS|VIC|WA)\b\s*(\d{4})?\s*$`)
+
+// parseLocalityText splits a locality's display text into its parts.
+func parseLocalityText(text string) PostcodeResult {
+	text = strings.Join(strings.Fields(text), " ")
+	if m := localityTextPattern.FindStringSubmatch(text); m != nil {
+		return PostcodeResult{Suburb: strings.TrimSpace(m[1]), State: strings.ToUpper(m[2]), Postcode: m[3]}
+	}
+	return PostcodeResult{Suburb: text}
+}
+
+// parseNearbySuburbs extracts the "Nearby suburbs" list from a locality detail page:
+// the first list following a heading that mentions "nearby".
+func parseNearbySuburbs(doc *goquery.Document) []PostcodeResult {
+	nearby := []PostcodeResult{}
+	doc.Find("h1, h2, h3, h4, h5, h6").EachWithBreak(func(_ int, heading *goquery.Selection) bool {
+		if !strings.Contains(strings.ToLower(heading.Text()), "nearby") {
+			return true
+		}
+
+		// The list is usually a sibling of the heading, but may be wrapped in a container.
+		list := heading.NextAllFiltered("ul, ol").First()
+		if list.Length() == 0 {
+			list = heading.Parent().Find("ul, ol").First()
+		}
+		list.Find("li").Each(func(_ int, item *goquery.Selection) {
+			if locality := parseLocalityText(item.Text()); locality.Suburb != "" {
+				nearby = append(nearby, locality)
+			}
+		})
+		return false
+	})
+	return nearby
+}
+
+// scrapeNearbySuburbs finds the named suburb (optionally within a state) in the
+// upstream search results, follows its link to the locality detail page, and
+// returns the nearby suburbs listed there.
+func scrapeNearbySuburbs(ctx context.Context, name, state string) (NearbySuburbs, error) {
+	results, _, err := searchPostcodes(ctx, name)
+	if err != nil {
+		return NearbySuburbs{}, err
+	}
+	rankResults(results, name, false)
+
+	var locality *PostcodeResult
+	for i, result := range results {
+		if matchTier(result.Suburb, name) == matchExact && result.detailURL != "" &&
+			(state == "" || strings.EqualFold(result.State, state)) {
+			locality = &results[i]
+			break
+		}
+	}
+	if locality == nil {
+		return NearbySuburbs{}, errLocalityNotFound
+	}
+
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return NearbySuburbs{}, err
+	}
+	link, err := url.Parse(locality.detailURL)
+	if err != nil {
+		return NearbySuburbs{}, fmt.Errorf("Invalid detail page link %q: %s", locality.detailURL, err)
+	}
+
+	// The detail page is a second upstream fetch, so it is charged separately.
+	if err := reserveScrape(ctx); err != nil {
+		return NearbySuburbs{}, err
+	}
+	doc, err := fetchDocument(ctx, base.ResolveReference(link).String())
+	if err != nil {
+		return NearbySuburbs{}, err
+	}
+
+	return NearbySuburbs{
+		Suburb:   locality.Suburb,
+		State:    locality.State,
+		Postcode: locality.Postcode,
+		Nearby:   parseNearbySuburbs(doc),
+	}, nil
+}
+
+// nearbyHandler handles GET /suburb/{name}/nearby?state=VIC, listing the suburbs
+// the upstream locality page shows as nearby. Add state when the name is ambiguous.
+func nearbyHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.PathValue("name"))
+	state := r.URL.Query().Get("state")
+	if err := checkKeywordLength(name); err != nil {
+		writeError(w, http.StatusBadRequest, codeKeywordInvalid, err.Error())
+		return
+	}
+
+	key := name + "|" + state
+	if cached, ok := nearbyCache.get(r.Context(), key); ok {
+		writeJSON(w, http.StatusOK, cached)
+		return
+	}
+
+	if !allowScrape(w, r) {
+		return
+	}
+	nearby, err := scrapeNearbySuburbs(r.Context(), name, state)
+	var exhausted *budgetExhaustedError
+	if errors.As(err, &exhausted) {
+		writeBudgetExhausted(w, exhausted)
+		return
+	}
+	if errors.Is(err, errLocalityNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("No locality named '%s'%s was found", name, stateSuffix(state)))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, upstreamErrorCode(err), err.Error())
+		return
+	}
+
+	nearbyCache.put(r.Context(), key, nearby)
+	writeJSON(w, http.StatusOK, nearby)
+}
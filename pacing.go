@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// paceStep is how much the pacer shortens the gap between upstream requests
+// after each quick, successful response.
+const paceStep = 50 * time.Millisecond
+
+// upstreamPacer spaces upstream requests; it is set in main.
+var upstreamPacer *adaptivePacer
+
+// adaptivePacer spaces upstream requests by a gap it adjusts AIMD-style, like
+// TCP congestion control: each quick success shortens the gap by paceStep, while a
+// 429 or 503, a failed request or a response slower than target doubles it. The
+// upstream thus gets requests as fast as it comfortably answers them, and much
+// slower ones as soon as it struggles.
+type adaptivePacer struct {
+	min, max time.Duration
+	target   time.Duration // responses slower than this count as the upstream struggling
+
+	mu       sync.Mutex
+	gap      time.Duration
+	nextSlot time.Time // earliest time the next upstream request may start
+}
+
+func newAdaptivePacer(minGap, maxGap, target time.Duration) *adaptivePacer {
+	return &adaptivePacer{min: minGap, max: maxGap, target: target, gap: minGap}
+}
+
+// wait blocks until the current gap since the previous upstream request has
+// passed, then claims the slot. Nothing is reserved while waiting, so a caller
+// that gives up doesn't push back the requests after it.
+func (p *adaptivePacer) wait(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		now := time.Now()
+		if !p.nextSlot.After(now) {
+			p.nextSlot = now.Add(p.gap)
+			p.mu.Unlock()
+			return nil
+		}
+		delay := p.nextSlot.Sub(now)
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// observe adjusts the gap after an upstream request that took latency. A
+// Retry-After on a throttling response also holds back every request until it
+// has passed.
+func (p *adaptivePacer) observe(ctx context.Context, resp *http.Response, err error, latency time.Duration) {
+	throttled := resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !throttled && err == nil && latency <= p.target {
+		p.gap = max(p.min, p.gap-paceStep)
+		return
+	}
+
+	previous := p.gap
+	p.gap = min(p.max, max(p.gap*2, paceStep))
+	if throttled {
+		if retryAfter := parseRetryAfter(resp.Header.Get("Retry-After")); retryAfter > 0 {
+			p.nextSlot = maxTime(p.nextSlot, time.Now().Add(min(retryAfter, p.max)))
+		}
+	}
+	if p.gap != previous {
+		switch {
+		case throttled:
+			logf(ctx, "Upstream answered %d; spacing upstream requests by %s", resp.StatusCode, p.gap)
+		case err != nil:
+			logf(ctx, "Upstream request failed; spacing upstream requests by %s", p.gap)
+		default:
+			logf(ctx, "Upstream took %s; spacing upstream requests by %s", latency.Round(time.Millisecond), p.gap)
+		}
+	}
+}
+
+// currentGap returns the gap the pacer is keeping between upstream requests.
+func (p *adaptivePacer) currentGap() time.Duration {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.gap
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date,
+// returning 0 when it is missing or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// pacedTransport spaces the requests it sends by its pacer and feeds their
+// outcomes back to it.
+type pacedTransport struct {
+	pacer *adaptivePacer
+	next  http.RoundTripper
+}
+
+func (t pacedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if err := t.pacer.wait(ctx); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	// A client giving up or running out of time says nothing about the upstream.
+	if ctx.Err() == nil {
+		t.pacer.observe(ctx, resp, err, time.Since(start))
+	}
+	return resp, err
+}
+
+// checkPacing validates the -pace-* flags.
+func checkPacing(minGap, maxGap, target time.Duration) error {
+	if minGap < 0 || maxGap < minGap {
+		return errors.New("-pace-min must be at least 0 and no more than -pace-max")
+	}
+	if target <= 0 {
+		return errors.New("-pace-target-latency must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+)
+
+// packedMagic starts every packed dataset file; its last byte is the format version.
+// Version 2 added coordinates.
+var packedMagic = []byte("PCD2")
+
+// packedFields is the number of string fields stored per row, in the order of
+// packedRowFields.
+const packedFields = 8
+
+// packedRowFields lists a row's stored fields in file order.
+func packedRowFields(row *PostcodeResult) [packedFields]*string {
+	return [packedFields]*string{
+		&row.Postcode, &row.Suburb, &row.State, &row.Category, &row.LocalityType,
+		&row.DeliveryOffice, &row.BSPNumber, &row.BSPName,
+	}
+}
+
+// writePacked writes rows in the packed dataset format: the magic, the row and
+// string counts, a table of string offsets, each row as packedFields indexes into
+// the string table, each row's latitude and longitude, then the string bytes.
+// Every distinct string is stored once, so the states, categories and delivery
+// offices repeated across rows cost nothing extra. Integers are little-endian
+// uint32 and coordinates little-endian float64.
+func writePacked(w io.Writer, rows []PostcodeResult) error {
+	ids := map[string]uint32{}
+	var strings bytes.Buffer
+	offsets := []uint32{0}
+	intern := func(s string) uint32 {
+		if id, ok := ids[s]; ok {
+			return id
+		}
+		id := uint32(len(offsets) - 1)
+		ids[s] = id
+		strings.WriteString(s)
+		offsets = append(offsets, uint32(strings.Len()))
+		return id
+	}
+
+	table := make([]uint32, 0, len(rows)*packedFields)
+	coordinates := make([]float64, 0, len(rows)*2)
+	for i := range rows {
+		for _, field := range packedRowFields(&rows[i]) {
+			table = append(table, intern(*field))
+		}
+		coordinates = append(coordinates, rows[i].Latitude, rows[i].Longitude)
+	}
+	if strings.Len() > 1<<31 {
+		return errors.New("dataset strings exceed the packed format's 2 GiB limit")
+	}
+
+	header := []uint32{uint32(len(rows)), uint32(len(offsets) - 1)}
+	if _, err := w.Write(packedMagic); err != nil {
+		return err
+	}
+	for _, part := range []any{header, offsets, table, coordinates} {
+		if err := binary.Write(w, binary.LittleEndian, part); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(strings.Bytes())
+	return err
+}
+
+// isPacked reports whether data starts like a packed dataset of any version.
+func isPacked(data []byte) bool {
+	return bytes.HasPrefix(data, packedMagic[:3])
+}
+
+// readPacked builds a dataset from packed data without copying its strings: each
+// row's fields point straight into data. data must therefore never change or be
+// unmapped while the dataset, or any result taken from it, is in use.
+func readPacked(data []byte) (*Dataset, error) {
+	corrupt := errors.New("packed dataset is truncated or corrupt")
+	if len(data) < 12 {
+		return nil, corrupt
+	}
+	if !bytes.HasPrefix(data, packedMagic) {
+		return nil, fmt.Errorf("packed dataset has unsupported format %q; re-run pack", data[:len(packedMagic)])
+	}
+	u32 := func(at int) uint32 { return binary.LittleEndian.Uint32(data[at:]) }
+	rowCount, stringCount := int(u32(4)), int(u32(8))
+
+	offsetsAt := 12
+	tableAt := offsetsAt + 4*(stringCount+1)
+	coordinatesAt := tableAt + 4*rowCount*packedFields
+	stringsAt := coordinatesAt + 16*rowCount
+	if rowCount < 0 || stringCount < 0 || stringsAt < tableAt || stringsAt > len(data) {
+		return nil, corrupt
+	}
+	stringData := data[stringsAt:]
+
+	// Convert the string table once; rows share the resulting strings.
+	values := make([]string, stringCount)
+	for i := range values {
+		start, end := u32(offsetsAt+4*i), u32(offsetsAt+4*i+4)
+		if start > end || int(end) > len(stringData) {
+			return nil, corrupt
+		}
+		if end > start {
+			values[i] = unsafe.String(&stringData[start], end-start)
+		}
+	}
+
+	rows := make([]PostcodeResult, rowCount)
+	for i := range rows {
+		for j, field := range packedRowFields(&rows[i]) {
+			id := int(u32(tableAt + 4*(i*packedFields+j)))
+			if id >= stringCount {
+				return nil, corrupt
+			}
+			*field = values[id]
+		}
+		at := coordinatesAt + 16*i
+		rows[i].Latitude = math.Float64frombits(binary.LittleEndian.Uint64(data[at:]))
+		rows[i].Longitude = math.Float64frombits(binary.LittleEndian.Uint64(data[at+8:]))
+	}
+	return newDataset(rows), nil
+}
+
+// loadPackedDataset maps a packed dataset file into memory read-only and serves
+// its strings from the mapping, so they live in the page cache, shared by every
+// replica on the host, rather than on each process's heap. The mapping is
+// released once a reload has replaced the dataset; see retireDataset.
+func loadPackedDataset(path string) (*Dataset, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("map packed dataset: %w", err)
+	}
+	dataset, err := readPacked(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	dataset.release = unmap
+	return dataset, nil
+}
+
+// retiredDatasetGrace is how long a replaced packed dataset stays mapped, for the
+// requests still reading it to finish; it comfortably outlasts any request.
+const retiredDatasetGrace = 5 * time.Minute
+
+// retireDataset releases a replaced dataset's mapping once retiredDatasetGrace has
+// passed. Responses and caches copy what they take from rows, and the row history
+// copies the rows it keeps, so nothing refers to the mapping by then.
+func retireDataset(dataset *Dataset) {
+	if dataset == nil || dataset.release == nil {
+		return
+	}
+	time.AfterFunc(retiredDatasetGrace, func() {
+		datasetHistory.forget(dataset)
+		if err := dataset.release(); err != nil {
+			log.Printf("Warning: failed to unmap replaced packed dataset: %v", err)
+		}
+	})
+}
+
+// runPack implements the "pack" subcommand, converting a dataset CSV into the
+// packed format that -dataset maps into memory. The file is written alongside and
+// renamed into place: a server mapping the old file keeps reading it intact
+// until it reloads.
+func runPack(args []string) error {
+	fs := flag.NewFlagSet("pack", flag.ExitOnError)
+	output := fs.String("o", "", "File to write the packed dataset to")
+	fs.Parse(args)
+	if *output == "" || fs.NArg() != 1 {
+		return errors.New("usage: pack -o FILE.pcd DATASET.csv")
+	}
+
+	dataset, err := loadDataset(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	out, err := os.CreateTemp(filepath.Dir(*output), filepath.Base(*output)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	if err := writePacked(out, dataset.Rows); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(out.Name(), *output); err != nil {
+		return err
+	}
+	fmt.Printf("Packed %d rows into %s\n", len(dataset.Rows), *output)
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Selector found via inspection: <table class="resultsList fn_tableResultsList fn_tablePostcodeList"...
+const postcodeTableSelector = "table.fn_tablePostcodeList"
+
+// fallbackTableSelectors are tried in order when postcodeTableSelector finds
+// nothing, using the table's other classes, so a renamed class doesn't empty every
+// search. Their use is counted in the parse metrics as a sign the primary selector
+// needs updating.
+var fallbackTableSelectors = []string{"table.fn_tableResultsList", "table.resultsList"}
+
+// noResultsPhrases are how the upstream's page for a keyword with no matches
+// says so, in place of a results table. Matching is case-insensitive.
+var noResultsPhrases = []string{"couldn't find any results", "could not find any results", "no results found"}
+
+// tableParse summarises how a page's results table was parsed.
+type tableParse struct {
+	Selector  string // the selector that found the table, or "" when none did
+	Skipped   int    // rows dropped because they could not be parsed
+	NoResults bool   // no table was found, but the page says nothing matched
+}
+
+// isNoResultsPage reports whether doc is the upstream's page for a search that
+// matched nothing, rather than a page whose layout the parser doesn't know.
+func isNoResultsPage(doc *goquery.Document) bool {
+	text := strings.ToLower(strings.ReplaceAll(doc.Find("body").Text(), "’", "'"))
+	for _, phrase := range noResultsPhrases {
+		if strings.Contains(text, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseWarning describes part of an upstream page that could not be parsed. The
+// rows that did parse are still returned, so a warning means results may be
+// incomplete rather than wrong.
+type ParseWarning struct {
+	Row     int    `json:"row,omitempty"` // 1-based table row, counting the header
+	Message string `json:"message"`
+}
+
+// warnedResults is a search response whose results may be incomplete because
+// part of the upstream page could not be parsed.
+type warnedResults struct {
+	Results  any            `json:"results"`
+	Warnings []ParseWarning `json:"warnings"`
+}
+
+// withWarnings wraps results with their parse warnings when the client asked for
+// them with ?include=warnings, so the body's shape never depends on whether the
+// upstream page parsed cleanly: either it is always wrapped, with an empty list
+// when there were no warnings, or never. Clients that didn't ask still see the
+// X-Parse-Warnings header.
+func withWarnings(results any, warnings []ParseWarning, include bool) any {
+	if !include {
+		return results
+	}
+	if warnings == nil {
+		warnings = []ParseWarning{}
+	}
+	return warnedResults{Results: results, Warnings: warnings}
+}
+
+// resultColumns are the logical column positions of the results table.
+type resultColumns struct {
+	postcode, suburb, category int
+}
+
+// defaultColumns is the layout used when the table has no recognisable header:
+// 0=Postcode, 1=Suburb (with state), 2=Category.
+var defaultColumns = resultColumns{postcode: 0, suburb: 1, category: 2}
+
+// tableRows returns the rows belonging to table itself, leaving out rows of any
+// table nested inside one of its cells.
+func tableRows(table *goquery.Selection) *goquery.Selection {
+	return table.ChildrenFiltered("tr").
+		AddSelection(table.ChildrenFiltered("thead, tbody, tfoot").ChildrenFiltered("tr"))
+}
+
+// rowCells expands a row's own cells into logical columns, so a cell spanning
+// several columns doesn't shift the ones after it. A spanning cell's text is
+// placed in its first column and the others are left empty.
+func rowCells(row *goquery.Selection) (texts []string, links []string, header bool) {
+	row.ChildrenFiltered("td, th").Each(func(_ int, cell *goquery.Selection) {
+		header = header || goquery.NodeName(cell) == "th"
+		span := 1
+		if raw, ok := cell.Attr("colspan"); ok {
+			if n, err := strconv.Atoi(strings.TrimSpace(raw)); err == nil && n > 1 {
+				span = min(n, 50)
+			}
+		}
+		// Text and links of a table nested in the cell don't belong to this row.
+		own := cell.Clone()
+		own.Find("table").Remove()
+		link, _ := own.Find("a").First().Attr("href")
+		texts = append(texts, strings.Join(strings.Fields(own.Text()), " "))
+		links = append(links, link)
+		for i := 1; i < span; i++ {
+			texts = append(texts, "")
+			links = append(links, "")
+		}
+	})
+	return texts, links, header
+}
+
+// headerColumns recognises a header row by its column names and returns the
+// layout it describes.
+func headerColumns(texts []string) (resultColumns, bool) {
+	cols := resultColumns{postcode: -1, suburb: -1, category: -1}
+	for i, text := range texts {
+		switch name := strings.ToLower(text); {
+		case strings.Contains(name, "postcode"):
+			cols.postcode = i
+		case strings.Contains(name, "suburb"), strings.Contains(name, "locality"):
+			cols.suburb = i
+		case strings.Contains(name, "category"):
+			cols.category = i
+		}
+	}
+	return cols, cols.postcode >= 0 && cols.suburb >= 0
+}
+
+// splitSuburbState splits suburb text like "SYDNEY, NSW" or "Sydney NSW" into its
+// suburb and state. The state is empty when none can be found.
+func splitSuburbState(text string) (string, string) {
+	if suburb, state, ok := strings.Cut(text, ","); ok {
+		return strings.TrimSpace(suburb), strings.TrimSpace(strings.Split(state, ",")[0])
+	}
+	if locality := parseLocalityText(text); locality.State != "" {
+		return locality.Suburb, locality.State
+	}
+	return strings.TrimSpace(text), ""
+}
+
+// parseResultsTable extracts the results table from an upstream search page. It
+// tolerates missing or repeated headers, nested tables, spanning cells and empty
+// rows; rows it cannot use are reported as warnings instead of being dropped
+// silently. The table is looked up with postcodeTableSelector, then each of
+// fallbackTableSelectors; parse reports which one found it and how many rows were
+// skipped.
+func parseResultsTable(doc *goquery.Document) (results []PostcodeResult, warnings []ParseWarning, parse tableParse) {
+	results = []PostcodeResult{}
+	warnings = []ParseWarning{}
+
+	// The parser must never take the server down; a panic becomes a warning and
+	// whatever was parsed so far is returned.
+	defer func() {
+		if r := recover(); r != nil {
+			warnings = append(warnings, ParseWarning{Message: fmt.Sprintf("parsing stopped early: %v", r)})
+		}
+	}()
+
+	var tables *goquery.Selection
+	for _, selector := range append([]string{postcodeTableSelector}, fallbackTableSelectors...) {
+		if tables = doc.Find(selector); tables.Length() > 0 {
+			parse.Selector = selector
+			break
+		}
+	}
+	if parse.Selector == "" {
+		parse.NoResults = isNoResultsPage(doc)
+		return results, warnings, parse
+	}
+
+	tables.Each(func(_ int, table *goquery.Selection) {
+		// A results table nested inside another is reached through its parent.
+		if table.ParentsFiltered(parse.Selector).Length() > 0 {
+			return
+		}
+		cols := defaultColumns
+
+		tableRows(table).Each(func(i int, row *goquery.Selection) {
+			n := i + 1
+			texts, links, isHeader := rowCells(row)
+			if header, ok := headerColumns(texts); ok {
+				cols = header
+				return
+			}
+			if isHeader || strings.TrimSpace(strings.Join(texts, "")) == "" {
+				return
+			}
+
+			cell := func(col int) string {
+				if col < 0 || col >= len(texts) {
+					return ""
+				}
+				return texts[col]
+			}
+			postcode := cell(cols.postcode)
+			suburb, state := splitSuburbState(cell(cols.suburb))
+
+			switch {
+			case len(texts) <= max(cols.postcode, cols.suburb):
+				warnings = append(warnings, ParseWarning{Row: n, Message: fmt.Sprintf("expected at least %d cells, found %d", max(cols.postcode, cols.suburb)+1, len(texts))})
+				parse.Skipped++
+				return
+			case postcode == "":
+				warnings = append(warnings, ParseWarning{Row: n, Message: fmt.Sprintf("row for %q has no postcode", suburb)})
+				parse.Skipped++
+				return
+			case suburb == "":
+				warnings = append(warnings, ParseWarning{Row: n, Message: fmt.Sprintf("row for postcode %s has no suburb", postcode)})
+				parse.Skipped++
+				return
+			}
+			if !fourDigits.MatchString(postcode) {
+				warnings = append(warnings, ParseWarning{Row: n, Message: fmt.Sprintf("postcode %q is not four digits", postcode)})
+			}
+			if state == "" {
+				warnings = append(warnings, ParseWarning{Row: n, Message: fmt.Sprintf("no state found for %s %s", postcode, suburb)})
+			}
+
+			result := PostcodeResult{
+				Postcode: postcode,
+				Suburb:   suburb,
+				State:    state,
+				Category: cell(cols.category),
+				Raw: &RawCells{
+					Postcode: cell(cols.postcode),
+					Suburb:   cell(cols.suburb),
+					Category: cell(cols.category),
+				},
+			}
+			result.LocalityType = classifyLocality(result)
+			// The suburb cell links to the locality's detail page.
+			if cols.suburb < len(links) {
+				result.detailURL = links[cols.suburb]
+			}
+			results = append(results, result)
+		})
+	})
+	return results, warnings, parse
+}
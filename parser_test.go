@@ -0,0 +1,147 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// parseHTML runs parseResultsTable over an HTML fragment.
+func parseHTML(t testing.TB, html string) ([]PostcodeResult, []ParseWarning, tableParse) {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatalf("parse HTML: %v", err)
+	}
+	return parseResultsTable(doc)
+}
+
+func TestParseResultsTable(t *testing.T) {
+	tests := []struct {
+		name      string
+		html      string
+		want      []string // "postcode suburb state category" per row
+		warnings  int
+		skipped   int
+		noResults bool
+	}{
+		{
+			name: "with header",
+			html: `<table class="fn_tablePostcodeList">
+				<tr><th>Postcode</th><th>Suburb</th><th>Category</th></tr>
+				<tr><td>2000</td><td><a href="/sydney">SYDNEY, NSW</a></td><td>Delivery Area</td></tr>
+			</table>`,
+			want: []string{"2000 SYDNEY NSW Delivery Area"},
+		},
+		{
+			name: "missing header",
+			html: `<table class="fn_tablePostcodeList">
+				<tr><td>3000</td><td>MELBOURNE, VIC</td><td>Delivery Area</td></tr>
+				<tr><td>3001</td><td>MELBOURNE, VIC</td><td>Post Office Boxes</td></tr>
+			</table>`,
+			want: []string{"3000 MELBOURNE VIC Delivery Area", "3001 MELBOURNE VIC Post Office Boxes"},
+		},
+		{
+			name: "reordered header",
+			html: `<table class="fn_tablePostcodeList">
+				<tr><th>Locality</th><th>Category</th><th>Postcode</th></tr>
+				<tr><td>SYDNEY, NSW</td><td>Delivery Area</td><td>2000</td></tr>
+			</table>`,
+			want: []string{"2000 SYDNEY NSW Delivery Area"},
+		},
+		{
+			name: "nested table",
+			html: `<table class="fn_tablePostcodeList">
+				<tr><td>2000</td><td>SYDNEY, NSW<table class="fn_tablePostcodeList"><tr><td>9999</td><td>NOWHERE, NSW</td></tr></table></td><td>Delivery Area</td></tr>
+			</table>`,
+			want: []string{"2000 SYDNEY NSW Delivery Area"},
+		},
+		{
+			name: "colspan",
+			html: `<table class="fn_tablePostcodeList">
+				<tr><th>Postcode</th><th>Suburb</th><th>Category</th></tr>
+				<tr><td colspan="2">2000</td><td>Delivery Area</td></tr>
+				<tr><td>2001</td><td>SYDNEY, NSW</td><td>Post Office Boxes</td></tr>
+			</table>`,
+			want:     []string{"2001 SYDNEY NSW Post Office Boxes"},
+			warnings: 1,
+			skipped:  1,
+		},
+		{
+			name: "empty rows",
+			html: `<table class="fn_tablePostcodeList">
+				<tr></tr>
+				<tr><td> </td><td></td><td></td></tr>
+				<tr><td>2000</td><td>SYDNEY, NSW</td><td>Delivery Area</td></tr>
+			</table>`,
+			want: []string{"2000 SYDNEY NSW Delivery Area"},
+		},
+		{
+			name: "row without postcode",
+			html: `<table class="fn_tablePostcodeList">
+				<tr><td></td><td>SYDNEY, NSW</td><td>Delivery Area</td></tr>
+			</table>`,
+			warnings: 1,
+			skipped:  1,
+		},
+		{
+			name: "fallback selector",
+			html: `<table class="resultsList">
+				<tr><td>2000</td><td>SYDNEY, NSW</td><td>Delivery Area</td></tr>
+			</table>`,
+			want: []string{"2000 SYDNEY NSW Delivery Area"},
+		},
+		{
+			name: "no table",
+			html: `<p>No results</p>`,
+		},
+		{
+			name:      "no results page",
+			html:      `<p>Sorry, we couldn’t find any results.</p>`,
+			noResults: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, warnings, parse := parseHTML(t, tt.html)
+			var got []string
+			for _, r := range results {
+				got = append(got, strings.Join([]string{r.Postcode, r.Suburb, r.State, r.Category}, " "))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("results = %q, want %q", got, tt.want)
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %v, want %d", warnings, tt.warnings)
+			}
+			if parse.Skipped != tt.skipped {
+				t.Errorf("skipped = %d, want %d", parse.Skipped, tt.skipped)
+			}
+			if parse.NoResults != tt.noResults {
+				t.Errorf("no results = %t, want %t", parse.NoResults, tt.noResults)
+			}
+		})
+	}
+}
+
+func FuzzParseResultsTable(f *testing.F) {
+	f.Add(`<table class="fn_tablePostcodeList"><tr><th>Postcode</th><th>Suburb</th><th>Category</th></tr><tr><td>2000</td><td>SYDNEY, NSW</td><td>Delivery Area</td></tr></table>`)
+	f.Add(`<table class="fn_tablePostcodeList"><tr><td colspan="99">2000</td></tr><tr><td>, NSW</td><td>3000</td></tr></table>`)
+	f.Add(`<table class="resultsList"><tbody><tr><td>2000<table class="resultsList"><tr><td>1</td></tr></table></td><td></td></tr></tbody></table>`)
+	f.Add(`<table class="fn_tableResultsList"><tr><td>0800</td><td>Darwin NT</td></tr><tr></tr>`)
+	f.Fuzz(func(t *testing.T, html string) {
+		results, warnings, _ := parseHTML(t, html)
+		// parseResultsTable recovers from panics, reporting them as a warning.
+		for _, w := range warnings {
+			if strings.HasPrefix(w.Message, "parsing stopped early") {
+				t.Fatalf("parser panicked: %s", w.Message)
+			}
+		}
+		for _, r := range results {
+			if r.Postcode == "" || r.Suburb == "" {
+				t.Errorf("result with an empty postcode or suburb: %+v", r)
+			}
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// poBoxTypes maps the normalised spellings of box address types to the form
+// Australia Post prints them in.
+var poBoxTypes = map[string]string{
+	"po box":             "PO Box",
+	"pobox":              "PO Box",
+	"post office box":    "PO Box",
+	"gpo box":            "GPO Box",
+	"locked bag":         "Locked Bag",
+	"locked mail bag":    "Locked Mail Bag",
+	"private bag":        "Private Bag",
+	"cmb":                "CMB",
+	"community mail bag": "CMB",
+}
+
+// poBoxNumber matches a box number: up to six digits with an optional letter, as
+// in "PO Box 123A".
+var poBoxNumber = regexp.MustCompile(`^\d{1,6}[A-Z]?$`)
+
+// POBoxRequest is the body of POST /pobox/validate.
+type POBoxRequest struct {
+	Type     string `json:"type"` // "PO Box" when empty; also GPO Box, Locked Bag, Private Bag, CMB
+	Number   string `json:"number"`
+	Postcode string `json:"postcode"`
+	// Suburb is the locality of the post office holding the box, as written in the
+	// address; State is optional. Both are checked when given.
+	Suburb string `json:"suburb"`
+	State  string `json:"state"`
+}
+
+// POBoxResult is the response of POST /pobox/validate.
+type POBoxResult struct {
+	Valid    bool   `json:"valid"`
+	Type     string `json:"type"`
+	Number   string `json:"number"`
+	Postcode string `json:"postcode"`
+	State    string `json:"state,omitempty"`
+	// BoxesOnly is set when the postcode is one reserved for PO Boxes and large
+	// volume receivers, which have no street addresses: it lies in a boxes-only
+	// range, or every dataset row for it is a po_box locality.
+	BoxesOnly bool `json:"boxes_only"`
+	// Match is the dataset row the postcode and suburb resolved to.
+	Match  *PostcodeResult `json:"match,omitempty"`
+	Reason string          `json:"reason,omitempty"`
+}
+
+// validatePOBox checks a box address. The postcode must be allocated to a state,
+// and to the given state if any. With a dataset loaded it must also exist, and a
+// given suburb must belong to it, with lenient validation's spelling tolerance.
+// Boxes are held at post offices in ordinary delivery areas as well as under the
+// boxes-only postcodes, so either kind is accepted.
+func validatePOBox(req POBoxRequest, dataset *Dataset) POBoxResult {
+	result := POBoxResult{
+		Number:   strings.ToUpper(strings.TrimSpace(req.Number)),
+		Postcode: strings.TrimSpace(req.Postcode),
+	}
+	invalid := func(format string, args ...any) POBoxResult {
+		result.Reason = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	boxType := normalizeName(req.Type)
+	if boxType == "" {
+		boxType = "po box"
+	}
+	var ok bool
+	if result.Type, ok = poBoxTypes[boxType]; !ok {
+		result.Type = strings.TrimSpace(req.Type)
+		return invalid("Unknown box type '%s': expected PO Box, GPO Box, Locked Bag, Private Bag or CMB", result.Type)
+	}
+	if !poBoxNumber.MatchString(result.Number) {
+		return invalid("Invalid box number '%s': expected up to six digits and an optional letter", result.Number)
+	}
+	if !fourDigits.MatchString(result.Postcode) {
+		return invalid("Invalid postcode '%s': expected four digits", result.Postcode)
+	}
+
+	states := statesForPostcode(postcodeNumber(result.Postcode))
+	state := strings.ToUpper(strings.TrimSpace(req.State))
+	switch {
+	case len(states) == 0:
+		return invalid("Postcode %s is not allocated to any state", result.Postcode)
+	case state != "" && !slices.Contains(states, state):
+		return invalid("Postcode %s is not in %s", result.Postcode, state)
+	}
+	result.State = states[0]
+	result.BoxesOnly = inPOBoxRange(result.Postcode)
+
+	if dataset == nil {
+		result.Valid = true
+		return result
+	}
+	rows := dataset.RowsForPostcode(result.Postcode)
+	if len(rows) == 0 {
+		return invalid("Postcode %s does not exist", result.Postcode)
+	}
+	result.BoxesOnly = result.BoxesOnly || boxesOnlyRows(rows)
+	if strings.TrimSpace(req.Suburb) != "" {
+		locality := dataset.Validate(result.Postcode, req.Suburb, state, false)
+		if !locality.Valid {
+			return invalid("%s", locality.Reason)
+		}
+		result.Match = locality.Match
+	}
+	result.Valid = true
+	return result
+}
+
+// boxesOnlyRows reports whether a postcode's dataset rows are all po_box
+// localities, so it has no street addresses even outside the boxes-only ranges.
+func boxesOnlyRows(rows []PostcodeResult) bool {
+	return len(rows) > 0 && !slices.ContainsFunc(rows, func(row PostcodeResult) bool {
+		return row.LocalityType != localityPOBox
+	})
+}
+
+// poBoxValidateHandler handles POST /pobox/validate with a body like
+// {"type": "PO Box", "number": "123", "postcode": "3001", "suburb": "Melbourne", "state": "VIC"},
+// for billing-address forms that accept box addresses. Like GET /validate it always
+// answers 200 with "valid" set, unless the body lacks the number or postcode.
+func poBoxValidateHandler(w http.ResponseWriter, r *http.Request) {
+	var req POBoxRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Number) == "" || strings.TrimSpace(req.Postcode) == "" {
+		writeError(w, http.StatusBadRequest, codeBodyInvalid, "Both 'number' and 'postcode' are required")
+		return
+	}
+	writeJSON(w, http.StatusOK, validatePOBox(req, currentDataset()))
+}
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	// Goquery is an excellent HTML parser, similar to jQuery or BeautifulSoup.
 	// You will need to install it: go get github.com/PuerkitoBio/goquery
 	"github.com/PuerkitoBio/goquery"
+	"github.com/redis/go-redis/v9"
 )
 
 type PostcodeResult struct {
@@ -18,161 +27,1260 @@ type PostcodeResult struct {
 	Suburb   string `json:"suburb"`
 	State    string `json:"state"`
 	Category string `json:"category"`
+
+	// LocalityType is one of "suburb", "town", "locality" or "po_box", so consumers
+	// can filter out entries without residential street addresses.
+	LocalityType string `json:"locality_type,omitempty"`
+
+	// Delivery office and barcode sort plan details, available when the dataset is
+	// the official Australia Post datafile.
+	DeliveryOffice string `json:"delivery_office,omitempty"`
+	BSPNumber      string `json:"bsp_number,omitempty"`
+	BSPName        string `json:"bsp_name,omitempty"`
+
+	// Latitude and Longitude locate the locality's centre, when the dataset has
+	// coordinate columns. Zero means unknown; no Australian locality lies at 0°.
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+
+	// AliasOf names the official suburb when this row was found through one of its
+	// aliases (a former name or alternative spelling); Suburb then holds the alias.
+	AliasOf string `json:"alias_of,omitempty"`
+
+	// DualName gives every official name of a locality that has variants (such
+	// as an Indigenous dual name), separated by " / ", from -variants.
+	DualName string `json:"dual_name,omitempty"`
+
+	// EffectiveTo is the date a soft-deleted row was removed from the dataset. It
+	// is only set on the removed rows an ?as_of= query brings back.
+	EffectiveTo string `json:"effective_to,omitempty"`
+
+	// LastVerifiedAt is when the row was last confirmed: scraped from the
+	// upstream, or read from (or found unchanged in) the dataset's source.
+	// Cached and stale results keep the time they were scraped.
+	LastVerifiedAt string `json:"last_verified_at,omitempty"`
+
+	// Raw holds the upstream cell texts a scraped row was parsed from. Search
+	// responses only include it for ?include=raw; dataset rows never have it.
+	Raw *RawCells `json:"raw,omitempty"`
+
+	// detailURL is the link to the locality's upstream detail page, when scraped.
+	detailURL string
+
+	// Score is the relevance of the result to the searched keyword (0-1].
+	// It is only populated when the client asks for it with ?score=true.
+	Score float64 `json:"score,omitempty"`
 }
 
-// Base URL for the Australia Post postcode search.
-const BASE_URL = "https://auspost.com.au/postcode/"
+// defaultBaseURL is the Australia Post postcode search.
+const defaultBaseURL = "https://auspost.com.au/postcode/"
+
+// baseURL is the upstream postcode search that keywords are appended to. main
+// sets it from -upstream-url or $UPSTREAM_URL, so test environments can point at
+// a mock server and staging at a recorded fixture host.
+var baseURL = defaultBaseURL
+
+// scrapeCache holds recent scrape results; it is configured in main.
+var scrapeCache *resultCache[[]PostcodeResult]
+
+// scrapeBudget limits how often the upstream is scraped; nil means no limit.
+var scrapeBudget scrapeLimiter
+
+// upstreamTransport carries upstream page requests; main layers the pacer, the
+// robots.txt guard and the on-disk HTTP cache over a transport tuned by the
+// -upstream-* connection flags.
+var upstreamTransport http.RoundTripper = timeoutTransport{timeout: upstreamFetchTimeout, next: http.DefaultTransport}
 
 // --- Handlers ---
 
 // postcodeHandler handles the /search API endpoint.
 // It expects a 'keyword' query parameter.
 func postcodeHandler(w http.ResponseWriter, r *http.Request) {
-	// Set the Content-Type header to ensure the client knows to expect JSON
-	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
 
 	// Extract the keyword from the URL query parameters
-	keyword := r.URL.Query().Get("keyword")
+	keyword := query.Get("keyword")
 
 	if keyword == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		// Write a structured error response
-		json.NewEncoder(w).Encode(map[string]string{"error": "Missing 'keyword' parameter in the query string. Example: /search?keyword=sydney"})
+		writeError(w, http.StatusBadRequest, codeKeywordMissing, "Missing 'keyword' parameter in the query string. Example: /search?keyword=sydney")
+		return
+	}
+	if err := checkKeywordLength(keyword); err != nil {
+		writeError(w, http.StatusBadRequest, codeKeywordInvalid, err.Error())
+		return
+	}
+
+	// count_only=true returns just the number of matches, for validation flows
+	// that only care whether a suburb exists and how ambiguous it is.
+	countOnly, err := parseBoolParam(query, "count_only")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+
+	// score=true includes the relevance score used to rank each result.
+	withScore, err := parseBoolParam(query, "score")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+
+	// group_by=state nests results under their state, which is how suburb
+	// pickers render them.
+	groupBy := query.Get("group_by")
+	if groupBy != "" && groupBy != "state" {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid 'group_by' parameter: only 'state' is supported")
+		return
+	}
+
+	// shape=nested answers a four-digit postcode keyword with the postcode as one
+	// object and its suburbs nested inside, instead of a flat list of rows.
+	shape := query.Get("shape")
+	if shape != "" && shape != "flat" && shape != "nested" {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid 'shape' parameter: expected 'flat' or 'nested'")
+		return
+	}
+	if shape == "nested" && groupBy != "" {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, "'shape=nested' cannot be combined with 'group_by'")
+		return
+	}
+
+	// case=title returns names as "Surry Hills" rather than the upstream's
+	// "SURRY HILLS".
+	titleCased, err := parseCase(query.Get("case"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+
+	// locality_type=suburb,town,... keeps only results of those types.
+	localityFilter, err := parseLocalityTypes(query.Get("locality_type"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+
+	// match=regex|wildcard matches suburb names against a pattern in the local dataset.
+	matchMode := query.Get("match")
+	if matchMode != "" && matchMode != matchModeRegex && matchMode != matchModeWildcard {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid 'match' parameter: expected 'regex' or 'wildcard'")
+		return
+	}
+
+	// include=raw attaches the upstream cell texts each scraped result was parsed
+	// from, for consumers auditing the normalised fields; include=warnings wraps
+	// the results with the parse warnings.
+	include, err := parseInclude(query.Get("include"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+
+	// refresh=true, sent with the admin token, skips the caches and scrapes the
+	// keyword live, caching the fresh result, for when a locality is known to
+	// have changed before its cache entry expires.
+	refresh, err := parseBoolParam(query, "refresh")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+	if refresh && !authorizeRefresh(w, r) {
+		return
+	}
+
+	// country=NZ searches another country's postcodes instead of Australia's.
+	source, ok := countrySource(w, query)
+	if !ok {
+		return
+	}
+	if source != nil {
+		countrySearch(w, query, source, keyword, countOnly, withScore, groupBy, localityFilter, titleCased)
+		return
+	}
+
+	// prefer_state=VIC lists that state's results first within each relevance tier.
+	// Without it the state is inferred from the client's address when a GeoIP
+	// database is loaded; prefer_state=none turns that off.
+	preferredState := strings.ToUpper(strings.TrimSpace(query.Get("prefer_state")))
+	switch {
+	case preferredState == "":
+		preferredState = activeGeoIP.clientState(r)
+	case preferredState == "NONE":
+		preferredState = ""
+	case stateRanges[preferredState] == nil:
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid 'prefer_state' parameter: expected a state code such as VIC, or none")
+		return
+	}
+
+	// A plain search answered recently is served from its encoded body, skipping
+	// the lookup, ranking and encoding below. Keys with private aliases get
+	// results of their own, so they bypass the cache.
+	aliases := requestAliases(r)
+	cacheable := renderedResponses.cacheable(w, r) && preferredState == "" && aliases == currentAliases()
+	if cacheable {
+		if entry, ok := renderedResponses.get(keyword); ok {
+			searchLog.record(r, keyword, entry.results)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(entry.body)
+			return
+		}
+	}
+
+	// "richmond vic" and "3121 richmond" search for the suburb and keep the results
+	// in that state or postcode. Patterns are used as they are.
+	var parsed searchQuery
+	if matchMode == "" {
+		parsed = parseSearchQuery(keyword)
+		keyword = parsed.Suburb
+	}
+
+	// as_of=2024-01-31 searches the rows that were current on that date.
+	dataset, ok := datasetAsOf(w, query, currentDataset())
+	if !ok {
+		return
+	}
+	var results []PostcodeResult
+	var warnings []ParseWarning // rows of a scraped page that could not be parsed
+
+	// A keyword of one to three digits is the start of a postcode, as typed into a
+	// numeric field: list the matching postcodes a page at a time.
+	if dataset != nil && matchMode == "" && postcodePrefix.MatchString(keyword) {
+		page, err := parsePagination(query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+			return
+		}
+		rows := dataset.PostcodesWithPrefix(keyword)
+		if titleCased {
+			rows = titleCaseResults(rows)
+		}
+		groups := groupByPostcode(rows)
+		if countOnly {
+			writeJSON(w, http.StatusOK, map[string]int{"count": len(groups)})
+			return
+		}
+		writeJSON(w, http.StatusOK, paginate(groups, page))
+		return
+	}
+
+	switch {
+	case matchMode != "":
+		if dataset == nil {
+			writeError(w, http.StatusBadRequest, codeDatasetUnavailable, fmt.Sprintf("match=%s requires a local dataset; start the server with -dataset", matchMode))
+			return
+		}
+		pattern, err := compileSuburbPattern(matchMode, keyword)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeKeywordInvalid, err.Error())
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), patternScanTimeout)
+		defer cancel()
+		results, err = dataset.Match(ctx, pattern)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codePatternTimeout, err.Error())
+			return
+		}
+
+	case dataset != nil:
+		results = append(dataset.Search(keyword), aliases.Search(dataset, keyword)...)
+		results = activeVariants.apply(dataset, results, keyword)
+		rankResults(results, keyword, withScore)
+
+	default:
+		var cached []PostcodeResult
+		ok := false
+		if !refresh {
+			cached, ok = scrapeCache.get(r.Context(), normalizeName(keyword))
+		}
+		if !ok {
+			// Punctuation alone leaves nothing to put in the upstream URL.
+			if len(tokenize(keyword)) == 0 {
+				writeError(w, http.StatusBadRequest, codeKeywordInvalid, "'keyword' must contain letters or digits")
+				return
+			}
+			release, queued := waitForScrape(w, r, normalizeName(keyword))
+			if !queued {
+				return
+			}
+			defer release()
+			// The scrape this search waited behind has usually cached the keyword.
+			// This search has already been counted as a miss.
+			if !refresh {
+				cached, ok = scrapeCache.lookup(r.Context(), normalizeName(keyword))
+			}
+		}
+		if ok {
+			results = cached
+		} else {
+			if !allowScrape(w, r) {
+				return
+			}
+
+			ctx := r.Context()
+			if refresh {
+				ctx = withLiveFetch(ctx)
+				auditTrail.recordRequest(r, "search.refresh", map[string]any{"keyword": keyword})
+			}
+
+			// Call the scraping function
+			results, warnings, err = searchPostcodes(ctx, keyword)
+			if err != nil {
+				if results, ok = scrapeFallback(w, r, normalizeName(keyword), err); !ok {
+					return
+				}
+				// Expired results may answer this search, but no later one.
+				cacheable = false
+			} else if len(results) > 0 && len(warnings) == 0 {
+				// Empty results usually mean broken selectors, and results with
+				// warnings may be incomplete, so neither is worth keeping.
+				scrapeCache.put(r.Context(), normalizeName(keyword), results)
+				if refresh {
+					// Other spellings of the keyword may have stale bodies cached.
+					renderedResponses.invalidate()
+					invalidations.publish(invalidateKeyword, normalizeName(keyword))
+				}
+			}
+		}
+		rankResults(results, keyword, withScore)
+	}
+
+	if dataset != nil {
+		results = stampDatasetVerified(results)
+	}
+	results = parsed.filter(results)
+	if preferredState != "" && parsed.State == "" {
+		preferState(results, keyword, preferredState)
+		w.Header().Set("X-Preferred-State", preferredState)
+	}
+	results = filterLocalityTypes(results, localityFilter)
+	if titleCased {
+		results = titleCaseResults(results)
+	}
+	if !include.raw {
+		results = withoutRaw(results)
+	}
+	searchLog.record(r, query.Get("keyword"), len(results))
+	if len(warnings) > 0 {
+		w.Header().Set("X-Parse-Warnings", strconv.Itoa(len(warnings)))
+	}
+
+	if countOnly {
+		writeJSON(w, http.StatusOK, map[string]int{"count": len(results)})
+		return
+	}
+
+	if len(results) == 0 {
+		// Locally there is nothing to fix, so an empty result is a plain 404.
+		if dataset != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{
+				"message": fmt.Sprintf("No postcodes found for keyword '%s'.", keyword),
+				"code":    string(codeNotFound),
+			})
+			return
+		}
+
+		// A scrape with no results is reported with a 500 status, since it usually
+		// means the upstream markup changed and the selectors need attention.
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"message": fmt.Sprintf("No postcodes found for keyword '%s'. Please verify the CSS selectors.", keyword),
+			"code":    string(codeNotFound),
+		})
+		return
+	}
+
+	if groupBy == "state" {
+		writeJSON(w, http.StatusOK, withWarnings(groupByState(results), warnings, include.warnings))
+		return
+	}
+
+	if shape == "nested" && fourDigits.MatchString(keyword) {
+		if detail, ok := nestPostcode(results, keyword); ok {
+			writeJSON(w, http.StatusOK, withWarnings(detail, warnings, include.warnings))
+			return
+		}
+	}
+
+	if cacheable && len(warnings) == 0 {
+		var body []byte
+		if err := encodeJSON(w, http.StatusOK, results, &body); err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to marshal JSON: %s", err))
+			return
+		}
+		renderedResponses.put(query.Get("keyword"), body, len(results), dataset, aliases)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, withWarnings(results, warnings, include.warnings))
+}
+
+// groupByState nests results under their state code, preserving the original order
+// within each state. Results without a state are grouped under an empty key.
+func groupByState(results []PostcodeResult) map[string][]PostcodeResult {
+	grouped := make(map[string][]PostcodeResult)
+	for _, result := range results {
+		grouped[result.State] = append(grouped[result.State], result)
+	}
+	return grouped
+}
+
+// PostcodeDetail is a search for an exact postcode answered as one object, for
+// ?shape=nested.
+type PostcodeDetail struct {
+	Postcode string `json:"postcode"`
+	// State is the postcode's state when all its suburbs are in one, as nearly
+	// all are.
+	State   string        `json:"state,omitempty"`
+	Suburbs []SuburbEntry `json:"suburbs"`
+}
+
+// nestPostcode collects the results with the given postcode into one object,
+// returning false when there are none.
+func nestPostcode(results []PostcodeResult, postcode string) (PostcodeDetail, bool) {
+	for _, group := range groupByPostcode(results) {
+		if group.Postcode != postcode {
+			continue
+		}
+		detail := PostcodeDetail{Postcode: group.Postcode, State: group.Suburbs[0].State, Suburbs: group.Suburbs}
+		for _, suburb := range group.Suburbs {
+			if suburb.State != detail.State {
+				detail.State = ""
+			}
+		}
+		return detail, true
+	}
+	return PostcodeDetail{}, false
+}
+
+// parseBoolParam reads an optional boolean query parameter.
+// A missing parameter is treated as false.
+func parseBoolParam(query url.Values, name string) (bool, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("Invalid '%s' parameter: expected true or false", name)
+	}
+	return value, nil
+}
+
+// writeJSON writes v as a JSON response with the given status code, indented when
+// the client asked for ?pretty=true and with results as positional arrays for
+// ?compact=true.
+// Clients that negotiated another format get v in that format instead; protobuf
+// requests for a response with no protobuf message get a 406.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	switch negotiatedFormat(w) {
+	case formatProtobuf:
+		body, ok := marshalProtobuf(v)
+		if !ok {
+			writeError(w, http.StatusNotAcceptable, codeNotAcceptable, "This response is not available as protobuf")
+			return
+		}
+		w.Header().Set("Content-Type", protobufContentType)
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+
+	case formatMsgpack:
+		body, err := marshalMsgpack(v)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to encode MessagePack: %s", err))
+			return
+		}
+		w.Header().Set("Content-Type", msgpackContentType)
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+
+	case formatJSONAPI:
+		doc, err := buildJSONAPIDocument(status, v, requestURL(w))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to marshal JSON: %s", err))
+			return
+		}
+		body, err := marshalLinked(doc, prettyJSON(w))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to marshal JSON: %s", err))
+			return
+		}
+		w.Header().Set("Content-Type", jsonapiContentType)
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+
+	case formatHAL:
+		// Error bodies keep their plain JSON shape; HAL has no error format.
+		if status >= 400 {
+			break
+		}
+		doc, err := buildHALDocument(v, requestURL(w))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to marshal JSON: %s", err))
+			return
+		}
+		body, err := marshalLinked(doc, prettyJSON(w))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to marshal JSON: %s", err))
+			return
+		}
+		w.Header().Set("Content-Type", halContentType)
+		w.WriteHeader(status)
+		w.Write(body)
 		return
 	}
 
-	// Add a small delay to be polite to the server we are scraping (good practice)
-	time.Sleep(500 * time.Millisecond)
+	if compactJSON(w) {
+		v = compactBody(v)
+	}
+	// The body is streamed, so a failure part-way can only be logged.
+	if err := encodeJSON(w, status, v, nil); err != nil {
+		log.Printf("Warning: failed to write JSON response: %v", err)
+	}
+}
 
-	// Call the scraping function
-	jsonOutput := searchPostcodes(keyword)
+// writeError writes a structured error response carrying a stable error code.
+func writeError(w http.ResponseWriter, status int, code errorCode, message string) {
+	switch negotiatedFormat(w) {
+	case formatProtobuf:
+		w.Header().Set("Content-Type", protobufContentType)
+		w.WriteHeader(status)
+		w.Write(marshalError(message, string(code)))
+		return
 
-	// Note: searchPostcodes returns a JSON string, which we write directly.
-	// If the output contains an error or no-result message, we set a 500 status.
-	if strings.Contains(jsonOutput, `"error"`) || strings.Contains(jsonOutput, `"message"`) {
-		w.WriteHeader(http.StatusInternalServerError)
+	case formatMsgpack:
+		// Encoding a map of strings cannot fail.
+		body, _ := marshalMsgpack(map[string]string{"error": message, "code": string(code)})
+		w.Header().Set("Content-Type", msgpackContentType)
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+
+	case formatJSONAPI:
+		w.Header().Set("Content-Type", jsonapiContentType)
+		w.WriteHeader(status)
+		doc := newJSONAPIErrors(status, code, message)
+		if self := requestURL(w); self != nil {
+			doc.Links = map[string]string{"self": self.RequestURI()}
+		}
+		body, _ := marshalLinked(doc, prettyJSON(w))
+		w.Write(body)
+		return
 	}
 
-	w.Write([]byte(jsonOutput))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	if prettyJSON(w) {
+		enc.SetIndent("", "    ")
+	}
+	enc.Encode(map[string]string{"error": message, "code": string(code)})
 }
 
 // --- Scraper Logic ---
 // searchPostcodes fetches and scrapes the postcode data for a given keyword.
-func searchPostcodes(keyword string) string {
+// An empty slice (rather than an error) is returned when the page contains no results.
+// Rows that could not be parsed are reported as warnings; the rest are still returned.
+func searchPostcodes(ctx context.Context, keyword string) ([]PostcodeResult, []ParseWarning, error) {
 	if keyword == "" {
-		return `{"error": "Keyword cannot be empty."}`
+		return nil, nil, errors.New("Keyword cannot be empty.")
+	}
+	if len(tokenize(keyword)) == 0 {
+		return nil, nil, errors.New("Keyword must contain letters or digits.")
 	}
 
-	// Construct the target URL.
-	url := fmt.Sprintf("%s%s", BASE_URL, strings.ToLower(strings.TrimSpace(keyword)))
-	log.Printf("Scraping target: %s", url)
+	doc, err := fetchDocument(ctx, searchURL(keyword))
+	if err != nil {
+		// A client giving up, a crawl being stopped or a page robots.txt rules out
+		// says nothing about the upstream's health.
+		if ctx.Err() == nil && !errors.Is(err, errRobotsDisallowed) {
+			recordScrape(scrapeFailed, err)
+		}
+		return nil, nil, err
+	}
 
-	// 1. Make the HTTP request
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	// --- IMPORTANT: TARGETING THE RESULTS TABLE ---
+	// The table layout is described in parser.go; rows that cannot be parsed come
+	// back as warnings alongside the rows that could.
+	resultsList, warnings, parse := parseResultsTable(doc)
+	verifiedAt := formatVerifiedAt(time.Now())
+	for i := range resultsList {
+		resultsList[i].LastVerifiedAt = verifiedAt
+	}
+	debugf(ctx, "Parsed %d rows, skipped %d, with %d warnings for keyword '%s' (selector: %q)", len(resultsList), parse.Skipped, len(warnings), keyword, parse.Selector)
+	recordParse(len(resultsList), len(warnings), parse)
+	for _, warning := range warnings {
+		logf(ctx, "Warning: Parsing results for keyword '%s', row %d: %s", keyword, warning.Row, warning.Message)
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return fmt.Sprintf(`{"error": "Failed to create request: %s"}`, err)
+	// Log a warning if the selector fails, but allow the API to return a no-results message.
+	switch {
+	case parse.NoResults:
+		debugf(ctx, "Upstream has no results for keyword '%s'", keyword)
+		recordScrape(scrapeOK, nil)
+	case parse.Selector == "":
+		logf(ctx, "Warning: Selector '%s' did not find any elements for keyword '%s'.", postcodeTableSelector, keyword)
+		recordScrape(scrapeParseFailure, nil)
+	case parse.Selector == postcodeTableSelector:
+		recordScrape(scrapeOK, nil)
+	default:
+		logf(ctx, "Warning: Selector '%s' did not find any elements for keyword '%s'; used fallback '%s'.", postcodeTableSelector, keyword, parse.Selector)
+		recordScrape(scrapeOK, nil)
+	}
+
+	return resultsList, warnings, nil
+}
+
+// parseBaseURL checks an -upstream-url value: an absolute http or https URL. A
+// trailing slash is added, so keywords are appended as a path segment.
+func parseBaseURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid upstream URL %q: expected an http or https URL such as %s", raw, defaultBaseURL)
 	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String(), nil
+}
 
-	// Use a common user-agent header to mimic a regular browser visit
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+// searchURL returns the upstream search page for keyword. The site's paths join
+// lower-case words with hyphens and leave out apostrophes, so "St Kilda  East"
+// becomes st-kilda-east and "O'Connor" becomes oconnor.
+func searchURL(keyword string) string {
+	return fmt.Sprintf("%s%s", baseURL, url.PathEscape(strings.Join(tokenize(keyword), "-")))
+}
 
-	resp, err := client.Do(req)
+// fetchDocument downloads an upstream page and parses it with goquery.
+func fetchDocument(ctx context.Context, targetURL string) (*goquery.Document, error) {
+	resp, body, err := fetchPage(ctx, targetURL)
 	if err != nil {
-		return fmt.Sprintf(`{"error": "Failed to fetch the page: %s"}`, err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Sprintf(`{"error": "Received non-OK HTTP status: %d"}`, resp.StatusCode)
+		return nil, fmt.Errorf("Received non-OK HTTP status: %d", resp.StatusCode)
 	}
 
 	// 2. Parse the HTML content using goquery
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
 	if err != nil {
-		return fmt.Sprintf(`{"error": "Failed to parse HTML: %s"}`, err)
+		return nil, fmt.Errorf("Failed to parse HTML: %s", err)
 	}
+	return doc, nil
+}
 
-	resultsList := []PostcodeResult{}
+// fetchPage downloads an upstream page whatever its status, returning the response
+// with its body already read.
+func fetchPage(ctx context.Context, targetURL string) (*http.Response, []byte, error) {
+	logf(ctx, "Scraping target: %s", targetURL)
 
-	// --- IMPORTANT: TARGETING THE RESULTS TABLE ---
-	// Selector found via inspection: <table class="resultsList fn_tableResultsList fn_tablePostcodeList"...
-	const postcodeTableSelector = "table.fn_tablePostcodeList"
+	// 1. Make the HTTP request
+	// The transport times the request itself, leaving out pacing waits.
+	client := &http.Client{Transport: upstreamTransport}
 
-	dataContainerFound := false
-	// Find all table rows (<tr>) within the selected table
-	doc.Find(postcodeTableSelector + " tr").Each(func(i int, row *goquery.Selection) {
-		dataContainerFound = true
+	req, err := http.NewRequestWithContext(ctx, "GET", targetURL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to create request: %s", err)
+	}
 
-		// Skip the header row (i=0)
-		if i == 0 {
-			return
-		}
+	req.Header.Set("User-Agent", nextUserAgent())
+	if id := requestID(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
 
-		// Find all table data cells (<td>) in the current row
-		cols := row.Find("td")
-		// Columns are: 0=Postcode, 1=Suburb, 2=Category
-		if cols.Length() >= 3 {
-			categoryText := strings.TrimSpace(cols.Eq(2).Text())
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to fetch the page: %w", err)
+	}
+	defer resp.Body.Close()
 
-			// Postcode is in the first column (index 0)
-			postcodeText := strings.TrimSpace(cols.Eq(0).Text())
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read the page: %w", err)
+	}
+	debugf(ctx, "Fetched %s: status %d, %d bytes in %s, X-Cache %s", targetURL, resp.StatusCode, len(body), time.Since(start).Round(time.Millisecond), resp.Header.Get("X-Cache"))
+	return resp, body, nil
+}
 
-			// Suburb (with State) is in the second column (index 1)
-			fullSuburbText := strings.TrimSpace(cols.Eq(1).Text())
+// subcommands are the non-server modes of the binary, selected by the first argument.
+var subcommands = map[string]func(args []string) error{
+	"snapshot":   runSnapshot,
+	"verify":     runVerify,
+	"compare":    runCompare,
+	"adjacency":  runAdjacency,
+	"bench":      runBench,
+	"pack":       runPack,
+	"mockserver": runMockServer,
+}
 
-			// Split the text by comma and space, e.g., "SYDNEY, NSW" -> ["SYDNEY", "NSW"]
-			parts := strings.Split(fullSuburbText, ",")
+// runCrawlJob crawls every postcode, serves the result as the active dataset and
+// saves it to the output file and snapshot location when those are configured.
+func runCrawlJob(ctx context.Context, c *crawler, output, snapshot string) error {
+	rows, err := c.run(ctx)
+	if err != nil {
+		return fmt.Errorf("crawl interrupted: %w", err)
+	}
+	if len(rows) == 0 {
+		return errors.New("crawl found no rows; keeping the current dataset")
+	}
 
-			suburb := ""
-			state := ""
+	replaceDataset(newDataset(rows), "crawl", "system")
+	if output != "" {
+		if err := writeDatasetFile(output, rows); err != nil {
+			return fmt.Errorf("write crawl output: %w", err)
+		}
+	}
+	if snapshot != "" {
+		key, err := publishSnapshot(ctx, snapshot, rows)
+		if err != nil {
+			return fmt.Errorf("publish snapshot: %w", err)
+		}
+		log.Printf("Published crawl snapshot %s", key)
+	}
+	invalidations.publish(invalidateDataset, "")
+	return nil
+}
 
-			// The first part is always the Suburb
-			if len(parts) >= 1 {
-				suburb = strings.TrimSpace(parts[0])
-			}
-			// The second part is the State
-			if len(parts) >= 2 {
-				state = strings.TrimSpace(parts[1])
-			}
+// wasmMain replaces the server in the WebAssembly build, which exposes the
+// validation core to JavaScript instead; it is nil otherwise.
+var wasmMain func()
 
-			if postcodeText != "" && suburb != "" {
-				resultsList = append(resultsList, PostcodeResult{
-					Postcode: postcodeText,
-					Suburb:   suburb,
-					State:    state,
-					Category: categoryText,
-				})
+func main() {
+	if wasmMain != nil {
+		wasmMain()
+		return
+	}
+	if len(os.Args) > 1 {
+		if command, ok := subcommands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %s: %v", os.Args[1], err)
 			}
+			return
 		}
+	}
+
+	datasetPath := flag.String("dataset", "", "Path to a local postcode CSV file; when set, searches are served from it instead of scraping")
+	snapshotLocation := flag.String("snapshot", "", "Load the latest dataset snapshot from s3://bucket/prefix at startup")
+	datasetURL := flag.String("dataset-url", "", "Load the dataset CSV from an HTTP(S) URL at startup")
+	datasetSHA256 := flag.String("dataset-sha256", "", "Expected SHA-256 of the -dataset-url file")
+	datasetChecksumURL := flag.String("dataset-checksum-url", "", "URL of a sha256sum-style checksum file to verify each -dataset-url download against")
+	datasetRefresh := flag.Duration("dataset-refresh", 0, "How often to re-fetch -dataset-url (0 disables refreshing)")
+	storeSpec := flag.String("store", "memory", "Where to keep cached results: 'memory' or 'bolt:PATH' for an embedded database file")
+	cacheTTL := flag.Duration("cache-ttl", 24*time.Hour, "How long scraped results are served from the cache")
+	renderedCacheSize := flag.Int("rendered-cache-size", 1000, "How many encoded responses to popular plain searches are kept in memory (0 disables it)")
+	readOnlyFlag := flag.Bool("read-only", false, "Serve without writing: no store or cache writes, crawling or data-changing admin and API requests, for replicas of a shared snapshot")
+	proxyUpstream := flag.String("proxy-upstream", "", "Serve as a caching, coalescing proxy in front of this URL (another instance of this server) instead of answering searches itself")
+	proxyCacheTTL := flag.Duration("proxy-cache-ttl", time.Minute, "How long -proxy-upstream responses are reused")
+	proxyCacheSize := flag.Int("proxy-cache-size", 1000, "How many -proxy-upstream responses are kept in memory")
+	migrateOnly := flag.Bool("migrate-only", false, "Apply pending -store schema migrations, then exit without serving")
+	searchHistoryOn := flag.Bool("search-history", false, "Keep a log of searches (keyword, time, result count, anonymised client) in the store")
+	searchHistoryRetention := flag.Duration("search-history-retention", 30*24*time.Hour, "How long logged searches are kept before they are deleted")
+	budget := flag.Int("scrape-budget", 0, "Maximum upstream scrapes per minute (0 means unlimited)")
+	scrapeQueueDepth := flag.Int("scrape-queue-depth", 16, "How many identical searches may wait behind an in-flight scrape of the same keyword before more get 503 (0 disables per-keyword queueing)")
+	scrapeQueueWait := flag.Duration("scrape-queue-wait", 10*time.Second, "Longest a search waits behind an in-flight scrape of the same keyword before getting 503")
+	redisURL := flag.String("redis-url", "", "Redis server URL (redis://host:6379/0) used to share state between replicas")
+	crawlInterval := flag.Duration("crawl-interval", 0, "How often to crawl every postcode into a fresh dataset (0 disables crawling)")
+	crawlDelay := flag.Duration("crawl-delay", time.Second, "Pause between upstream requests during a crawl")
+	crawlOutput := flag.String("crawl-output", "", "File to write each crawled dataset to")
+	crawlRetries := flag.Int("crawl-retries", 3, "How many more times a crawl retries each postcode that failed")
+	crawlRetryBackoff := flag.Duration("crawl-retry-backoff", 30*time.Second, "Wait before the first round of crawl retries; doubles each round")
+	crawlDeadLetter := flag.String("crawl-dead-letter", "", "File each crawl writes a JSON report of its permanently failed postcodes to")
+	crawlCheckpoint := flag.String("crawl-checkpoint", "", "File crawl progress is saved to, so an interrupted crawl resumes where it stopped")
+	leaderLeaseFile := flag.String("leader-lease-file", "", "Lease file on shared storage used to elect the node that runs scheduled crawls when Redis is not configured")
+	logLevelName := flag.String("log-level", "info", "Minimum level logged: debug, info, warn or error; adjustable at runtime via PUT /admin/log-level")
+	logFormat := flag.String("log-format", "text", "Log line format: text or json")
+	listenAddr := flag.String("listen", ":8080", "Address for the API: host:port, or unix:/path/to.sock for a Unix domain socket")
+	reusePort := flag.Bool("reuse-port", false, "Open the API port with SO_REUSEPORT so a new process can start serving on it before the old one exits")
+	drainDelay := flag.Duration("drain-delay", 0, "On SIGTERM, how long to fail /healthz while still serving, so load balancers stop routing here first")
+	shutdownGrace := flag.Duration("shutdown-grace", 30*time.Second, "On SIGTERM, how long in-flight requests get to finish after the server stops accepting connections")
+	adminAddr := flag.String("admin-addr", "", "Address for the admin listener serving admin actions, pprof, expvar and /healthz, e.g. localhost:6060 or unix:/path/to.sock (empty disables it)")
+	publicHealth := flag.Bool("public-healthz", true, "Also serve /healthz on the public port; disable to keep it on -admin-addr only")
+	statsdAddr := flag.String("statsd-addr", "", "host:port of a statsd or DogStatsD agent to push metrics to over UDP (empty disables)")
+	statsdPrefix := flag.String("statsd-prefix", metricsNamespace, "Prefix of the metric names pushed to statsd")
+	statsdInterval := flag.Duration("statsd-interval", 10*time.Second, "How often metrics are pushed to -statsd-addr")
+	statsdTags := flag.String("statsd-tags", "", "Comma-separated DogStatsD tags added to every pushed metric, e.g. env:prod; setting any sends labels as tags too")
+	requestTimeout := flag.Duration("request-timeout", 15*time.Second, "Maximum time any API request may take")
+	endpointTimeoutSpec := flag.String("endpoint-timeouts", "", "Per-endpoint request timeouts overriding -request-timeout, e.g. /search=20s,/postcodes=5s")
+	flag.IntVar(&requestLimits.KeywordLength, "max-keyword-length", requestLimits.KeywordLength, "Maximum length of a search keyword, in characters")
+	flag.Int64Var(&requestLimits.BodyBytes, "max-body-bytes", requestLimits.BodyBytes, "Maximum size of a request body, in bytes")
+	flag.IntVar(&requestLimits.BatchSize, "max-batch-size", requestLimits.BatchSize, "Maximum number of items in a batch request")
+	adjacencyPath := flag.String("adjacency", "", "Path to a postcode adjacency CSV written by the adjacency command, served at /postcode/{code}/adjacent")
+	deliveryPointsPath := flag.String("delivery-points", "", "Path to a delivery point CSV (DPID extract or per-locality street_points counts) used to flag localities without deliverable street addresses")
+	regionsPath := flag.String("regions", "", "Path to a CSV of the local government area and federal electorate of each locality (suburb,state,lga,electorate), shown by /locality/{state}/{suburb}")
+	nzDatasetPath := flag.String("nz-dataset", "", "Path to a New Zealand postcode CSV (postcode,suburb,region) or packed dataset, searched with ?country=NZ")
+	aliasesPath := flag.String("aliases", "", "Path to a CSV of suburb aliases (alias,suburb,state) resolved by local searches and validation")
+	variantsPath := flag.String("variants", "", "Path to a CSV of other official locality names (variant,suburb,state[,language]), such as Indigenous dual names, found by local searches")
+	rulesPath := flag.String("validation-rules", "", "Path to a YAML file of address validation rules checked by POST /validate/rules")
+	keysPath := flag.String("api-keys", "", "Path to a CSV of API keys (name,key,daily_quota,monthly_quota); when set, every request needs an X-API-Key header")
+	jwtIssuer := flag.String("jwt-issuer", "", "Accept JWT bearer tokens from this issuer (requires -jwt-jwks-url)")
+	jwtJWKSURL := flag.String("jwt-jwks-url", "", "URL of the issuer's JWKS document holding its token signing keys")
+	jwtAudience := flag.String("jwt-audience", "", "Audience JWT bearer tokens must be issued for (empty skips the check)")
+	jwtScopes := flag.String("jwt-scopes", "", "Comma-separated scopes every JWT bearer token must grant")
+	allowCIDRs := flag.String("allow-cidrs", "", "Comma-separated CIDR ranges allowed to call the API (empty allows all)")
+	denyCIDRs := flag.String("deny-cidrs", "", "Comma-separated CIDR ranges refused even when allowed by -allow-cidrs")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma-separated CIDR ranges of proxies whose X-Forwarded-For header is trusted for -allow-cidrs/-deny-cidrs and -geoip-db")
+	geoIPPath := flag.String("geoip-db", "", "CSV of networks and states (network,state) used to list results from the client's state first")
+	webhookURLs := flag.String("webhook-url", "", "Comma-separated URLs notified when a refresh or crawl replaces the dataset; payloads are signed with $WEBHOOK_SECRET")
+	webhookAttempts := flag.Int("webhook-attempts", 6, "Delivery attempts per webhook before it is written to the dead-letter log")
+	webhookDeadLetter := flag.String("webhook-dead-letter", "", "File that undeliverable webhooks are appended to as JSON lines")
+	upstreamURL := flag.String("upstream-url", "", "Base URL of the upstream postcode search (default $UPSTREAM_URL, or "+defaultBaseURL+")")
+	userAgent := flag.String("user-agent", defaultUserAgent, "User-Agent sent to the upstream site; include a way to contact you")
+	userAgentsFile := flag.String("user-agents-file", "", "File of User-Agent strings, one per line, rotated between upstream requests (overrides -user-agent)")
+	httpCacheDir := flag.String("http-cache-dir", "", "Directory caching raw upstream pages, revalidated with ETag/Last-Modified (empty disables it)")
+	httpCacheMinTTL := flag.Duration("http-cache-min-ttl", 0, "Serve cached upstream pages without revalidating for at least this long")
+	paceMin := flag.Duration("pace-min", 250*time.Millisecond, "Shortest gap between upstream requests while the upstream answers quickly")
+	paceMax := flag.Duration("pace-max", 30*time.Second, "Longest gap between upstream requests while the upstream is slow or throttling")
+	paceTarget := flag.Duration("pace-target-latency", 2*time.Second, "Upstream response time above which requests are spaced further apart")
+	var transportTuning transportSettings
+	flag.IntVar(&transportTuning.MaxIdleConnsPerHost, "upstream-max-idle-conns-per-host", 16, "Idle connections kept open to the upstream for reuse")
+	flag.IntVar(&transportTuning.MaxConnsPerHost, "upstream-max-conns-per-host", 0, "Most connections open to the upstream at once (0 means no limit)")
+	flag.DurationVar(&transportTuning.IdleConnTimeout, "upstream-idle-timeout", 90*time.Second, "How long an idle upstream connection is kept open (0 means until the upstream closes it)")
+	flag.BoolVar(&transportTuning.DisableHTTP2, "upstream-disable-http2", false, "Talk HTTP/1.1 to the upstream even when it offers HTTP/2")
+	respectRobots := flag.Bool("respect-robots", true, "Honour the upstream robots.txt Disallow and Crawl-delay rules")
+	robotsFailClosed := flag.Bool("robots-fail-closed", false, "Refuse to scrape while the upstream robots.txt cannot be fetched")
+	auditLogPath := flag.String("audit-log", "", "File that administrative actions are appended to as JSON lines")
+	alertSlackWebhook := flag.String("alert-slack-webhook", "", "Slack incoming webhook URL that scrape failure alerts are posted to")
+	alertEmail := flag.String("alert-email", "", "Comma-separated addresses that scrape failure alerts are emailed to via $SMTP_ADDR")
+	alertWindow := flag.Duration("alert-window", 15*time.Minute, "Period over which scrape error and parse-failure rates are measured")
+	alertMinScrapes := flag.Int("alert-min-scrapes", 10, "Minimum scrapes in the window before an alert can fire")
+	alertErrorRate := flag.Float64("alert-error-rate", 0.5, "Fraction of scrapes failing that fires an alert (0 disables)")
+	canaryFile := flag.String("canary-file", "", "CSV of known-good lookups (keyword,postcode,suburb,state) checked in the background; while any fails, /healthz answers 503 and an alert is sent")
+	canaryInterval := flag.Duration("canary-interval", 5*time.Minute, "How often the -canary-file checks run")
+	alertParseFailureRate := flag.Float64("alert-parse-failure-rate", 0.2, "Fraction of scrapes whose results table is missing that fires an alert (0 disables)")
+	flag.Parse()
+
+	if err := setupLogging(*logLevelName, *logFormat); err != nil {
+		log.Fatalf("Error: Invalid logging settings: %v", err)
+	}
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	auditTrail = newAuditLog(*auditLogPath)
+	config := map[string]any{}
+	flag.Visit(func(f *flag.Flag) {
+		config[f.Name] = f.Value.String()
 	})
+	auditTrail.record("system", "config.load", config)
+	if readOnly = *readOnlyFlag; readOnly {
+		err := checkReadOnlyFlags(map[string]bool{
+			"crawl-interval": *crawlInterval > 0,
+			"search-history": *searchHistoryOn,
+			"http-cache-dir": *httpCacheDir != "",
+			"migrate-only":   *migrateOnly,
+		})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
 
-	// Log a warning if the selector fails, but allow the API to return a no-results message.
-	if !dataContainerFound {
-		log.Printf("Warning: Selector '%s' did not find any elements for keyword '%s'.", postcodeTableSelector, keyword)
+	var proxy *cachingProxy
+	if *proxyUpstream != "" {
+		target, err := parseProxyUpstream(*proxyUpstream)
+		if err == nil {
+			err = checkProxyFlags(map[string]bool{
+				"dataset":        *datasetPath != "",
+				"snapshot":       *snapshotLocation != "",
+				"dataset-url":    *datasetURL != "",
+				"crawl-interval": *crawlInterval > 0,
+			})
+		}
+		if err == nil && (*proxyCacheTTL <= 0 || *proxyCacheSize < 1) {
+			err = errors.New("-proxy-cache-ttl and -proxy-cache-size must be positive")
+		}
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		proxy = newCachingProxy(target, *proxyCacheTTL, *proxyCacheSize)
 	}
 
-	if len(resultsList) == 0 {
-		return fmt.Sprintf(`{"message": "No postcodes found for keyword '%s'. Please verify the CSS selectors."}`, keyword)
+	timeouts, err := parseEndpointTimeouts(*requestTimeout, *endpointTimeoutSpec)
+	if err != nil {
+		log.Fatalf("Error: Invalid -endpoint-timeouts: %v", err)
 	}
 
-	// 3. Return the data as a JSON string
-	jsonOutput, err := json.MarshalIndent(resultsList, "", "    ")
+	store, err := openStore(*storeSpec)
 	if err != nil {
-		return fmt.Sprintf(`{"error": "Failed to marshal JSON: %s"}`, err)
+		log.Fatalf("Error: Failed to open store: %v", err)
 	}
+	defer store.Close()
+	// Migrations run before anything reads the store, so every component sees
+	// the current schema.
+	if readOnly {
+		// A memory store starts empty, so it has no schema to be behind.
+		if _, inMemory := store.(*memoryStore); !inMemory {
+			if err := checkSchemaCurrent(store); err != nil {
+				log.Fatalf("Error: Store is not usable read-only: %v", err)
+			}
+		}
+		store = readOnlyStore{store}
+	} else {
+		migrated, err := migrateStore(store)
+		if err != nil {
+			log.Fatalf("Error: Failed to migrate store: %v", err)
+		}
+		if *migrateOnly {
+			version, _ := schemaVersion(store)
+			log.Printf("Applied %d store migrations; schema version is %d", migrated, version)
+			return
+		}
+	}
+	scrapeCache = &resultCache[[]PostcodeResult]{store: store, bucket: resultsBucket, ttl: *cacheTTL}
+	nearbyCache = &resultCache[NearbySuburbs]{store: store, bucket: nearbyBucket, ttl: *cacheTTL}
+	shippingZones = &zoneRegistry{store: store, bucket: zonesBucket}
+	datasetHistory, err = newRowHistory(store)
+	if err != nil {
+		log.Fatalf("Error: Failed to load dataset row history: %v", err)
+	}
+	privateAliases = &aliasRegistry{store: store, tables: map[string]privateAliasTable{}}
+	renderedResponses = newRenderedCache(*renderedCacheSize)
+	usageMeter = &usageCounter{store: store}
 
-	return string(jsonOutput)
-}
+	if *keysPath != "" {
+		apiKeysPath = *keysPath
+		apiKeys, err = loadAPIKeys(apiKeysPath)
+		if err != nil {
+			log.Fatalf("Error: Failed to load API keys: %v", err)
+		}
+		log.Printf("Loaded %d API keys from %s", len(apiKeys), apiKeysPath)
+	}
+	webhooks = newWebhookDispatcher(*webhookURLs, os.Getenv("WEBHOOK_SECRET"), *webhookAttempts, *webhookDeadLetter)
+	if webhooks != nil && len(webhooks.secret) == 0 {
+		log.Printf("Warning: WEBHOOK_SECRET is not set; webhook deliveries will be unsigned")
+	}
 
-func main() {
-	http.HandleFunc("/search", postcodeHandler)
-	port := "8080"
-	log.Printf("Starting postcode API server on http://localhost:%s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	alertDestinations, err := newAlertDestinations(*alertSlackWebhook, *alertEmail)
+	if err != nil {
+		log.Fatalf("Error: Invalid alert settings: %v", err)
+	}
+	scrapeAlerts = newAlertMonitor(*alertWindow, *alertMinScrapes, *alertErrorRate, *alertParseFailureRate, alertDestinations)
+
+	activeIPFilter, err = newIPFilter(*allowCIDRs, *denyCIDRs, *trustedProxies)
+	if err != nil {
+		log.Fatalf("Error: Invalid IP filter: %v", err)
+	}
+	proxies, err := parseCIDRList(*trustedProxies)
+	if err != nil {
+		log.Fatalf("Error: Invalid trusted proxies: %v", err)
+	}
+	if *geoIPPath != "" {
+		activeGeoIP, err = loadGeoIP(*geoIPPath, proxies)
+		if err != nil {
+			log.Fatalf("Error: Failed to load GeoIP database: %v", err)
+		}
+		log.Printf("Loaded %d GeoIP networks from %s", len(activeGeoIP.ranges), *geoIPPath)
+	}
+	if *searchHistoryOn {
+		searchLog = newSearchHistory(store, *searchHistoryRetention, proxies)
+		go searchLog.pruneLoop(context.Background())
+	}
+	if *jwtIssuer != "" || *jwtJWKSURL != "" {
+		jwtAuth, err = newJWTVerifier(*jwtIssuer, *jwtJWKSURL, *jwtAudience, *jwtScopes)
+		if err != nil {
+			log.Fatalf("Error: Invalid JWT settings: %v", err)
+		}
+	}
+
+	if *userAgentsFile != "" {
+		userAgents, err = loadUserAgents(*userAgentsFile)
+		if err != nil {
+			log.Fatalf("Error: Failed to load user agents: %v", err)
+		}
+	} else if strings.TrimSpace(*userAgent) != "" {
+		userAgents = []string{strings.TrimSpace(*userAgent)}
+	}
+
+	if *upstreamURL == "" {
+		*upstreamURL = os.Getenv("UPSTREAM_URL")
+	}
+	if *upstreamURL != "" {
+		if baseURL, err = parseBaseURL(*upstreamURL); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		log.Printf("Scraping upstream %s", baseURL)
+	}
+	if err := checkPacing(*paceMin, *paceMax, *paceTarget); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := checkTransportSettings(transportTuning); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	connections := newUpstreamTransport(transportTuning)
+	// The pacer sits under the robots guard, so it times only the page requests.
+	upstreamPacer = newAdaptivePacer(*paceMin, *paceMax, *paceTarget)
+	upstreamTransport = pacedTransport{pacer: upstreamPacer, next: timeoutTransport{timeout: upstreamFetchTimeout, next: connections}}
+	if *respectRobots {
+		upstreamTransport = robotsTransport{guard: newRobotsGuard(*robotsFailClosed, connections), next: upstreamTransport}
+	}
+	if *httpCacheDir != "" {
+		// The cache wraps the robots guard, so fresh pages are served without
+		// waiting out a Crawl-delay.
+		upstreamTransport, err = newHTTPCache(*httpCacheDir, *httpCacheMinTTL, upstreamTransport)
+		if err != nil {
+			log.Fatalf("Error: Failed to open HTTP cache: %v", err)
+		}
+	}
+
+	var redisClient *redis.Client
+	if *redisURL != "" {
+		redisClient, err = newRedisClient(*redisURL)
+		if err != nil {
+			log.Fatalf("Error: Failed to configure Redis: %v", err)
+		}
+		defer redisClient.Close()
+		invalidations = newInvalidationBus(redisClient)
+		go invalidations.listen(context.Background())
+	}
+
+	if *budget > 0 {
+		if redisClient != nil {
+			scrapeBudget = newRedisLimiter(redisClient, *budget)
+			log.Printf("Limiting upstream scrapes to %d per minute across all replicas", *budget)
+		} else {
+			scrapeBudget = newLocalLimiter(*budget)
+			log.Printf("Limiting upstream scrapes to %d per minute", *budget)
+		}
+	}
+
+	if *scrapeQueueDepth > 0 {
+		scrapeQueue = newKeywordQueue(*scrapeQueueDepth, *scrapeQueueWait)
+	}
+
+	sources := 0
+	for _, source := range []string{*datasetPath, *snapshotLocation, *datasetURL} {
+		if source != "" {
+			sources++
+		}
+	}
+
+	switch {
+	case sources > 1:
+		log.Fatalf("Error: -dataset, -snapshot and -dataset-url are mutually exclusive")
+	case *datasetPath != "":
+		dataset, err := loadDataset(*datasetPath)
+		if err != nil {
+			log.Fatalf("Error: Failed to load dataset: %v", err)
+		}
+		activeDataset.Store(dataset)
+		log.Printf("Loaded %d rows from dataset %s", len(dataset.Rows), *datasetPath)
+		datasetReloader = func(ctx context.Context) (*Dataset, string, error) {
+			dataset, err := loadDataset(*datasetPath)
+			return dataset, *datasetPath, err
+		}
+	case *snapshotLocation != "":
+		datasetReloader = func(ctx context.Context) (*Dataset, string, error) {
+			return loadSnapshotDataset(ctx, *snapshotLocation)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		dataset, key, err := loadSnapshotDataset(ctx, *snapshotLocation)
+		cancel()
+		switch {
+		case err == nil:
+			activeDataset.Store(dataset)
+			log.Printf("Loaded %d rows from snapshot %s", len(dataset.Rows), key)
+		case *crawlInterval > 0:
+			// A crawling node may be the one that publishes the first snapshot.
+			log.Printf("Warning: no snapshot loaded, serving by scraping until the first crawl: %v", err)
+		default:
+			log.Fatalf("Error: Failed to load snapshot: %v", err)
+		}
+	case *datasetURL != "":
+		if *datasetSHA256 == "" && *datasetChecksumURL == "" {
+			log.Printf("Warning: -dataset-url is not being checksum-verified; set -dataset-sha256 or -dataset-checksum-url")
+		}
+		remote := newRemoteDataset(*datasetURL, *datasetChecksumURL, *datasetSHA256)
+		dataset, err := remote.load(context.Background())
+		if err != nil {
+			log.Fatalf("Error: Failed to load dataset: %v", err)
+		}
+		activeDataset.Store(dataset)
+		log.Printf("Loaded %d rows from %s", len(dataset.Rows), *datasetURL)
+		datasetReloader = func(ctx context.Context) (*Dataset, string, error) {
+			dataset, err := remote.load(ctx)
+			return dataset, *datasetURL, err
+		}
+		if *datasetRefresh > 0 {
+			go remote.refreshLoop(context.Background(), *datasetRefresh)
+		}
+	}
+
+	// Rows removed since the last run are soft-deleted as of now.
+	trackDataset(currentDataset())
+	markDatasetVerified(currentDataset())
+
+	if *nzDatasetPath != "" {
+		dataset, err := loadDataset(*nzDatasetPath)
+		if err != nil {
+			log.Fatalf("Error: Failed to load New Zealand dataset: %v", err)
+		}
+		countrySources["NZ"] = newDatasetSource(dataset)
+		log.Printf("Loaded %d New Zealand rows from %s", len(dataset.Rows), *nzDatasetPath)
+	}
+	if *deliveryPointsPath != "" {
+		activeDeliveryPoints, err = loadDeliveryPoints(*deliveryPointsPath)
+		if err != nil {
+			log.Fatalf("Error: Failed to load delivery points: %v", err)
+		}
+		log.Printf("Loaded delivery points from %s", *deliveryPointsPath)
+	}
+	if *regionsPath != "" {
+		activeRegions, err = loadRegions(*regionsPath)
+		if err != nil {
+			log.Fatalf("Error: Failed to load regions: %v", err)
+		}
+		log.Printf("Loaded regions of %d localities from %s", len(activeRegions.regions), *regionsPath)
+	}
+	if *adjacencyPath != "" {
+		activeAdjacency, err = loadAdjacency(*adjacencyPath)
+		if err != nil {
+			log.Fatalf("Error: Failed to load postcode adjacency: %v", err)
+		}
+		log.Printf("Loaded adjacency for %d postcodes from %s", len(activeAdjacency), *adjacencyPath)
+	}
+	if *aliasesPath != "" {
+		aliases, err := loadAliases(*aliasesPath)
+		if err != nil {
+			log.Fatalf("Error: Failed to load aliases: %v", err)
+		}
+		activeAliases.Store(aliases)
+		log.Printf("Loaded %d suburb aliases from %s", len(aliases.aliases), *aliasesPath)
+	}
+	if *variantsPath != "" {
+		variants, err := loadVariants(*variantsPath)
+		if err != nil {
+			log.Fatalf("Error: Failed to load variants: %v", err)
+		}
+		activeVariants = variants
+		log.Printf("Loaded %d locality name variants from %s", len(variants.variants), *variantsPath)
+	}
+	if *rulesPath != "" {
+		validationRules, err = loadValidationRules(*rulesPath)
+		if err != nil {
+			log.Fatalf("Error: Failed to load validation rules: %v", err)
+		}
+		log.Printf("Loaded %d validation rules from %s", len(validationRules), *rulesPath)
+	}
+
+	if *crawlInterval > 0 {
+		elector := newLeaderElector(redisClient, *leaderLeaseFile, "crawl")
+		c := &crawler{
+			delay:        *crawlDelay,
+			checkpoint:   *crawlCheckpoint,
+			retries:      *crawlRetries,
+			retryBackoff: *crawlRetryBackoff,
+			deadLetter:   *crawlDeadLetter,
+		}
+		// An unfinished crawl left a checkpoint behind; pick it up now rather than
+		// a whole interval later.
+		_, err := os.Stat(*crawlCheckpoint)
+		resume := *crawlCheckpoint != "" && err == nil
+		go runScheduled(context.Background(), "crawl", *crawlInterval, resume, elector, func(ctx context.Context) error {
+			return runCrawlJob(ctx, c, *crawlOutput, *snapshotLocation)
+		})
+	}
+
+	if *canaryFile != "" {
+		checks, err := loadCanaryChecks(*canaryFile)
+		if err != nil {
+			log.Fatalf("Error: Failed to load canary checks: %v", err)
+		}
+		canary = newCanaryMonitor(checks)
+		go canary.loop(context.Background(), *canaryInterval)
+		log.Printf("Running %d canary checks every %s", len(checks), *canaryInterval)
+	}
+
+	if *statsdAddr != "" {
+		if *statsdInterval <= 0 {
+			log.Fatalf("Error: -statsd-interval must be positive")
+		}
+		pusher, err := newStatsdPusher(*statsdAddr, *statsdPrefix, *statsdTags)
+		if err != nil {
+			log.Fatalf("Error: Failed to set up statsd: %v", err)
+		}
+		go pusher.loop(context.Background(), *statsdInterval)
+		log.Printf("Pushing metrics to statsd at %s every %s", *statsdAddr, *statsdInterval)
+	}
+
+	if *adminAddr != "" {
+		if sameListenAddr(*adminAddr, *listenAddr) {
+			log.Fatalf("Error: -admin-addr must differ from -listen, or admin endpoints would be public")
+		}
+		go serveAdmin(*adminAddr, *reusePort)
+	}
+
+	// The public API gets its own mux: importing net/http/pprof and expvar registers
+	// their handlers on http.DefaultServeMux, which must never be served publicly.
+	mux := http.NewServeMux()
+	route := func(pattern string, handler http.HandlerFunc) {
+		mux.Handle(pattern, withRequestID(withTimeout(timeouts.forPattern(pattern), withETag(withBodyLimit(withNegotiation(withIPFilter(withAuth(handler))))))))
+	}
+	// Listings can run to megabytes, so they stream under a context deadline
+	// rather than being buffered by withTimeout.
+	routeStreamed := func(pattern string, handler http.HandlerFunc) {
+		mux.Handle(pattern, withRequestID(withDeadline(timeouts.forPattern(pattern), withBodyLimit(withNegotiation(withIPFilter(withAuth(handler)))))))
+	}
+	if proxy != nil {
+		// Everything is relayed to the proxied server, which authenticates the
+		// caller and negotiates the format itself; the IP filter still applies here.
+		mux.Handle("/", withRequestID(withTimeout(timeouts.forPattern("/"), withBodyLimit(withIPFilter(proxy)))))
+		log.Printf("Proxying to %s, caching responses for %s", *proxyUpstream, *proxyCacheTTL)
+	} else {
+		route("/search", postcodeHandler)
+		routeStreamed("GET /postcodes", postcodesHandler)
+		route("GET /states", statesHandler)
+		routeStreamed("GET /states/{state}/postcodes", statePostcodesHandler)
+		routeStreamed("GET /suburbs", suburbsHandler)
+		route("GET /random", randomHandler)
+		routeStreamed("GET /dataset/changes", datasetChangesHandler)
+		route("GET /dataset/changes.atom", changesAtomHandler)
+		route("GET /dataset/changes.json", changesJSONFeedHandler)
+		route("GET /postcode/{code}/state", postcodeStateHandler)
+		route("GET /postcode/{code}/delivery", deliveryHandler)
+		route("GET /postcode/{code}/adjacent", adjacentHandler)
+		route("GET /suburb/{name}/nearby", nearbyHandler)
+		route("GET /locality/{state}/{suburb}", localityHandler)
+		route("GET /geo/within", geoWithinHandler)
+		route("GET /geo/reverse", geoReverseHandler)
+		route("GET /validate", validateHandler)
+		route("POST /pobox/validate", poBoxValidateHandler)
+		route("POST /validate/rules", ruleValidateHandler)
+		route("GET /deliverable", deliverableHandler)
+		route("POST /zones", rejectWhenReadOnly(createZoneHandler))
+		route("GET /zones", listZonesHandler)
+		route("GET /zones/{id}", getZoneHandler)
+		route("DELETE /zones/{id}", rejectWhenReadOnly(deleteZoneHandler))
+		route("GET /zones/{id}/contains", zoneContainsHandler)
+		route("GET /aliases", listAliasesHandler)
+		route("PUT /aliases", rejectWhenReadOnly(replaceAliasesHandler))
+		route("DELETE /aliases", rejectWhenReadOnly(deleteAliasesHandler))
+		route("POST /contains", bulkContainsHandler)
+		route("GET /suggest", suggestHandler)
+		route("GET /errors", errorsHandler)
+		route("GET /usage", usageHandler)
+		route("GET /status", statusHandler)
+	}
+
+	// Health checks skip authentication and IP filtering so load balancers can
+	// always probe them.
+	if *publicHealth {
+		mux.HandleFunc("GET /healthz", healthHandler)
+	}
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      timeouts.longest() + writeTimeoutSlack,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+	listener, err := listen(*listenAddr, *reusePort)
+	if err != nil {
+		log.Fatalf("Error: Server failed to start: %v", err)
+	}
+	log.Printf("Starting postcode API server on %s", listener.Addr())
+	if err := serveUntilSignal(server, listener, *drainDelay, *shutdownGrace); err != nil {
+		log.Printf("Server stopped: %v", err)
 	}
 }
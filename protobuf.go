@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The encoders below write the messages defined in proto/postcode.proto directly
+// with protowire, which keeps the build free of generated code.
+
+// appendString appends a string field, omitting empty values as proto3 does.
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// marshalPostcodeResult encodes a postcode.v1.PostcodeResult.
+func marshalPostcodeResult(r PostcodeResult) []byte {
+	var b []byte
+	b = appendString(b, 1, r.Postcode)
+	b = appendString(b, 2, r.Suburb)
+	b = appendString(b, 3, r.State)
+	b = appendString(b, 4, r.Category)
+	b = appendString(b, 5, r.LocalityType)
+	b = appendString(b, 6, r.DeliveryOffice)
+	b = appendString(b, 7, r.BSPNumber)
+	b = appendString(b, 8, r.BSPName)
+	b = appendString(b, 9, r.AliasOf)
+	if r.Score != 0 {
+		b = protowire.AppendTag(b, 10, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(r.Score))
+	}
+	b = appendString(b, 11, r.EffectiveTo)
+	b = appendString(b, 12, r.DualName)
+	b = appendString(b, 13, r.LastVerifiedAt)
+	return b
+}
+
+// marshalParseWarning encodes a postcode.v1.ParseWarning.
+func marshalParseWarning(w ParseWarning) []byte {
+	var b []byte
+	if w.Row != 0 {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(w.Row))
+	}
+	return appendString(b, 2, w.Message)
+}
+
+// marshalSearchResponse encodes a postcode.v1.SearchResponse.
+func marshalSearchResponse(results []PostcodeResult, warnings []ParseWarning) []byte {
+	var b []byte
+	for _, r := range results {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalPostcodeResult(r))
+	}
+	for _, w := range warnings {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalParseWarning(w))
+	}
+	return b
+}
+
+// marshalCountResponse encodes a postcode.v1.CountResponse.
+func marshalCountResponse(count int) []byte {
+	if count == 0 {
+		return []byte{}
+	}
+	b := protowire.AppendTag(nil, 1, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(count))
+}
+
+// marshalError encodes a postcode.v1.Error.
+func marshalError(message, code string) []byte {
+	b := appendString([]byte{}, 1, message)
+	return appendString(b, 2, code)
+}
+
+// marshalProtobuf encodes the response values that have a protobuf message.
+// It reports false for any other value.
+func marshalProtobuf(v any) ([]byte, bool) {
+	switch v := v.(type) {
+	case []PostcodeResult:
+		return marshalSearchResponse(v, nil), true
+	case warnedResults:
+		if results, ok := v.Results.([]PostcodeResult); ok {
+			return marshalSearchResponse(results, v.Warnings), true
+		}
+	case map[string]int:
+		if count, ok := v["count"]; ok && len(v) == 1 {
+			return marshalCountResponse(count), true
+		}
+	case map[string]string:
+		// No-result bodies carry a "message" instead of an "error".
+		if message, ok := v["message"]; ok {
+			return marshalError(message, v["code"]), true
+		}
+	}
+	return nil, false
+}
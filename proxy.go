@@ -0,0 +1,273 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyFetchTimeout bounds a fetch from the proxied server. Fetches are shared
+// between coalesced requests, so they don't end when the first client leaves.
+const proxyFetchTimeout = 30 * time.Second
+
+// hopHeaders are connection-level headers that are not passed through the proxy.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// perClientHeaders are left out of a shared fetch, since they concern only the
+// client that happened to start it: conditionals are answered per client from the
+// fetched response, and one client's X-Timeout-Ms must not cut the fetch short
+// for the others.
+var perClientHeaders = []string{"If-None-Match", "If-Modified-Since", clientTimeoutHeader}
+
+// proxiedResponse is a buffered response from the proxied server.
+type proxiedResponse struct {
+	status    int
+	header    http.Header
+	body      []byte
+	fetchedAt time.Time
+}
+
+// proxyCall is a fetch in flight, shared by the identical requests waiting on it.
+type proxyCall struct {
+	done chan struct{}
+	resp *proxiedResponse
+	err  error
+}
+
+// cachingProxy serves as a cache in front of another instance of this server,
+// or any HTTP origin, for -proxy-upstream. Identical GET requests arriving while
+// one is being fetched wait for it instead of each going upstream, and
+// successful responses are reused for ttl. Other methods are passed straight
+// through.
+type cachingProxy struct {
+	target  *url.URL
+	ttl     time.Duration
+	max     int
+	client  *http.Client
+	forward *httputil.ReverseProxy
+
+	mu       sync.Mutex
+	order    *list.List // front is most recently used; values are cache keys
+	entries  map[string]*list.Element
+	cached   map[string]*proxiedResponse
+	inflight map[string]*proxyCall
+}
+
+func newCachingProxy(target *url.URL, ttl time.Duration, size int) *cachingProxy {
+	return &cachingProxy{
+		target:   target,
+		ttl:      ttl,
+		max:      size,
+		client:   &http.Client{Timeout: proxyFetchTimeout},
+		forward:  httputil.NewSingleHostReverseProxy(target),
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+		cached:   map[string]*proxiedResponse{},
+		inflight: map[string]*proxyCall{},
+	}
+}
+
+// proxyKey identifies a response by everything that can change it: the URL, the
+// negotiated format and the caller's credentials, hashed so keys aren't kept
+// in memory.
+func proxyKey(r *http.Request) string {
+	h := sha256.New()
+	for _, part := range []string{r.URL.RequestURI(), r.Header.Get("Accept"), r.Header.Get("Authorization"), r.Header.Get("X-API-Key")} {
+		io.WriteString(h, part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (p *cachingProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		p.forward.ServeHTTP(w, r)
+		return
+	}
+
+	key := proxyKey(r)
+	if resp, ok := p.lookup(key); ok {
+		p.write(w, r, resp, "HIT")
+		return
+	}
+
+	p.mu.Lock()
+	call, shared := p.inflight[key]
+	if !shared {
+		call = &proxyCall{done: make(chan struct{})}
+		p.inflight[key] = call
+		go p.fetch(r, key, call)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-r.Context().Done():
+		if clientTimedOut(r.Context()) {
+			writeClientTimeout(w)
+		}
+		return
+	}
+	if call.err != nil {
+		logf(r.Context(), "Warning: proxy fetch of %s failed: %v", r.URL.RequestURI(), call.err)
+		writeError(w, http.StatusBadGateway, codeUpstreamError, "The proxied server could not be reached")
+		return
+	}
+	verdict := "MISS"
+	if shared {
+		verdict = "COALESCED"
+	}
+	p.write(w, r, call.resp, verdict)
+}
+
+// fetch gets r from the proxied server for everyone waiting on call, and caches
+// the response when it may be reused.
+func (p *cachingProxy) fetch(r *http.Request, key string, call *proxyCall) {
+	defer func() {
+		p.mu.Lock()
+		delete(p.inflight, key)
+		p.mu.Unlock()
+		close(call.done)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), proxyFetchTimeout)
+	defer cancel()
+	out := r.Clone(ctx)
+	out.RequestURI = ""
+	out.Method = http.MethodGet // a HEAD fills the cache for later GETs too
+	p.forward.Director(out)
+	for _, name := range append(hopHeaders, perClientHeaders...) {
+		out.Header.Del(name)
+	}
+	if id := requestID(r.Context()); id != "" {
+		out.Header.Set(requestIDHeader, id)
+	}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := out.Header.Get("X-Forwarded-For"); prior != "" {
+			ip = prior + ", " + ip
+		}
+		out.Header.Set("X-Forwarded-For", ip)
+	}
+
+	resp, err := p.client.Do(out)
+	if err != nil {
+		call.err = err
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		call.err = fmt.Errorf("read response: %w", err)
+		return
+	}
+	for _, name := range hopHeaders {
+		resp.Header.Del(name)
+	}
+	call.resp = &proxiedResponse{status: resp.StatusCode, header: resp.Header, body: body, fetchedAt: time.Now()}
+	if cacheableProxyResponse(resp) {
+		p.store(key, call.resp)
+	}
+}
+
+// cacheableProxyResponse reports whether a response may be reused for other
+// requests: a success or a not-found the origin hasn't marked private or no-store.
+func cacheableProxyResponse(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return false
+	}
+	cacheControl := strings.ToLower(resp.Header.Get("Cache-Control"))
+	return !strings.Contains(cacheControl, "no-store") && !strings.Contains(cacheControl, "private")
+}
+
+func (p *cachingProxy) lookup(key string) (*proxiedResponse, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	element, ok := p.entries[key]
+	if !ok {
+		return nil, false
+	}
+	resp := p.cached[key]
+	if time.Since(resp.fetchedAt) > p.ttl {
+		p.order.Remove(element)
+		delete(p.entries, key)
+		delete(p.cached, key)
+		return nil, false
+	}
+	p.order.MoveToFront(element)
+	return resp, true
+}
+
+func (p *cachingProxy) store(key string, resp *proxiedResponse) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if element, ok := p.entries[key]; ok {
+		p.order.MoveToFront(element)
+	} else {
+		p.entries[key] = p.order.PushFront(key)
+	}
+	p.cached[key] = resp
+	for p.order.Len() > p.max {
+		oldest := p.order.Back()
+		p.order.Remove(oldest)
+		delete(p.entries, oldest.Value.(string))
+		delete(p.cached, oldest.Value.(string))
+	}
+}
+
+// write sends a proxied response, with X-Cache saying how it was obtained and Age
+// how long ago it was fetched. A client whose If-None-Match names the response's
+// ETag gets 304 Not Modified instead.
+func (p *cachingProxy) write(w http.ResponseWriter, r *http.Request, resp *proxiedResponse, verdict string) {
+	header := w.Header()
+	for name, values := range resp.header {
+		// The request's own ID, set by the middleware, wins over the fetch's.
+		if name == requestIDHeader {
+			continue
+		}
+		header[name] = values
+	}
+	header.Set("X-Cache", verdict)
+	header.Set("Age", strconv.Itoa(int(time.Since(resp.fetchedAt).Seconds())))
+	if tag := resp.header.Get("ETag"); resp.status == http.StatusOK && tag != "" && etagMatches(r.Header.Get("If-None-Match"), tag) {
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(resp.status)
+	if r.Method != http.MethodHead {
+		w.Write(resp.body)
+	}
+}
+
+// parseProxyUpstream checks a -proxy-upstream value: an absolute http or https URL.
+func parseProxyUpstream(raw string) (*url.URL, error) {
+	target, err := url.Parse(raw)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, errors.New("-proxy-upstream must be an absolute http or https URL")
+	}
+	return target, nil
+}
+
+// checkProxyFlags rejects flags that give a -proxy-upstream server data of its
+// own to answer from.
+func checkProxyFlags(flags map[string]bool) error {
+	for _, name := range []string{"dataset", "snapshot", "dataset-url", "crawl-interval"} {
+		if flags[name] {
+			return fmt.Errorf("-%s cannot be used with -proxy-upstream", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import "strings"
+
+// searchQuery is a search keyword split into the suburb name and any state or
+// postcode typed alongside it, the way the auspost search box reads "richmond vic"
+// or "3121 richmond".
+type searchQuery struct {
+	Suburb   string
+	State    string // upper-case state code, or empty
+	Postcode string
+}
+
+// parseSearchQuery detects a trailing state code and a leading or trailing postcode
+// in keyword. A keyword that is nothing but a state or a postcode is left whole, so
+// "vic" and "3121" still search as before.
+func parseSearchQuery(keyword string) searchQuery {
+	words := strings.Fields(keyword)
+	q := searchQuery{}
+	word := func(i int) string { return strings.Trim(words[i], ",.") }
+
+	// "richmond vic 3121" and "richmond 3121"
+	if n := len(words); n > 1 && fourDigits.MatchString(word(n-1)) {
+		q.Postcode, words = word(n-1), words[:n-1]
+	}
+	// "richmond vic" and "richmond, vic"
+	if n := len(words); n > 1 {
+		if state := strings.ToUpper(word(n - 1)); stateRanges[state] != nil {
+			q.State, words = state, words[:n-1]
+		}
+	}
+	// "3121 richmond"
+	if n := len(words); n > 1 && q.Postcode == "" && fourDigits.MatchString(word(0)) {
+		q.Postcode, words = word(0), words[1:]
+	}
+
+	q.Suburb = strings.Trim(strings.Join(words, " "), ", ")
+	return q
+}
+
+// filter keeps the results in the query's state and postcode, if it has them.
+func (q searchQuery) filter(results []PostcodeResult) []PostcodeResult {
+	if q.State == "" && q.Postcode == "" {
+		return results
+	}
+	kept := []PostcodeResult{}
+	for _, result := range results {
+		if (q.State == "" || strings.EqualFold(result.State, q.State)) &&
+			(q.Postcode == "" || result.Postcode == q.Postcode) {
+			kept = append(kept, result)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// radixTree maps string keys to dataset row numbers, sharing common key prefixes
+// between edges, so every key starting with a prefix is found by walking the
+// prefix once rather than by testing every row.
+type radixTree struct {
+	root radixNode
+}
+
+type radixNode struct {
+	label    string       // the key bytes on the edge from the parent
+	rows     []int        // rows whose key ends at this node
+	children []*radixNode // ordered by the first byte of their label
+}
+
+// buildRadixTree indexes each row under the key returned for it.
+func buildRadixTree(rows []PostcodeResult, key func(PostcodeResult) string) *radixTree {
+	tree := &radixTree{}
+	for i, row := range rows {
+		tree.insert(key(row), i)
+	}
+	return tree
+}
+
+// child returns the position of the child whose label starts with b, and whether
+// there is one; otherwise the position a new child for b belongs at.
+func (n *radixNode) child(b byte) (int, bool) {
+	i := sort.Search(len(n.children), func(i int) bool { return n.children[i].label[0] >= b })
+	return i, i < len(n.children) && n.children[i].label[0] == b
+}
+
+func (t *radixTree) insert(key string, row int) {
+	n := &t.root
+	for key != "" {
+		i, ok := n.child(key[0])
+		if !ok {
+			n.children = slices.Insert(n.children, i, &radixNode{label: key, rows: []int{row}})
+			return
+		}
+		next := n.children[i]
+		common := 0
+		for common < len(next.label) && common < len(key) && next.label[common] == key[common] {
+			common++
+		}
+		if common < len(next.label) {
+			// The key leaves the edge part way along: split it at that point.
+			split := &radixNode{label: next.label[:common], children: []*radixNode{next}}
+			next.label = next.label[common:]
+			n.children[i] = split
+			next = split
+		}
+		n, key = next, key[common:]
+	}
+	n.rows = append(n.rows, row)
+}
+
+// withPrefix returns the ascending row numbers of every key starting with prefix.
+func (t *radixTree) withPrefix(prefix string) []int {
+	n := &t.root
+	for prefix != "" {
+		i, ok := n.child(prefix[0])
+		if !ok {
+			return nil
+		}
+		next := n.children[i]
+		switch {
+		case strings.HasPrefix(prefix, next.label):
+			prefix = prefix[len(next.label):]
+		case strings.HasPrefix(next.label, prefix):
+			prefix = ""
+		default:
+			return nil
+		}
+		n = next
+	}
+
+	var rows []int
+	var collect func(*radixNode)
+	collect = func(n *radixNode) {
+		rows = append(rows, n.rows...)
+		for _, child := range n.children {
+			collect(child)
+		}
+	}
+	collect(n)
+	// Keys come out in byte order; callers expect dataset order, as a scan gives.
+	sort.Ints(rows)
+	return rows
+}
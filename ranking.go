@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Match tiers used to rank results against the searched keyword, best first.
+const (
+	matchOther = iota
+	matchSubstring
+	matchPrefix
+	matchExact
+)
+
+// tierScores maps each match tier to the score reported when ?score=true is requested.
+var tierScores = map[int]float64{
+	matchExact:     1.0,
+	matchPrefix:    0.75,
+	matchSubstring: 0.5,
+	matchOther:     0.25,
+}
+
+// normalizeName lower-cases a suburb name or keyword, drops apostrophes and treats
+// hyphens and other punctuation as spaces, so "North  Sydney ", "north-sydney" and
+// "North Sydney" compare equal, as do "O'Connor" and "oconnor".
+func normalizeName(name string) string {
+	return strings.Join(tokenize(name), " ")
+}
+
+// matchTier classifies how well a suburb name matches the keyword.
+func matchTier(suburb, keyword string) int {
+	suburb = normalizeName(suburb)
+	keyword = normalizeName(keyword)
+
+	switch {
+	case keyword == "":
+		return matchOther
+	case suburb == keyword:
+		return matchExact
+	case strings.HasPrefix(suburb, keyword):
+		return matchPrefix
+	case strings.Contains(suburb, keyword):
+		return matchSubstring
+	default:
+		return matchOther
+	}
+}
+
+// rankResults orders results so exact suburb matches come first, followed by prefix
+// and then substring matches. The sort is stable, so results within a tier keep the
+// upstream order. When withScore is set, each result's Score field is populated.
+func rankResults(results []PostcodeResult, keyword string, withScore bool) {
+	type rankedResult struct {
+		result PostcodeResult
+		tier   int
+	}
+
+	ranked := make([]rankedResult, len(results))
+	for i, result := range results {
+		tier := matchTier(result.Suburb, keyword)
+		// A locality found by one of its other names ranks by the best of them.
+		if result.DualName != "" {
+			for _, name := range strings.Split(result.DualName, " / ") {
+				tier = max(tier, matchTier(name, keyword))
+			}
+		}
+		ranked[i] = rankedResult{result: result, tier: tier}
+	}
+	sort.SliceStable(ranked, func(a, b int) bool {
+		return ranked[a].tier > ranked[b].tier
+	})
+
+	for i, r := range ranked {
+		results[i] = r.result
+		if withScore {
+			results[i].Score = tierScores[r.tier]
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RawCells are the cell texts of the upstream table row a scraped result was
+// parsed from, before the suburb and state were split apart or any casing was
+// applied. Runs of whitespace are collapsed as a browser would show them;
+// nothing else is changed.
+type RawCells struct {
+	Postcode string `json:"postcode"`
+	Suburb   string `json:"suburb"`
+	Category string `json:"category"`
+}
+
+// searchIncludes are the optional parts of a search response asked for with
+// ?include=.
+type searchIncludes struct {
+	raw      bool // each scraped result's upstream cell texts
+	warnings bool // the parse warnings, wrapping the results
+}
+
+// parseInclude reads ?include=, a comma-separated list of optional parts of a
+// search response: "raw" and "warnings".
+func parseInclude(raw string) (searchIncludes, error) {
+	var include searchIncludes
+	if raw == "" {
+		return include, nil
+	}
+	for _, part := range strings.Split(raw, ",") {
+		switch part = strings.TrimSpace(part); part {
+		case "raw":
+			include.raw = true
+		case "warnings":
+			include.warnings = true
+		default:
+			return searchIncludes{}, fmt.Errorf("Invalid 'include' parameter: %q is not one of raw, warnings", part)
+		}
+	}
+	return include, nil
+}
+
+// withoutRaw returns a copy of results with their raw cell texts left out, for
+// clients that didn't ask for them with ?include=raw.
+func withoutRaw(results []PostcodeResult) []PostcodeResult {
+	stripped := make([]PostcodeResult, len(results))
+	for i, result := range results {
+		result.Raw = nil
+		stripped[i] = result
+	}
+	return stripped
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// readOnly is set by -read-only, for replicas serving a shared snapshot: nothing
+// may write to the store, crawl, or change server data through the API.
+var readOnly bool
+
+// errReadOnly is returned by writes to the store in read-only mode.
+var errReadOnly = errors.New("the server is in read-only mode")
+
+// readOnlyStore wraps a Store so that any write fails. Components skip their
+// writes in read-only mode; this catches one that doesn't.
+type readOnlyStore struct {
+	Store
+}
+
+func (readOnlyStore) Put(bucket, key string, value []byte) error { return errReadOnly }
+func (readOnlyStore) Delete(bucket, key string) error            { return errReadOnly }
+
+// checkReadOnlyFlags rejects the flags whose features write, which a read-only
+// server can't honour. Each value is whether that flag is in use.
+func checkReadOnlyFlags(flags map[string]bool) error {
+	for _, name := range []string{"crawl-interval", "search-history", "http-cache-dir", "migrate-only"} {
+		if flags[name] {
+			return fmt.Errorf("-%s cannot be used with -read-only", name)
+		}
+	}
+	return nil
+}
+
+// checkSchemaCurrent fails when the store needs migrations, which a read-only
+// server can't apply.
+func checkSchemaCurrent(store Store) error {
+	version, err := schemaVersion(store)
+	if err != nil {
+		return err
+	}
+	if latest := migrations[len(migrations)-1].version; version != latest {
+		return fmt.Errorf("store schema version is %d, this release needs %d; run -migrate-only against it from a writable instance", version, latest)
+	}
+	return nil
+}
+
+// rejectWhenReadOnly answers requests to a handler that changes data with 403 in
+// read-only mode.
+func rejectWhenReadOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if readOnly {
+			writeError(w, http.StatusForbidden, codeReadOnly, "This server is read-only and does not accept changes")
+			return
+		}
+		next(w, r)
+	}
+}
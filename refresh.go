@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+)
+
+// liveFetchKey marks a context whose upstream requests must reach the upstream,
+// not be answered from a fresh page in the HTTP cache.
+type liveFetchKey struct{}
+
+func withLiveFetch(ctx context.Context) context.Context {
+	return context.WithValue(ctx, liveFetchKey{}, true)
+}
+
+func liveFetch(ctx context.Context) bool {
+	live, _ := ctx.Value(liveFetchKey{}).(bool)
+	return live
+}
+
+// authorizeRefresh checks a /search?refresh=true request, which skips the caches
+// and scrapes the keyword live. Only admins may force scrapes, so the request must
+// carry $ADMIN_TOKEN in X-Admin-Token: the Authorization header already belongs
+// to API clients. It writes the error response and returns false otherwise.
+func authorizeRefresh(w http.ResponseWriter, r *http.Request) bool {
+	if currentDataset() != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, "refresh=true only applies to scraped searches; this server answers from a local dataset")
+		return false
+	}
+	if readOnly {
+		writeError(w, http.StatusForbidden, codeReadOnly, "This server is read-only and cannot cache a refreshed result")
+		return false
+	}
+	token := r.Header.Get("X-Admin-Token")
+	if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, codeAdminTokenInvalid, "refresh=true requires the admin token in the X-Admin-Token header")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// renderedTTL bounds how long an encoded response is reused. Scraped results have
+// their own, longer, cache TTL; this keeps a rendered copy from outliving it by
+// much.
+const renderedTTL = 5 * time.Minute
+
+// jsonBuffers are reused between responses kept in the rendered cache, so
+// encoding one doesn't grow a fresh buffer each time.
+var jsonBuffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// encodeJSON writes v to w as JSON, indented for ?pretty=true. json.Encoder
+// encodes v whole before writing it, so a large listing is held in memory once
+// while it is written; routes under withDeadline then pass it to the client
+// without buffering a second copy. Keep, when non-nil, receives a copy of the
+// body, which is encoded through a pooled buffer instead.
+func encodeJSON(w http.ResponseWriter, status int, v any, keep *[]byte) error {
+	w.Header().Set("Content-Type", "application/json")
+	if keep == nil {
+		w.WriteHeader(status)
+		enc := json.NewEncoder(w)
+		if prettyJSON(w) {
+			enc.SetIndent("", "    ")
+		}
+		return enc.Encode(v)
+	}
+
+	buf := jsonBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		// Don't let one huge response pin a huge buffer in the pool.
+		if buf.Cap() <= 1<<20 {
+			buf.Reset()
+			jsonBuffers.Put(buf)
+		}
+	}()
+	buf.Reset()
+	enc := json.NewEncoder(buf)
+	if prettyJSON(w) {
+		enc.SetIndent("", "    ")
+	}
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	*keep = bytes.Clone(buf.Bytes())
+	w.WriteHeader(status)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// renderedEntry is the encoded body of one search response, valid while the data
+// it was built from is current.
+type renderedEntry struct {
+	key        string
+	body       []byte
+	results    int
+	dataset    *Dataset
+	aliases    *aliasTable
+	generation uint64
+	expires    time.Time
+}
+
+// renderedCache keeps the encoded JSON bodies of the most recently used plain
+// searches (a keyword and nothing else), so popular lookups skip decoding the
+// cached results, ranking, filtering and encoding. It is a small LRU in memory;
+// anything it misses goes the normal way.
+type renderedCache struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+	// generation is bumped when the scrape caches are flushed, invalidating every
+	// entry built from them.
+	generation atomic.Uint64
+}
+
+// renderedResponses is the configured cache, or nil when -rendered-cache-size is 0.
+var renderedResponses *renderedCache
+
+func newRenderedCache(size int) *renderedCache {
+	if size <= 0 {
+		return nil
+	}
+	return &renderedCache{max: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// cacheable reports whether the response to r may come from, or go into, the
+// cache: a plain JSON search with no parameter other than keyword.
+func (c *renderedCache) cacheable(w http.ResponseWriter, r *http.Request) bool {
+	if c == nil || negotiatedFormat(w) != formatJSON {
+		return false
+	}
+	query := r.URL.Query()
+	return len(query) == 1 && len(query["keyword"]) == 1
+}
+
+// get returns the body cached for keyword if the dataset, aliases and scrape cache
+// it was built from are still the current ones.
+func (c *renderedCache) get(keyword string) (renderedEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[keyword]
+	if !ok {
+		return renderedEntry{}, false
+	}
+	entry := element.Value.(renderedEntry)
+	if time.Now().After(entry.expires) || entry.dataset != currentDataset() ||
+		entry.aliases != currentAliases() || entry.generation != c.generation.Load() {
+		c.order.Remove(element)
+		delete(c.entries, keyword)
+		return renderedEntry{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry, true
+}
+
+// put caches body for keyword, built from dataset and aliases, evicting the least
+// recently used entry when full.
+func (c *renderedCache) put(keyword string, body []byte, results int, dataset *Dataset, aliases *aliasTable) {
+	entry := renderedEntry{
+		key:        keyword,
+		body:       body,
+		results:    results,
+		dataset:    dataset,
+		aliases:    aliases,
+		generation: c.generation.Load(),
+		expires:    time.Now().Add(renderedTTL),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[keyword]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[keyword] = c.order.PushFront(entry)
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(renderedEntry).key)
+	}
+}
+
+// len returns how many responses the cache holds.
+func (c *renderedCache) len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// invalidate drops every entry, e.g. after the scrape caches are flushed.
+func (c *renderedCache) invalidate() {
+	if c != nil {
+		c.generation.Add(1)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setReusePort fails on platforms without SO_REUSEPORT.
+func setReusePort(network, address string, conn syscall.RawConn) error {
+	return errors.New("-reuse-port is not supported on this platform")
+}
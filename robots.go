@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// robotsTTL is how long a fetched robots.txt is trusted before it is fetched again.
+const robotsTTL = 24 * time.Hour
+
+// errRobotsDisallowed is returned for upstream URLs the site's robots.txt disallows.
+var errRobotsDisallowed = errors.New("the upstream robots.txt disallows fetching this page")
+
+// robotsRule is one Allow or Disallow line.
+type robotsRule struct {
+	pattern string
+	allow   bool
+}
+
+// robotsGroup holds the rules for one set of user agents.
+type robotsGroup struct {
+	agents     []string // lower-case product tokens; "*" matches any agent
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// robotsPolicy is a parsed robots.txt.
+type robotsPolicy struct {
+	groups []robotsGroup
+}
+
+// parseRobots parses a robots.txt file. Unknown directives are ignored.
+func parseRobots(r io.Reader) *robotsPolicy {
+	policy := &robotsPolicy{}
+	var current *robotsGroup
+	inAgents := false // still reading the user-agent lines that open a group
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				policy.groups = append(policy.groups, robotsGroup{})
+				current = &policy.groups[len(policy.groups)-1]
+				inAgents = true
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inAgents = false
+			// An empty Disallow allows everything, so it adds no rule.
+			if current != nil && value != "" {
+				current.rules = append(current.rules, robotsRule{pattern: value, allow: key == "allow"})
+			}
+		case "crawl-delay":
+			inAgents = false
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && current != nil && seconds > 0 {
+				current.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+	return policy
+}
+
+// group returns the group that applies to userAgent: the one whose token appears
+// in it, falling back to the "*" group. It returns nil if neither exists.
+func (p *robotsPolicy) group(userAgent string) *robotsGroup {
+	userAgent = strings.ToLower(userAgent)
+	var fallback *robotsGroup
+	for i, group := range p.groups {
+		for _, agent := range group.agents {
+			if agent == "*" {
+				fallback = &p.groups[i]
+			} else if agent != "" && strings.Contains(userAgent, agent) {
+				return &p.groups[i]
+			}
+		}
+	}
+	return fallback
+}
+
+// allowed reports whether userAgent may fetch path (including its query string).
+// The longest matching rule wins, and Allow wins a tie.
+func (p *robotsPolicy) allowed(userAgent, path string) bool {
+	group := p.group(userAgent)
+	if group == nil {
+		return true
+	}
+	allow, longest := true, -1
+	for _, rule := range group.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+			allow, longest = rule.allow, len(rule.pattern)
+		}
+	}
+	return allow
+}
+
+// crawlDelay returns the Crawl-delay that applies to userAgent, or 0.
+func (p *robotsPolicy) crawlDelay(userAgent string) time.Duration {
+	if group := p.group(userAgent); group != nil {
+		return group.crawlDelay
+	}
+	return 0
+}
+
+// robotsMatch matches a robots.txt path pattern, where '*' matches any run of
+// characters and a trailing '$' anchors the end of the path.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for _, part := range parts[1:] {
+		i := strings.Index(rest, part)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(part):]
+	}
+	if !anchored {
+		return true
+	}
+	// With a wildcard before the anchor, the last part only has to end the path.
+	if len(parts) > 1 {
+		return strings.HasSuffix(path, parts[len(parts)-1])
+	}
+	return rest == ""
+}
+
+// robotsGuard fetches and caches each upstream host's robots.txt, refusing URLs it
+// disallows and spacing requests by its Crawl-delay.
+type robotsGuard struct {
+	failClosed bool // refuse to scrape when robots.txt cannot be fetched
+	client     *http.Client
+
+	mu       sync.Mutex
+	policies map[string]cachedRobots // by scheme://host
+	nextSlot time.Time               // earliest time the next upstream request may start
+}
+
+type cachedRobots struct {
+	policy    *robotsPolicy
+	fetchedAt time.Time
+}
+
+// newRobotsGuard returns a guard fetching robots.txt over transport.
+func newRobotsGuard(failClosed bool, transport http.RoundTripper) *robotsGuard {
+	return &robotsGuard{
+		failClosed: failClosed,
+		client:     &http.Client{Timeout: 10 * time.Second, Transport: transport},
+		policies:   map[string]cachedRobots{},
+	}
+}
+
+// policy returns the robots.txt for the host of target, fetching it when the cached
+// copy is missing or stale. A missing robots.txt (any 4xx) allows everything.
+func (g *robotsGuard) policy(ctx context.Context, target *url.URL, userAgent string) (*robotsPolicy, error) {
+	origin := target.Scheme + "://" + target.Host
+
+	g.mu.Lock()
+	cached, ok := g.policies[origin]
+	g.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < robotsTTL {
+		return cached.policy, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch robots.txt: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var policy *robotsPolicy
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		policy = parseRobots(io.LimitReader(resp.Body, 512<<10))
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		policy = &robotsPolicy{}
+	default:
+		return nil, fmt.Errorf("fetch robots.txt: status %d", resp.StatusCode)
+	}
+
+	g.mu.Lock()
+	g.policies[origin] = cachedRobots{policy: policy, fetchedAt: time.Now()}
+	g.mu.Unlock()
+	return policy, nil
+}
+
+// wait checks targetURL against the robots.txt rules for userAgent and then blocks
+// until the Crawl-delay since the previous upstream request has passed. When
+// robots.txt cannot be fetched, scraping goes ahead unless the guard fails closed.
+func (g *robotsGuard) wait(ctx context.Context, targetURL, userAgent string) error {
+	target, err := url.Parse(targetURL)
+	if err != nil {
+		return err
+	}
+	policy, err := g.policy(ctx, target, userAgent)
+	if err != nil {
+		if g.failClosed {
+			return fmt.Errorf("refusing to scrape without robots.txt: %w", err)
+		}
+		logf(ctx, "Warning: %v; scraping without robots.txt rules", err)
+		return nil
+	}
+	if !policy.allowed(userAgent, target.RequestURI()) {
+		return errRobotsDisallowed
+	}
+
+	delay := policy.crawlDelay(userAgent)
+	if delay <= 0 {
+		return nil
+	}
+	// The slot is only claimed once it has come, so a request that gives up
+	// while waiting doesn't push back the ones after it. The upstream fetch
+	// timeout starts after this wait.
+	for logged := false; ; logged = true {
+		g.mu.Lock()
+		now := time.Now()
+		if !g.nextSlot.After(now) {
+			g.nextSlot = now.Add(delay)
+			g.mu.Unlock()
+			return nil
+		}
+		wait := g.nextSlot.Sub(now)
+		g.mu.Unlock()
+		if !logged {
+			debugf(ctx, "Waiting %s for the robots.txt Crawl-delay before %s", wait.Round(time.Millisecond), targetURL)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// robotsTransport applies a robotsGuard to every upstream request, using the
+// request's own User-Agent.
+type robotsTransport struct {
+	guard *robotsGuard
+	next  http.RoundTripper
+}
+
+func (t robotsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.guard.wait(req.Context(), req.URL.String(), req.Header.Get("User-Agent")); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Checks a validation rule can make. Each passes when the fields it looks at are
+// empty, leaving missing fields to "required" rules.
+const (
+	checkRequired         = "required"           // field is present and not blank
+	checkPattern          = "pattern"            // field's whole value matches pattern
+	checkPostcodeInState  = "postcode_in_state"  // postcode is allocated to state
+	checkSuburbInPostcode = "suburb_in_postcode" // suburb (and state, if given) belongs to postcode; needs a dataset
+	checkNotPOBox         = "not_po_box"         // postcode has street addresses, not just PO Boxes
+)
+
+// Rule severities. Only errors make an address invalid.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// ruleValues is a list of values in a rule's when clause. A single value may be
+// written without the list brackets.
+type ruleValues []string
+
+func (v *ruleValues) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*v = ruleValues{node.Value}
+		return nil
+	}
+	var values []string
+	if err := node.Decode(&values); err != nil {
+		return err
+	}
+	*v = values
+	return nil
+}
+
+// validationRule is one rule of a -validation-rules file.
+type validationRule struct {
+	Name  string `yaml:"name"`
+	Check string `yaml:"check"`
+	// Field and Pattern are the field and regular expression of required and
+	// pattern checks.
+	Field   string `yaml:"field"`
+	Pattern string `yaml:"pattern"`
+	// When limits the rule to addresses whose fields have one of the listed
+	// values, compared case-insensitively; every field listed must match.
+	When     map[string]ruleValues `yaml:"when"`
+	Severity string                `yaml:"severity"`
+	// Message is reported when the rule fails. {field} placeholders are replaced
+	// with the address's values.
+	Message string `yaml:"message"`
+
+	pattern *regexp.Regexp
+}
+
+// validationRules are the rules loaded from -validation-rules; nil when none are.
+var validationRules []validationRule
+
+// loadValidationRules reads a YAML rules file.
+func loadValidationRules(path string) ([]validationRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open validation rules: %w", err)
+	}
+	defer f.Close()
+
+	return readValidationRules(f)
+}
+
+// readValidationRules parses YAML rules, rejecting unknown keys and checks so a
+// typo fails at startup instead of quietly letting every address through.
+func readValidationRules(r io.Reader) ([]validationRule, error) {
+	var file struct {
+		Rules []validationRule `yaml:"rules"`
+	}
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("read validation rules: %w", err)
+	}
+	if len(file.Rules) == 0 {
+		return nil, errors.New("read validation rules: no rules defined")
+	}
+
+	names := map[string]bool{}
+	for i := range file.Rules {
+		rule := &file.Rules[i]
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("validation rule %d (%s): %w", i+1, rule.Name, err)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("validation rule %d: duplicate name %q", i+1, rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return file.Rules, nil
+}
+
+// compile checks a rule's definition, filling in its defaults.
+func (rule *validationRule) compile() error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return errors.New("a name is required")
+	}
+	switch rule.Severity = strings.ToLower(strings.TrimSpace(rule.Severity)); rule.Severity {
+	case "":
+		rule.Severity = severityError
+	case severityError, severityWarning:
+	default:
+		return fmt.Errorf("unknown severity %q: expected error or warning", rule.Severity)
+	}
+
+	switch rule.Check {
+	case checkRequired, checkPattern:
+		if rule.Field == "" {
+			return fmt.Errorf("%s checks need a field", rule.Check)
+		}
+	case checkPostcodeInState, checkSuburbInPostcode, checkNotPOBox:
+		if rule.Field != "" {
+			return fmt.Errorf("%s checks always use the address fields; remove field", rule.Check)
+		}
+	default:
+		return fmt.Errorf("unknown check %q: expected one of %s", rule.Check,
+			strings.Join([]string{checkRequired, checkPattern, checkPostcodeInState, checkSuburbInPostcode, checkNotPOBox}, ", "))
+	}
+	if (rule.Check == checkPattern) != (rule.Pattern != "") {
+		return errors.New("pattern is required by pattern checks and only allowed there")
+	}
+	if rule.Check == checkPattern {
+		pattern, err := regexp.Compile(`^(?:` + rule.Pattern + `)$`)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		rule.pattern = pattern
+	}
+	if rule.Message == "" {
+		rule.Message = defaultRuleMessage(rule)
+	}
+	return nil
+}
+
+// defaultRuleMessage describes a failure of a rule that doesn't set a message.
+func defaultRuleMessage(rule *validationRule) string {
+	switch rule.Check {
+	case checkRequired:
+		return fmt.Sprintf("'%s' is required", rule.Field)
+	case checkPattern:
+		return fmt.Sprintf("'%s' has an invalid format", rule.Field)
+	case checkPostcodeInState:
+		return "Postcode {postcode} is not in {state}"
+	case checkSuburbInPostcode:
+		return "Suburb '{suburb}' is not in postcode {postcode}"
+	default:
+		return "Postcode {postcode} is for PO Boxes only"
+	}
+}
+
+// applies reports whether the rule's when clause matches the address.
+func (rule validationRule) applies(address map[string]string) bool {
+	for field, values := range rule.When {
+		value := strings.TrimSpace(address[field])
+		if !slices.ContainsFunc(values, func(want string) bool { return strings.EqualFold(want, value) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// passes evaluates the rule's check against the address. dataset may be nil
+// unless the rule is a suburb_in_postcode check.
+func (rule validationRule) passes(address map[string]string, dataset *Dataset, aliases *aliasTable) bool {
+	field := func(name string) string { return strings.TrimSpace(address[name]) }
+	postcode, suburb, state := field("postcode"), field("suburb"), strings.ToUpper(field("state"))
+
+	switch rule.Check {
+	case checkRequired:
+		return field(rule.Field) != ""
+	case checkPattern:
+		return field(rule.Field) == "" || rule.pattern.MatchString(field(rule.Field))
+	case checkPostcodeInState:
+		return postcode == "" || state == "" ||
+			fourDigits.MatchString(postcode) && slices.Contains(statesForPostcode(postcodeNumber(postcode)), state)
+	case checkSuburbInPostcode:
+		return postcode == "" || suburb == "" || dataset.validate(postcode, suburb, state, false, aliases).Valid
+	case checkNotPOBox:
+		if postcode == "" {
+			return true
+		}
+		if inPOBoxRange(postcode) {
+			return false
+		}
+		return dataset == nil || !boxesOnlyRows(dataset.RowsForPostcode(postcode))
+	}
+	return true
+}
+
+// message renders the rule's failure message for the address.
+func (rule validationRule) message(address map[string]string) string {
+	return rulePlaceholder.ReplaceAllStringFunc(rule.Message, func(placeholder string) string {
+		return strings.TrimSpace(address[placeholder[1:len(placeholder)-1]])
+	})
+}
+
+// rulePlaceholder matches a {field} placeholder in a rule message.
+var rulePlaceholder = regexp.MustCompile(`\{[A-Za-z0-9_]+\}`)
+
+// RuleViolation is a rule an address failed.
+type RuleViolation struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// RuleValidationResult is the response of POST /validate/rules.
+type RuleValidationResult struct {
+	// Valid is false when the address failed any rule of severity error.
+	Valid      bool            `json:"valid"`
+	Violations []RuleViolation `json:"violations"`
+}
+
+// evaluateRules checks an address against every rule that applies to it.
+func evaluateRules(rules []validationRule, address map[string]string, dataset *Dataset, aliases *aliasTable) RuleValidationResult {
+	result := RuleValidationResult{Valid: true, Violations: []RuleViolation{}}
+	for _, rule := range rules {
+		if !rule.applies(address) || rule.passes(address, dataset, aliases) {
+			continue
+		}
+		result.Violations = append(result.Violations, RuleViolation{Rule: rule.Name, Severity: rule.Severity, Message: rule.message(address)})
+		if rule.Severity == severityError {
+			result.Valid = false
+		}
+	}
+	return result
+}
+
+// rulesNeedDataset reports whether any of the rules looks suburbs up in the dataset.
+func rulesNeedDataset(rules []validationRule) bool {
+	return slices.ContainsFunc(rules, func(rule validationRule) bool { return rule.Check == checkSuburbInPostcode })
+}
+
+// ruleValidateHandler handles POST /validate/rules with an address as a JSON
+// object of strings, like {"postcode": "3001", "suburb": "Melbourne", "state":
+// "VIC", "address_type": "delivery"}, checking it against the -validation-rules
+// file. Fields other than postcode, suburb and state are only seen by the rules'
+// when clauses, required and pattern checks. Like GET /validate it answers 200
+// with "valid" set.
+func ruleValidateHandler(w http.ResponseWriter, r *http.Request) {
+	if validationRules == nil {
+		writeError(w, http.StatusServiceUnavailable, codeDatasetUnavailable, "This endpoint requires validation rules; start the server with -validation-rules")
+		return
+	}
+	var address map[string]string
+	if !decodeJSONBody(w, r, &address) {
+		return
+	}
+	dataset := currentDataset()
+	if rulesNeedDataset(validationRules) {
+		if dataset = requireDataset(w); dataset == nil {
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, evaluateRules(validationRules, address, dataset, requestAliases(r)))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	errScrapeQueueFull    = errors.New("too many searches are already waiting on this keyword")
+	errScrapeQueueTimeout = errors.New("timed out waiting for the in-flight scrape of this keyword")
+)
+
+// scrapeQueue is set in main; nil means scrapes of the same keyword aren't serialised.
+var scrapeQueue *keywordQueue
+
+// keywordQueue serialises upstream scrapes per keyword. One scrape of a keyword
+// runs at a time; up to depth identical searches wait behind it, each for at most
+// wait, and usually find its results in the cache once they get their turn.
+type keywordQueue struct {
+	depth int
+	wait  time.Duration
+
+	mu    sync.Mutex
+	slots map[string]*keywordSlot
+}
+
+// keywordSlot is the queue for one keyword. turn holds a token while a scrape
+// runs; queued counts that scrape and the searches waiting behind it.
+type keywordSlot struct {
+	turn   chan struct{}
+	queued int
+}
+
+func newKeywordQueue(depth int, wait time.Duration) *keywordQueue {
+	return &keywordQueue{depth: depth, wait: wait, slots: map[string]*keywordSlot{}}
+}
+
+// acquire waits for the turn to scrape key and returns the function that gives
+// it up. It fails straight away when depth searches are already waiting, and
+// after the queue's wait or when ctx is done.
+func (q *keywordQueue) acquire(ctx context.Context, key string) (func(), error) {
+	if q == nil {
+		return func() {}, nil
+	}
+
+	q.mu.Lock()
+	slot := q.slots[key]
+	if slot == nil {
+		slot = &keywordSlot{turn: make(chan struct{}, 1)}
+		q.slots[key] = slot
+	}
+	if slot.queued > q.depth {
+		q.mu.Unlock()
+		return nil, errScrapeQueueFull
+	}
+	slot.queued++
+	q.mu.Unlock()
+
+	leave := func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if slot.queued--; slot.queued == 0 {
+			delete(q.slots, key)
+		}
+	}
+
+	timer := time.NewTimer(q.wait)
+	defer timer.Stop()
+	select {
+	case slot.turn <- struct{}{}:
+		return func() {
+			<-slot.turn
+			leave()
+		}, nil
+	case <-ctx.Done():
+		leave()
+		return nil, ctx.Err()
+	case <-timer.C:
+		leave()
+		return nil, errScrapeQueueTimeout
+	}
+}
+
+// waitForScrape takes the turn to scrape key for a search. When the queue is full
+// or the wait runs out it writes a 503 with Retry-After and returns false.
+func waitForScrape(w http.ResponseWriter, r *http.Request, key string) (func(), bool) {
+	release, err := scrapeQueue.acquire(r.Context(), key)
+	switch {
+	case err == nil:
+		return release, true
+	case errors.Is(err, errScrapeQueueFull), errors.Is(err, errScrapeQueueTimeout):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(scrapeQueue.wait.Seconds()))))
+		writeError(w, http.StatusServiceUnavailable, codeScrapeQueueFull, "Too many searches for this keyword are waiting on the upstream; please retry later")
+	case clientTimedOut(r.Context()):
+		writeClientTimeout(w)
+	default:
+		// The client has gone or the request timed out, which the timeout
+		// middleware answers.
+	}
+	return nil, false
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Snapshots are stored under a location like s3://bucket/prefix as
+// prefix/snapshots/<timestamp>.csv, with prefix/LATEST holding the newest one's key.
+const latestSnapshotObject = "LATEST"
+
+// snapshotTimeFormat names each snapshot after the time it was pushed.
+const snapshotTimeFormat = "20060102T150405Z"
+
+// snapshotLocation is a parsed s3://bucket/prefix URL.
+type snapshotLocation struct {
+	Bucket string
+	Prefix string
+}
+
+func parseSnapshotLocation(raw string) (snapshotLocation, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return snapshotLocation{}, fmt.Errorf("invalid snapshot location %q: expected s3://bucket/prefix", raw)
+	}
+	return snapshotLocation{Bucket: u.Host, Prefix: strings.Trim(u.Path, "/")}, nil
+}
+
+func (l snapshotLocation) key(name string) string {
+	return path.Join(l.Prefix, name)
+}
+
+// pushSnapshot uploads dataset CSV data as a new snapshot and marks it as the latest.
+// The data is parsed first so a malformed dataset is never published.
+func pushSnapshot(ctx context.Context, client *s3Client, loc snapshotLocation, data []byte) (string, error) {
+	dataset, err := readDataset(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("refusing to push invalid dataset: %w", err)
+	}
+	if len(dataset.Rows) == 0 {
+		return "", errors.New("refusing to push an empty dataset")
+	}
+
+	key := loc.key("snapshots/" + time.Now().UTC().Format(snapshotTimeFormat) + ".csv")
+	if err := client.put(ctx, loc.Bucket, key, data); err != nil {
+		return "", err
+	}
+	// Only move the pointer once the snapshot itself is fully uploaded.
+	if err := client.put(ctx, loc.Bucket, loc.key(latestSnapshotObject), []byte(key)); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// fetchLatestSnapshot downloads the newest snapshot's raw CSV and its object key.
+func fetchLatestSnapshot(ctx context.Context, client *s3Client, loc snapshotLocation) ([]byte, string, error) {
+	pointer, err := client.get(ctx, loc.Bucket, loc.key(latestSnapshotObject))
+	if err != nil {
+		return nil, "", fmt.Errorf("read latest snapshot pointer: %w", err)
+	}
+	key := strings.TrimSpace(string(pointer))
+	data, err := client.get(ctx, loc.Bucket, key)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, key, nil
+}
+
+// loadSnapshotDataset fetches and parses the latest snapshot, for use at server startup.
+func loadSnapshotDataset(ctx context.Context, rawLocation string) (*Dataset, string, error) {
+	loc, err := parseSnapshotLocation(rawLocation)
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := newS3ClientFromEnv()
+	if err != nil {
+		return nil, "", err
+	}
+	data, key, err := fetchLatestSnapshot(ctx, client, loc)
+	if err != nil {
+		return nil, "", err
+	}
+	dataset, err := readDataset(bytes.NewReader(data))
+	if err != nil {
+		return nil, key, err
+	}
+	if pushed, err := time.Parse(snapshotTimeFormat, strings.TrimSuffix(path.Base(key), ".csv")); err == nil {
+		dataset.sourceTime = pushed
+	}
+	return dataset, key, nil
+}
+
+// publishSnapshot pushes rows as the latest snapshot at rawLocation.
+func publishSnapshot(ctx context.Context, rawLocation string, rows []PostcodeResult) (string, error) {
+	loc, err := parseSnapshotLocation(rawLocation)
+	if err != nil {
+		return "", err
+	}
+	client, err := newS3ClientFromEnv()
+	if err != nil {
+		return "", err
+	}
+	var data bytes.Buffer
+	if err := writeDatasetCSV(&data, rows); err != nil {
+		return "", err
+	}
+	return pushSnapshot(ctx, client, loc, data.Bytes())
+}
+
+// runSnapshot implements the "snapshot push" and "snapshot pull" subcommands.
+func runSnapshot(args []string) error {
+	usage := errors.New("usage: snapshot push -to s3://bucket/prefix FILE | snapshot pull -from s3://bucket/prefix -o FILE")
+	if len(args) == 0 {
+		return usage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	switch args[0] {
+	case "push":
+		fs := flag.NewFlagSet("snapshot push", flag.ExitOnError)
+		to := fs.String("to", "", "Snapshot location, e.g. s3://bucket/prefix")
+		fs.Parse(args[1:])
+		if *to == "" || fs.NArg() != 1 {
+			return usage
+		}
+
+		loc, err := parseSnapshotLocation(*to)
+		if err != nil {
+			return err
+		}
+		client, err := newS3ClientFromEnv()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		key, err := pushSnapshot(ctx, client, loc, data)
+		if err != nil {
+			return err
+		}
+		log.Printf("Pushed snapshot s3://%s/%s", loc.Bucket, key)
+		return nil
+
+	case "pull":
+		fs := flag.NewFlagSet("snapshot pull", flag.ExitOnError)
+		from := fs.String("from", "", "Snapshot location, e.g. s3://bucket/prefix")
+		out := fs.String("o", "postcodes.csv", "File to write the snapshot to")
+		fs.Parse(args[1:])
+		if *from == "" {
+			return usage
+		}
+
+		loc, err := parseSnapshotLocation(*from)
+		if err != nil {
+			return err
+		}
+		client, err := newS3ClientFromEnv()
+		if err != nil {
+			return err
+		}
+		data, key, err := fetchLatestSnapshot(ctx, client, loc)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*out, data, 0o644); err != nil {
+			return err
+		}
+		log.Printf("Pulled snapshot s3://%s/%s to %s", loc.Bucket, key, *out)
+		return nil
+
+	default:
+		return usage
+	}
+}
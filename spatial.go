@@ -0,0 +1,360 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+const (
+	// earthRadiusKM is the mean radius of the Earth.
+	earthRadiusKM = 6371.0088
+
+	// maxRadiusKM caps GET /geo/within, which otherwise could list the whole dataset.
+	maxRadiusKM = 100
+
+	defaultWithinResults = 50
+	maxWithinResults     = 500
+	maxReverseResults    = 20
+)
+
+// rtreeNodeSize is how many entries each node of the spatial index holds.
+const rtreeNodeSize = 16
+
+// point3 is a position on the unit sphere. Indexing points by their 3D position
+// rather than by latitude and longitude makes the straight-line distance to a box
+// an exact lower bound on the great-circle distance to anything inside it.
+type point3 [3]float64
+
+func unitVector(lat, lon float64) point3 {
+	phi, lambda := lat*math.Pi/180, lon*math.Pi/180
+	return point3{math.Cos(phi) * math.Cos(lambda), math.Cos(phi) * math.Sin(lambda), math.Sin(phi)}
+}
+
+// chordToKM converts a straight-line distance between two points on the unit
+// sphere into the great-circle distance between them on the Earth.
+func chordToKM(chord float64) float64 {
+	return 2 * earthRadiusKM * math.Asin(min(chord/2, 1))
+}
+
+// kmToChord is the inverse of chordToKM.
+func kmToChord(km float64) float64 {
+	return 2 * math.Sin(min(km/(2*earthRadiusKM), math.Pi/2))
+}
+
+// box3 is an axis-aligned bounding box around points on the unit sphere.
+type box3 struct {
+	min, max point3
+}
+
+func (b box3) union(o box3) box3 {
+	for i := range b.min {
+		b.min[i] = min(b.min[i], o.min[i])
+		b.max[i] = max(b.max[i], o.max[i])
+	}
+	return b
+}
+
+// distance returns the straight-line distance from p to the nearest point of the
+// box, or 0 when p lies inside it.
+func (b box3) distance(p point3) float64 {
+	var sum float64
+	for i, v := range p {
+		d := max(b.min[i]-v, 0, v-b.max[i])
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
+
+// center returns the longitude and height of the box's middle, for ordering
+// nodes while the tree is packed.
+func (b box3) center() (float64, float64) {
+	var c point3
+	for i := range c {
+		c[i] = (b.min[i] + b.max[i]) / 2
+	}
+	return math.Atan2(c[1], c[0]), c[2]
+}
+
+// rtreeNode is a node of the spatial index: either a branch over child nodes or,
+// when children is nil, a single dataset row.
+type rtreeNode struct {
+	box      box3
+	row      int
+	children []*rtreeNode
+}
+
+// spatialIndex is an R-tree over the dataset's locality coordinates, answering
+// radius and nearest-locality queries by descending only into the boxes that can
+// hold a match instead of measuring the distance to every row.
+type spatialIndex struct {
+	root *rtreeNode
+}
+
+// buildSpatialIndex bulk-loads an R-tree of the rows with coordinates using
+// Sort-Tile-Recursive packing. It returns nil when no row has coordinates.
+func buildSpatialIndex(rows []PostcodeResult) *spatialIndex {
+	var level []*rtreeNode
+	for i, row := range rows {
+		if !row.hasLocation() {
+			continue
+		}
+		p := unitVector(row.Latitude, row.Longitude)
+		level = append(level, &rtreeNode{box: box3{min: p, max: p}, row: i})
+	}
+	if len(level) == 0 {
+		return nil
+	}
+	for len(level) > 1 || level[0].children == nil {
+		level = packLevel(level)
+	}
+	return &spatialIndex{root: level[0]}
+}
+
+// packLevel groups nodes into parents of up to rtreeNodeSize: it cuts the nodes
+// into vertical strips by longitude, sorts each strip north to south and fills
+// parents in that order, so each parent covers a compact tile.
+func packLevel(nodes []*rtreeNode) []*rtreeNode {
+	parents := (len(nodes) + rtreeNodeSize - 1) / rtreeNodeSize
+	perStrip := int(math.Ceil(math.Sqrt(float64(parents)))) * rtreeNodeSize
+
+	sort.Slice(nodes, func(i, j int) bool {
+		a, _ := nodes[i].box.center()
+		b, _ := nodes[j].box.center()
+		return a < b
+	})
+	var packed []*rtreeNode
+	for start := 0; start < len(nodes); start += perStrip {
+		strip := nodes[start:min(start+perStrip, len(nodes))]
+		sort.Slice(strip, func(i, j int) bool {
+			_, a := strip[i].box.center()
+			_, b := strip[j].box.center()
+			return a > b
+		})
+		for first := 0; first < len(strip); first += rtreeNodeSize {
+			group := strip[first:min(first+rtreeNodeSize, len(strip))]
+			parent := &rtreeNode{box: group[0].box, children: append([]*rtreeNode(nil), group...)}
+			for _, child := range group[1:] {
+				parent.box = parent.box.union(child.box)
+			}
+			packed = append(packed, parent)
+		}
+	}
+	return packed
+}
+
+// spatialHit is a row found by a spatial query and its distance in kilometres.
+type spatialHit struct {
+	row int
+	km  float64
+}
+
+// within returns the rows no more than radiusKM from the point, nearest first.
+func (s *spatialIndex) within(lat, lon, radiusKM float64) []spatialHit {
+	p, limit := unitVector(lat, lon), kmToChord(radiusKM)
+	var hits []spatialHit
+	var visit func(*rtreeNode)
+	visit = func(n *rtreeNode) {
+		if n.box.distance(p) > limit {
+			return
+		}
+		if n.children == nil {
+			hits = append(hits, spatialHit{row: n.row, km: chordToKM(n.box.distance(p))})
+			return
+		}
+		for _, child := range n.children {
+			visit(child)
+		}
+	}
+	visit(s.root)
+	sortHits(hits)
+	return hits
+}
+
+// nearest returns the count rows closest to the point, nearest first. Nodes are
+// expanded in order of their distance from the point, so the search stops as soon
+// as count rows are closer than anything left unexpanded.
+func (s *spatialIndex) nearest(lat, lon float64, count int) []spatialHit {
+	p := unitVector(lat, lon)
+	queue := &nodeQueue{{node: s.root, distance: s.root.box.distance(p)}}
+	var hits []spatialHit
+	for queue.Len() > 0 && len(hits) < count {
+		next := heap.Pop(queue).(queuedNode)
+		if next.node.children == nil {
+			hits = append(hits, spatialHit{row: next.node.row, km: chordToKM(next.distance)})
+			continue
+		}
+		for _, child := range next.node.children {
+			heap.Push(queue, queuedNode{node: child, distance: child.box.distance(p)})
+		}
+	}
+	return hits
+}
+
+// sortHits orders hits nearest first, breaking ties by dataset order.
+func sortHits(hits []spatialHit) {
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].km != hits[j].km {
+			return hits[i].km < hits[j].km
+		}
+		return hits[i].row < hits[j].row
+	})
+}
+
+// nodeQueue is a min-heap of nodes by distance, for the nearest search.
+type queuedNode struct {
+	node     *rtreeNode
+	distance float64
+}
+
+type nodeQueue []queuedNode
+
+func (q nodeQueue) Len() int { return len(q) }
+func (q nodeQueue) Less(i, j int) bool {
+	if q[i].distance != q[j].distance {
+		return q[i].distance < q[j].distance
+	}
+	return q[i].node.row < q[j].node.row
+}
+func (q nodeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *nodeQueue) Push(x any)   { *q = append(*q, x.(queuedNode)) }
+func (q *nodeQueue) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
+
+// hasLocation reports whether the row has coordinates.
+func (r PostcodeResult) hasLocation() bool {
+	return r.Latitude != 0 || r.Longitude != 0
+}
+
+// GeoResult is a locality found by a geo query, with its distance from the point.
+type GeoResult struct {
+	PostcodeResult
+	DistanceKM float64 `json:"distance_km"`
+}
+
+// geoResults turns hits into results, rounding distances to metres.
+func (d *Dataset) geoResults(hits []spatialHit) []GeoResult {
+	results := make([]GeoResult, len(hits))
+	for i, hit := range hits {
+		results[i] = GeoResult{PostcodeResult: d.Rows[hit.row], DistanceKM: math.Round(hit.km*1000) / 1000}
+	}
+	return results
+}
+
+// Within returns up to limit localities within radiusKM of the point, nearest first.
+func (d *Dataset) Within(lat, lon, radiusKM float64, limit int) []GeoResult {
+	hits := d.locations.within(lat, lon, radiusKM)
+	return d.geoResults(hits[:min(len(hits), limit)])
+}
+
+// Nearest returns the count localities whose centres are closest to the point.
+func (d *Dataset) Nearest(lat, lon float64, count int) []GeoResult {
+	return d.geoResults(d.locations.nearest(lat, lon, count))
+}
+
+// parseFloatParam reads a required numeric query parameter within [lo, hi].
+func parseFloatParam(query url.Values, name string, lo, hi float64) (float64, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return 0, fmt.Errorf("Missing '%s' parameter", name)
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(value) || value < lo || value > hi {
+		return 0, fmt.Errorf("Invalid '%s' parameter: expected a number between %g and %g", name, lo, hi)
+	}
+	return value, nil
+}
+
+// parseLimitParam reads an optional result count between 1 and most.
+func parseLimitParam(query url.Values, fallback, most int) (int, error) {
+	raw := query.Get("limit")
+	if raw == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > most {
+		return 0, fmt.Errorf("Invalid 'limit' parameter: expected an integer between 1 and %d", most)
+	}
+	return n, nil
+}
+
+// requireLocations returns the loaded dataset when it has coordinates, or writes a
+// 503 response and returns nil.
+func requireLocations(w http.ResponseWriter) *Dataset {
+	dataset := requireDataset(w)
+	if dataset != nil && dataset.locations == nil {
+		writeError(w, http.StatusServiceUnavailable, codeDatasetUnavailable, "This endpoint requires a dataset with latitude and longitude columns")
+		return nil
+	}
+	return dataset
+}
+
+// parsePoint reads the lat and lon query parameters.
+func parsePoint(query url.Values) (float64, float64, error) {
+	lat, err := parseFloatParam(query, "lat", -90, 90)
+	if err != nil {
+		return 0, 0, err
+	}
+	lon, err := parseFloatParam(query, "lon", -180, 180)
+	if err != nil {
+		return 0, 0, err
+	}
+	return lat, lon, nil
+}
+
+// geoWithinHandler handles GET /geo/within?lat=-37.8136&lon=144.9631&radius_km=5,
+// listing the localities whose centres lie within the radius, nearest first.
+func geoWithinHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	lat, lon, err := parsePoint(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+	radius, err := parseFloatParam(query, "radius_km", 0, maxRadiusKM)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+	limit, err := parseLimitParam(query, defaultWithinResults, maxWithinResults)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+
+	dataset := requireLocations(w)
+	if dataset == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"results": dataset.Within(lat, lon, radius, limit)})
+}
+
+// geoReverseHandler handles GET /geo/reverse?lat=-37.8136&lon=144.9631&limit=1,
+// returning the localities whose centres are nearest the point.
+func geoReverseHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	lat, lon, err := parsePoint(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+	limit, err := parseLimitParam(query, 1, maxReverseResults)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+
+	dataset := requireLocations(w)
+	if dataset == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"results": dataset.Nearest(lat, lon, limit)})
+}
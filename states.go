@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// postcodeRange is an inclusive range of postcodes allocated to a state.
+type postcodeRange struct {
+	From, To int
+}
+
+// stateRanges lists the postcode ranges allocated to each state and territory,
+// including the ranges reserved for PO Boxes and large-volume receivers.
+var stateRanges = map[string][]postcodeRange{
+	"ACT": {{200, 299}, {2600, 2618}, {2900, 2920}},
+	"NSW": {{1000, 1999}, {2000, 2599}, {2619, 2899}, {2921, 2999}},
+	"NT":  {{800, 899}, {900, 999}},
+	"QLD": {{4000, 4999}, {9000, 9999}},
+	"SA":  {{5000, 5799}, {5800, 5999}},
+	"TAS": {{7000, 7799}, {7800, 7999}},
+	"VIC": {{3000, 3999}, {8000, 8999}},
+	"WA":  {{6000, 6797}, {6800, 6999}},
+}
+
+// statesForPostcode returns the states whose allocated ranges include postcode n,
+// in alphabetical order. Ranges don't overlap, so there is at most one.
+func statesForPostcode(n int) []string {
+	states := []string{}
+	for state, ranges := range stateRanges {
+		for _, r := range ranges {
+			if n >= r.From && n <= r.To {
+				states = append(states, state)
+				break
+			}
+		}
+	}
+	sort.Strings(states)
+	return states
+}
+
+// formatPostcode renders a postcode number with its leading zeros, e.g. 800 -> "0800".
+func formatPostcode(n int) string {
+	return fmt.Sprintf("%04d", n)
+}
+
+// allPostcodes returns every allocated postcode in ascending order.
+func allPostcodes() []string {
+	allocated := make([]bool, 10000)
+	for _, ranges := range stateRanges {
+		for _, r := range ranges {
+			for n := r.From; n <= r.To; n++ {
+				allocated[n] = true
+			}
+		}
+	}
+
+	codes := []string{}
+	for n, ok := range allocated {
+		if ok {
+			codes = append(codes, formatPostcode(n))
+		}
+	}
+	return codes
+}
+
+// PostcodeState is the response of GET /postcode/{code}/state.
+type PostcodeState struct {
+	Postcode string `json:"postcode"`
+	State    string `json:"state"`
+	// BoxesOnly is set for postcodes in the ranges reserved for PO Boxes and large
+	// volume receivers.
+	BoxesOnly bool `json:"boxes_only"`
+}
+
+// postcodeStateHandler handles GET /postcode/{code}/state, naming the state a
+// postcode is allocated to from the built-in range table alone. It needs no
+// dataset or upstream, so it answers even before any data is loaded. A postcode in
+// an allocated range need not be in use.
+func postcodeStateHandler(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimSpace(r.PathValue("code"))
+	if !fourDigits.MatchString(code) {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid postcode: expected four digits")
+		return
+	}
+	states := statesForPostcode(postcodeNumber(code))
+	if len(states) == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Postcode %s is not allocated to any state", code))
+		return
+	}
+	writeJSON(w, http.StatusOK, PostcodeState{Postcode: code, State: states[0], BoxesOnly: inPOBoxRange(code)})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upstreamTracker remembers the outcome of recent upstream scrapes for GET /status.
+type upstreamTracker struct {
+	mu                  sync.Mutex
+	lastOutcome         int
+	lastAttempt         time.Time
+	lastSuccess         time.Time
+	lastError           string
+	consecutiveFailures int
+}
+
+var upstreamHealth upstreamTracker
+
+// recordScrape counts one scrape outcome towards the failure alerts and the
+// status page. err is why a failed scrape failed.
+func recordScrape(outcome int, err error) {
+	scrapeAlerts.record(outcome)
+	upstreamHealth.record(outcome, err)
+}
+
+func (t *upstreamTracker) record(outcome int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastOutcome = outcome
+	t.lastAttempt = time.Now().UTC()
+	switch outcome {
+	case scrapeOK:
+		t.lastSuccess = t.lastAttempt
+		t.consecutiveFailures = 0
+		return
+	case scrapeParseFailure:
+		t.lastError = "results table not found on the page"
+	default:
+		t.lastError = err.Error()
+	}
+	t.consecutiveFailures++
+}
+
+// upstreamStatus is the upstream section of GET /status.
+type upstreamStatus struct {
+	URL string `json:"url"`
+	// State is "unknown" before the first scrape, then "up", "degraded" (the last
+	// page had no results table, nor the upstream's "no results" message) or
+	// "down" (the last request failed).
+	State               string     `json:"state"`
+	LastAttempt         *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	// PacingGap is how far apart upstream requests are spaced now; BackedOff is
+	// whether the pacer has widened it because the upstream slowed or throttled.
+	PacingGap string `json:"pacing_gap"`
+	BackedOff bool   `json:"backed_off"`
+}
+
+func (t *upstreamTracker) status() upstreamStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := upstreamStatus{
+		URL:                 baseURL,
+		State:               "unknown",
+		LastError:           t.lastError,
+		ConsecutiveFailures: t.consecutiveFailures,
+		PacingGap:           upstreamPacer.currentGap().String(),
+		BackedOff:           upstreamPacer != nil && upstreamPacer.currentGap() > upstreamPacer.min,
+	}
+	if !t.lastAttempt.IsZero() {
+		status.LastAttempt = &t.lastAttempt
+		status.State = map[int]string{scrapeOK: "up", scrapeParseFailure: "degraded", scrapeFailed: "down"}[t.lastOutcome]
+	}
+	if !t.lastSuccess.IsZero() {
+		status.LastSuccess = &t.lastSuccess
+	}
+	return status
+}
+
+// datasetStatus is the dataset section of GET /status.
+type datasetStatus struct {
+	Loaded bool `json:"loaded"`
+	Rows   int  `json:"rows"`
+	// Version is a fingerprint of the rows, the same on every replica serving
+	// the same data.
+	Version     string     `json:"version,omitempty"`
+	LastChanged *time.Time `json:"last_changed,omitempty"`
+}
+
+// datasetVersions remembers the fingerprint of the last dataset asked about, so
+// the status page doesn't hash every row on each request.
+var datasetVersions struct {
+	sync.Mutex
+	dataset *Dataset
+	version string
+}
+
+// datasetVersion returns a short fingerprint of the dataset's rows.
+func datasetVersion(dataset *Dataset) string {
+	datasetVersions.Lock()
+	defer datasetVersions.Unlock()
+	if datasetVersions.dataset != dataset {
+		datasetVersions.dataset, datasetVersions.version = dataset, versionOfRows(dataset.Rows)
+	}
+	return datasetVersions.version
+}
+
+// cacheStatus describes one cache on GET /status.
+type cacheStatus struct {
+	Entries  int     `json:"entries"`
+	Capacity int     `json:"capacity,omitempty"`
+	Hits     uint64  `json:"hits,omitempty"`
+	Misses   uint64  `json:"misses,omitempty"`
+	HitRate  float64 `json:"hit_rate,omitempty"`
+}
+
+func resultCacheStatus[T any](c *resultCache[T]) cacheStatus {
+	entries, err := c.entries()
+	if err != nil {
+		log.Printf("Warning: failed to count %s cache entries: %v", c.bucket, err)
+	}
+	status := cacheStatus{Entries: entries, Hits: c.hits.Load(), Misses: c.misses.Load()}
+	if lookups := status.Hits + status.Misses; lookups > 0 {
+		status.HitRate = float64(status.Hits) / float64(lookups)
+	}
+	return status
+}
+
+// serverStatus is the body of GET /status.
+type serverStatus struct {
+	Status   string                 `json:"status"` // "ok", "degraded", "failing" or "draining"
+	Mode     string                 `json:"mode"`   // "dataset" or "scrape"
+	ReadOnly bool                   `json:"read_only"`
+	Upstream upstreamStatus         `json:"upstream"`
+	Dataset  datasetStatus          `json:"dataset"`
+	Caches   map[string]cacheStatus `json:"caches"`
+	Canary   *canaryStatus          `json:"canary,omitempty"`
+	Checked  time.Time              `json:"checked_at"`
+}
+
+func currentStatus() serverStatus {
+	status := serverStatus{
+		Status:   "ok",
+		Mode:     "scrape",
+		ReadOnly: readOnly,
+		Upstream: upstreamHealth.status(),
+		Caches: map[string]cacheStatus{
+			"results":  resultCacheStatus(scrapeCache),
+			"nearby":   resultCacheStatus(nearbyCache),
+			"rendered": {Entries: renderedResponses.len()},
+		},
+		Canary:  canary.status(),
+		Checked: time.Now().UTC(),
+	}
+	if renderedResponses != nil {
+		rendered := status.Caches["rendered"]
+		rendered.Capacity = renderedResponses.max
+		status.Caches["rendered"] = rendered
+	}
+	if dataset := currentDataset(); dataset != nil {
+		status.Mode = "dataset"
+		status.Dataset = datasetStatus{Loaded: true, Rows: len(dataset.Rows), Version: datasetVersion(dataset)}
+		if changes := datasetHistory.changes(); len(changes) > 0 {
+			status.Dataset.LastChanged = &changes[0].Time
+		}
+	}
+	switch {
+	case draining.Load():
+		status.Status = "draining"
+	case canary.failing():
+		status.Status = "failing"
+	case status.Mode == "scrape" && (status.Upstream.State == "down" || status.Upstream.State == "degraded"):
+		status.Status = "degraded"
+	}
+	return status
+}
+
+// statusPage renders GET /status for browsers.
+var statusPage = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Postcode API status: {{.Status}}</title>
+<style>body{font-family:sans-serif;max-width:40em;margin:2em auto}th{text-align:left;padding-right:2em}</style></head>
+<body>
+<h1>Postcode API: {{.Status}}</h1>
+<h2>Upstream</h2>
+<table>
+<tr><th>URL</th><td>{{.Upstream.URL}}</td></tr>
+<tr><th>State</th><td>{{.Upstream.State}}</td></tr>
+<tr><th>Last success</th><td>{{with .Upstream.LastSuccess}}{{.Format "2006-01-02 15:04:05 MST"}}{{else}}never{{end}}</td></tr>
+<tr><th>Last error</th><td>{{or .Upstream.LastError "none"}}</td></tr>
+<tr><th>Consecutive failures</th><td>{{.Upstream.ConsecutiveFailures}}</td></tr>
+<tr><th>Request spacing</th><td>{{.Upstream.PacingGap}}{{if .Upstream.BackedOff}} (backed off){{end}}</td></tr>
+</table>
+<h2>Dataset</h2>
+{{if .Dataset.Loaded}}<table>
+<tr><th>Rows</th><td>{{.Dataset.Rows}}</td></tr>
+<tr><th>Version</th><td>{{.Dataset.Version}}</td></tr>
+<tr><th>Last changed</th><td>{{with .Dataset.LastChanged}}{{.Format "2006-01-02 15:04:05 MST"}}{{else}}not since history began{{end}}</td></tr>
+</table>{{else}}<p>No local dataset; searches are scraped.</p>{{end}}
+<h2>Caches</h2>
+<table>
+<tr><th>Cache</th><th>Entries</th><th>Hit rate</th></tr>
+{{range $name, $cache := .Caches}}<tr><td>{{$name}}</td><td>{{$cache.Entries}}{{with $cache.Capacity}} / {{.}}{{end}}</td><td>{{if or $cache.Hits $cache.Misses}}{{printf "%.2f" $cache.HitRate}}{{else}}-{{end}}</td></tr>
+{{end}}</table>
+{{with .Canary}}<h2>Canary</h2>
+<p>{{len .Failures}} of {{.Checks}} checks failing{{with .LastRun}}, last run {{.Format "2006-01-02 15:04:05 MST"}}{{end}}.</p>
+{{if .Failures}}<ul>
+{{range .Failures}}<li>{{.Keyword}} → {{.Postcode}} {{.Suburb}} {{.State}}: {{.Reason}}</li>
+{{end}}</ul>{{end}}{{end}}
+<p>Checked {{.Checked.Format "2006-01-02 15:04:05 MST"}}{{if .ReadOnly}}; read-only replica{{end}}.</p>
+</body>
+</html>
+`))
+
+// statusHandler handles GET /status, a summary of upstream health, the dataset
+// and the caches. Browsers get an HTML page; everything else gets JSON. Unlike
+// /healthz it always answers 200, as it describes the server rather than gating
+// traffic to it.
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	status := currentStatus()
+	w.Header().Set("Cache-Control", "no-store")
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusPage.Execute(w, status); err != nil {
+		log.Printf("Warning: failed to render the status page: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store is the storage interface for data that outlives a single request, such as
+// cached scrape results and dataset snapshots. Keys are grouped into named buckets.
+type Store interface {
+	// Get returns the value stored under key, and false if there is none.
+	Get(bucket, key string) ([]byte, bool, error)
+	// Put stores value under key, replacing any previous value.
+	Put(bucket, key string, value []byte) error
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(bucket, key string) error
+	// ForEach calls fn for every key in the bucket, in key order.
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+	// ForEachFrom is ForEach starting at the first key at or after start. value
+	// is only valid until fn returns.
+	ForEachFrom(bucket, start string, fn func(key string, value []byte) error) error
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// openStore opens the store described by spec: "memory" (the default) keeps
+// everything in process, and "bolt:PATH" uses an embedded bbolt database file.
+func openStore(spec string) (Store, error) {
+	switch {
+	case spec == "" || spec == "memory":
+		return newMemoryStore(), nil
+	case strings.HasPrefix(spec, "bolt:"):
+		return openBoltStore(strings.TrimPrefix(spec, "bolt:"))
+	default:
+		return nil, fmt.Errorf("unknown store %q: expected 'memory' or 'bolt:PATH'", spec)
+	}
+}
+
+// memoryStore is a Store backed by maps. Its contents are lost on restart.
+type memoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{buckets: make(map[string]map[string][]byte)}
+}
+
+func (s *memoryStore) Get(bucket, key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.buckets[bucket][key]
+	return value, ok, nil
+}
+
+func (s *memoryStore) Put(bucket, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string][]byte)
+	}
+	// Copy so callers can reuse their buffer, matching bbolt's semantics.
+	s.buckets[bucket][key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (s *memoryStore) Delete(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.buckets[bucket], key)
+	return nil
+}
+
+func (s *memoryStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return s.ForEachFrom(bucket, "", fn)
+}
+
+func (s *memoryStore) ForEachFrom(bucket, start string, fn func(key string, value []byte) error) error {
+	// Snapshot the bucket so fn can call back into the store without deadlocking.
+	s.mu.RLock()
+	entries := make(map[string][]byte, len(s.buckets[bucket]))
+	for key, value := range s.buckets[bucket] {
+		if key >= start {
+			entries[key] = value
+		}
+	}
+	s.mu.RUnlock()
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := fn(key, entries[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build wasm
+
+package main
+
+import "errors"
+
+// openBoltStore fails in WebAssembly builds, which bbolt does not support.
+func openBoltStore(path string) (Store, error) {
+	return nil, errors.New("the bolt store is not available in WebAssembly builds")
+}
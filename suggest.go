@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultSuggestions = 5
+	maxSuggestions     = 20
+)
+
+// Suggestion is an official suburb offered for what the user typed.
+type Suggestion struct {
+	Suburb    string   `json:"suburb"`
+	State     string   `json:"state"`
+	Postcodes []string `json:"postcodes"`
+	// Distance is the number of single-character edits between the input and the
+	// suburb, after the normalisation lenient validation uses.
+	Distance int `json:"distance"`
+}
+
+// SuggestResponse is the body of GET /suggest.
+type SuggestResponse struct {
+	Query       string       `json:"query"`
+	Suggestions []Suggestion `json:"suggestions"`
+}
+
+// isSubsequence reports whether every rune of short appears in long, in order, as
+// when vowels are left out: "melbrn" in "melbourne".
+func isSubsequence(short, long string) bool {
+	for _, r := range long {
+		if short == "" {
+			break
+		}
+		if first, size := utf8.DecodeRuneInString(short); first == r {
+			short = short[size:]
+		}
+	}
+	return short == ""
+}
+
+// Suggest returns up to limit official suburbs that input may be meant to name,
+// closest first, using the same spelling tolerance as lenient validation. Since
+// input is often a name still being typed, a suburb also qualifies when input is
+// within tolerance of its beginning, or when input's letters appear in it in order
+// starting with its first letter.
+func (d *Dataset) Suggest(input string, limit int) []Suggestion {
+	input = canonicalSuburb(input)
+	suggestions := []Suggestion{}
+	if input == "" {
+		return suggestions
+	}
+	first, _ := utf8.DecodeRuneInString(input)
+
+	byLocality := map[[2]string]int{} // suburb, state -> index in suggestions
+	for _, row := range d.Rows {
+		key := [2]string{strings.ToUpper(row.Suburb), strings.ToUpper(row.State)}
+		if i, ok := byLocality[key]; ok {
+			suggestions[i].Postcodes = append(suggestions[i].Postcodes, row.Postcode)
+			continue
+		}
+
+		official := canonicalSuburb(row.Suburb)
+		distance := editDistance(input, official)
+		runes := []rune(official)
+		prefix := string(runes[:min(len(runes), utf8.RuneCountInString(input))])
+		near := distance <= typoTolerance(len(official)) ||
+			editDistance(input, prefix) <= typoTolerance(len(input))
+		if !near && !(strings.HasPrefix(official, string(first)) && isSubsequence(input, official)) {
+			continue
+		}
+		byLocality[key] = len(suggestions)
+		suggestions = append(suggestions, Suggestion{Suburb: row.Suburb, State: row.State, Postcodes: []string{row.Postcode}, Distance: distance})
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].Distance != suggestions[j].Distance {
+			return suggestions[i].Distance < suggestions[j].Distance
+		}
+		return suggestions[i].Suburb < suggestions[j].Suburb
+	})
+	return suggestions[:min(len(suggestions), limit)]
+}
+
+// suggestHandler handles GET /suggest?q=melbrn&limit=5, offering official suburbs
+// for a name the dataset doesn't have, e.g. while the user is still typing it.
+func suggestHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := query.Get("q")
+	if strings.TrimSpace(q) == "" {
+		writeError(w, http.StatusBadRequest, codeKeywordMissing, "Missing 'q' parameter in the query string. Example: /suggest?q=melbrn")
+		return
+	}
+	if err := checkKeywordLength(q); err != nil {
+		writeError(w, http.StatusBadRequest, codeKeywordInvalid, err.Error())
+		return
+	}
+
+	limit := defaultSuggestions
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxSuggestions {
+			writeError(w, http.StatusBadRequest, codeParameterInvalid, fmt.Sprintf("Invalid 'limit' parameter: expected an integer between 1 and %d", maxSuggestions))
+			return
+		}
+		limit = n
+	}
+
+	dataset := requireDataset(w)
+	if dataset == nil {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SuggestResponse{Query: q, Suggestions: dataset.Suggest(q, limit)})
+}
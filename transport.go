@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// upstreamFetchTimeout bounds one upstream page request, from sending it to
+// reading the last byte of its body. Time spent waiting for the pacer or a
+// robots.txt Crawl-delay beforehand doesn't count.
+const upstreamFetchTimeout = 10 * time.Second
+
+// timeoutTransport gives each request it sends timeout to complete, body
+// included. It sits under the pacer and robots guard, so their waits, which
+// can be longer than a fetch may take, don't eat into it.
+type timeoutTransport struct {
+	timeout time.Duration
+	next    http.RoundTripper
+}
+
+func (t timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases a request's timeout once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// transportSettings tune the connection pool used for upstream requests. Go's
+// defaults keep only two idle connections per host, so a crawl spacing requests
+// closely keeps opening new TLS connections instead of reusing them.
+type transportSettings struct {
+	MaxIdleConnsPerHost int
+	MaxConnsPerHost     int // 0 means no limit
+	IdleConnTimeout     time.Duration
+	DisableHTTP2        bool
+}
+
+// newUpstreamTransport returns a copy of the default transport with settings applied.
+func newUpstreamTransport(settings transportSettings) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = settings.MaxIdleConnsPerHost
+	transport.MaxIdleConns = max(transport.MaxIdleConns, settings.MaxIdleConnsPerHost)
+	transport.MaxConnsPerHost = settings.MaxConnsPerHost
+	transport.IdleConnTimeout = settings.IdleConnTimeout
+	if settings.DisableHTTP2 {
+		// A non-nil, empty TLSNextProto is how net/http is told not to negotiate h2.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+// checkTransportSettings validates the -upstream-* connection flags.
+func checkTransportSettings(settings transportSettings) error {
+	if settings.MaxIdleConnsPerHost < 1 {
+		return errors.New("-upstream-max-idle-conns-per-host must be at least 1")
+	}
+	if settings.MaxConnsPerHost < 0 {
+		return errors.New("-upstream-max-conns-per-host must be 0 (no limit) or more")
+	}
+	if settings.IdleConnTimeout < 0 {
+		return errors.New("-upstream-idle-timeout must not be negative")
+	}
+	return nil
+}
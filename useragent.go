@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultUserAgent identifies the scraper honestly and says where to reach its
+// operators, rather than impersonating a browser.
+const defaultUserAgent = "australia-postcode-check/1.0 (+https://github.com/ryanzeng1990/australia-postcode-check)"
+
+// userAgents are the User-Agent strings sent upstream, used in turn; main sets them
+// from -user-agent or -user-agents-file.
+var userAgents = []string{defaultUserAgent}
+
+// userAgentTurn counts upstream requests to rotate through userAgents.
+var userAgentTurn atomic.Uint64
+
+// nextUserAgent returns the User-Agent for the next upstream request.
+func nextUserAgent() string {
+	n := userAgentTurn.Add(1) - 1
+	return userAgents[n%uint64(len(userAgents))]
+}
+
+// loadUserAgents reads User-Agent strings from a file, one per line. Blank lines and
+// lines starting with '#' are ignored.
+func loadUserAgents(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open user agents: %w", err)
+	}
+	defer f.Close()
+
+	var agents []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		agents = append(agents, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read user agents: %w", err)
+	}
+	if len(agents) == 0 {
+		return nil, errors.New("user agents file contains no user agents")
+	}
+	return agents, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ValidationResult is the response of GET /validate.
+type ValidationResult struct {
+	Valid bool   `json:"valid"`
+	Mode  string `json:"mode"`
+	// Match is the official row the input resolved to. In lenient mode its spelling
+	// may differ from the input, which lets callers correct what the user typed.
+	Match *PostcodeResult `json:"match,omitempty"`
+	// MatchedAlias is set when the input suburb resolved through an alias.
+	MatchedAlias string `json:"matched_alias,omitempty"`
+	// Deliverable says whether the matched locality has street delivery points,
+	// when the server has delivery point data.
+	Deliverable *bool  `json:"deliverable,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// Validation modes.
+const (
+	validationLenient = "lenient"
+	validationStrict  = "strict"
+)
+
+// suburbAbbreviations maps common abbreviations to the word they stand for. Both the
+// input and the official name are expanded, so "Mt Waverley" matches "MOUNT WAVERLEY"
+// and "Saint Kilda" matches "ST KILDA".
+var suburbAbbreviations = map[string]string{
+	"st":  "saint",
+	"mt":  "mount",
+	"nth": "north",
+	"sth": "south",
+	"pt":  "point",
+	"hts": "heights",
+	"pk":  "park",
+	"ck":  "creek",
+	"stn": "station",
+}
+
+// canonicalSuburb normalises a suburb name for lenient comparison: lower case,
+// punctuation dropped and abbreviations expanded.
+func canonicalSuburb(name string) string {
+	words := tokenize(name)
+	for i, word := range words {
+		if full, ok := suburbAbbreviations[word]; ok {
+			words[i] = full
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// typoTolerance is how many single-character edits lenient mode allows for a name
+// of the given length; short names must be spelled correctly.
+func typoTolerance(length int) int {
+	switch {
+	case length >= 9:
+		return 2
+	case length >= 5:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+// Validate checks a postcode/suburb/state combination. In strict mode the suburb and
+// state must match the official spelling and casing exactly; lenient mode ignores
+// case and punctuation, expands abbreviations and tolerates minor typos. An empty
+// state is not checked.
+func (d *Dataset) Validate(postcode, suburb, state string, strict bool) ValidationResult {
+	return d.validate(postcode, suburb, state, strict, currentAliases())
+}
+
+// validate is Validate with lenient mode resolving suburbs through aliases, which
+// may be nil.
+func (d *Dataset) validate(postcode, suburb, state string, strict bool, aliases *aliasTable) ValidationResult {
+	mode := validationLenient
+	if strict {
+		mode = validationStrict
+	}
+
+	candidates := d.RowsForPostcode(postcode)
+	if len(candidates) == 0 {
+		return ValidationResult{Mode: mode, Reason: fmt.Sprintf("Postcode %s does not exist", postcode)}
+	}
+
+	stateMatches := func(row PostcodeResult) bool {
+		if state == "" {
+			return true
+		}
+		if strict {
+			return row.State == state
+		}
+		return strings.EqualFold(row.State, strings.TrimSpace(state))
+	}
+
+	var best *PostcodeResult
+	bestDistance := -1
+	input := canonicalSuburb(suburb)
+	for i, row := range candidates {
+		if strict {
+			if row.Suburb == suburb && stateMatches(row) {
+				return ValidationResult{Valid: true, Mode: mode, Match: &candidates[i]}
+			}
+			continue
+		}
+
+		official := canonicalSuburb(row.Suburb)
+		distance := editDistance(input, official)
+		if distance <= typoTolerance(len(official)) && stateMatches(row) && (best == nil || distance < bestDistance) {
+			best = &candidates[i]
+			bestDistance = distance
+		}
+	}
+	if best != nil {
+		return ValidationResult{Valid: true, Mode: mode, Match: best}
+	}
+
+	// Lenient mode also accepts former names and alternative spellings.
+	if !strict {
+		if alias, ok := aliases.Resolve(suburb, state); ok {
+			for i, row := range candidates {
+				if strings.EqualFold(row.Suburb, alias.Suburb) && strings.EqualFold(row.State, alias.State) {
+					return ValidationResult{Valid: true, Mode: mode, Match: &candidates[i], MatchedAlias: alias.Name}
+				}
+			}
+		}
+	}
+
+	return ValidationResult{
+		Mode:   mode,
+		Reason: fmt.Sprintf("Suburb '%s' is not in postcode %s%s", suburb, postcode, stateSuffix(state)),
+	}
+}
+
+// stateSuffix renders an optional state for use in messages.
+func stateSuffix(state string) string {
+	if state == "" {
+		return ""
+	}
+	return " in " + state
+}
+
+// validateHandler handles GET /validate?postcode=3182&suburb=St+Kilda&state=VIC.
+// Add strict=true to require the official spelling and casing, and country=NZ to
+// check a New Zealand address, with its region as the state.
+func validateHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	postcode := strings.TrimSpace(query.Get("postcode"))
+	suburb := query.Get("suburb")
+	if postcode == "" || strings.TrimSpace(suburb) == "" {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, "Both 'postcode' and 'suburb' parameters are required")
+		return
+	}
+	strict, err := parseBoolParam(query, "strict")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, err.Error())
+		return
+	}
+
+	source, ok := countrySource(w, query)
+	if !ok {
+		return
+	}
+	if source != nil {
+		if query.Has("as_of") {
+			writeError(w, http.StatusBadRequest, codeParameterInvalid, "The 'as_of' parameter is only available for Australian addresses")
+			return
+		}
+		writeJSON(w, http.StatusOK, source.Validate(postcode, suburb, query.Get("state"), strict))
+		return
+	}
+
+	dataset := requireDataset(w)
+	if dataset == nil {
+		return
+	}
+	// as_of=2024-01-31 validates an address recorded then against the rows that
+	// were current at the time.
+	if dataset, ok = datasetAsOf(w, query, dataset); !ok {
+		return
+	}
+
+	result := dataset.validate(postcode, suburb, query.Get("state"), strict, requestAliases(r))
+	if result.Match != nil {
+		result.Deliverable = activeDeliveryPoints.deliverable(result.Match.Postcode, result.Match.Suburb, result.Match.State)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
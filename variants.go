@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// NameVariant is another official name of a locality, such as the Indigenous
+// name of a dual-named place ("ULURU" for "AYERS ROCK").
+type NameVariant struct {
+	Name     string `json:"name"`
+	Suburb   string `json:"suburb"` // the locality as the dataset names it
+	State    string `json:"state"`
+	Language string `json:"language,omitempty"`
+}
+
+// variantTable is the set of known name variants, indexed by variant name.
+type variantTable struct {
+	variants []NameVariant
+	index    *searchIndex
+	names    map[string][]string // suburbStateKey -> every name of the locality, dataset name first
+}
+
+// activeVariants is the loaded variants table, or nil when -variants is not set.
+var activeVariants *variantTable
+
+// loadVariants reads a variants CSV file with "variant", "suburb" and "state"
+// columns and an optional "language" column.
+func loadVariants(path string) (*variantTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open variants: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read variants header: %w", err)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"variant", "suburb", "state"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("variants header is missing a %s column", required)
+		}
+	}
+
+	cell := func(record []string, column string) string {
+		if i, ok := index[column]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var variants []NameVariant
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read variants: %w", err)
+		}
+		variant := NameVariant{
+			Name:     strings.ToUpper(cell(record, "variant")),
+			Suburb:   strings.ToUpper(cell(record, "suburb")),
+			State:    strings.ToUpper(cell(record, "state")),
+			Language: cell(record, "language"),
+		}
+		if variant.Name == "" || variant.Suburb == "" {
+			continue
+		}
+		variants = append(variants, variant)
+	}
+	return newVariantTable(variants), nil
+}
+
+// newVariantTable indexes variants by name so they can be searched like suburbs.
+func newVariantTable(variants []NameVariant) *variantTable {
+	table := &variantTable{variants: variants, names: map[string][]string{}}
+	rows := make([]PostcodeResult, len(variants))
+	for i, variant := range variants {
+		rows[i] = PostcodeResult{Suburb: variant.Name, State: variant.State}
+		key := suburbStateKey(variant.Suburb, variant.State)
+		if table.names[key] == nil {
+			table.names[key] = []string{variant.Suburb}
+		}
+		table.names[key] = append(table.names[key], variant.Name)
+	}
+	table.index = buildSearchIndex(rows)
+	return table
+}
+
+// apply adds the localities whose variant names match keyword to results, then
+// sets DualName on every result with variants, so a search on either name finds
+// the locality and returns all its names.
+func (t *variantTable) apply(d *Dataset, results []PostcodeResult, keyword string) []PostcodeResult {
+	if t == nil {
+		return results
+	}
+	seen := map[string]bool{}
+	for _, row := range results {
+		seen[rowKey(row)] = true
+	}
+	for _, i := range t.index.lookup(keyword) {
+		variant := t.variants[i]
+		for _, row := range d.RowsForSuburb(variant.Suburb, variant.State) {
+			if !seen[rowKey(row)] {
+				seen[rowKey(row)] = true
+				results = append(results, row)
+			}
+		}
+	}
+	for i, row := range results {
+		official := row.Suburb
+		if row.AliasOf != "" {
+			official = row.AliasOf
+		}
+		if names := t.names[suburbStateKey(official, row.State)]; names != nil {
+			results[i].DualName = strings.Join(names, " / ")
+		}
+	}
+	return results
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Integrity problems reported by the verify command.
+const (
+	issueDuplicateRow       = "duplicate_row"
+	issueInvalidPostcode    = "invalid_postcode"
+	issuePostcodeOutOfRange = "postcode_out_of_range"
+	issueMissingState       = "missing_state"
+	issueUnknownState       = "unknown_state"
+	issueOrphanedAlias      = "orphaned_alias"
+)
+
+// IntegrityIssue is one problem found in a dataset.
+type IntegrityIssue struct {
+	Type    string `json:"type"`
+	Row     int    `json:"row,omitempty"` // 1-based position among the dataset rows, when the issue is about a row
+	Message string `json:"message"`
+}
+
+// IntegrityReport is the output of the verify command.
+type IntegrityReport struct {
+	Dataset string           `json:"dataset"`
+	Rows    int              `json:"rows"`
+	Aliases int              `json:"aliases,omitempty"`
+	OK      bool             `json:"ok"`
+	Counts  map[string]int   `json:"counts"`
+	Issues  []IntegrityIssue `json:"issues"`
+}
+
+var fourDigits = regexp.MustCompile(`^\d{4}$`)
+
+// verifyDataset checks a dataset, and optionally an alias table, for structural
+// problems: duplicate rows, malformed postcodes, postcodes outside their state's
+// allocated ranges, rows without a known state, and aliases naming a suburb the
+// dataset doesn't have.
+func verifyDataset(dataset *Dataset, aliases *aliasTable) []IntegrityIssue {
+	issues := []IntegrityIssue{}
+	add := func(kind string, row int, format string, args ...any) {
+		issues = append(issues, IntegrityIssue{Type: kind, Row: row, Message: fmt.Sprintf(format, args...)})
+	}
+
+	seen := map[[3]string]int{}
+	for i, row := range dataset.Rows {
+		n := i + 1
+
+		key := [3]string{row.Postcode, strings.ToUpper(row.Suburb), strings.ToUpper(row.State)}
+		if first, ok := seen[key]; ok {
+			add(issueDuplicateRow, n, "%s %s %s duplicates row %d", row.Postcode, row.Suburb, row.State, first)
+		} else {
+			seen[key] = n
+		}
+
+		if !fourDigits.MatchString(row.Postcode) {
+			add(issueInvalidPostcode, n, "postcode %q is not four digits", row.Postcode)
+		}
+
+		switch ranges, known := stateRanges[strings.ToUpper(row.State)]; {
+		case row.State == "":
+			add(issueMissingState, n, "%s %s has no state", row.Postcode, row.Suburb)
+		case !known:
+			add(issueUnknownState, n, "%s %s has unknown state %q", row.Postcode, row.Suburb, row.State)
+		case fourDigits.MatchString(row.Postcode):
+			code := postcodeNumber(row.Postcode)
+			inRange := false
+			for _, r := range ranges {
+				inRange = inRange || (code >= r.From && code <= r.To)
+			}
+			if !inRange {
+				add(issuePostcodeOutOfRange, n, "postcode %s is outside the ranges allocated to %s", row.Postcode, row.State)
+			}
+		}
+	}
+
+	if aliases != nil {
+		for _, alias := range aliases.aliases {
+			if len(dataset.RowsForSuburb(alias.Suburb, alias.State)) == 0 {
+				add(issueOrphanedAlias, 0, "alias %q refers to %s %s, which is not in the dataset", alias.Name, alias.Suburb, alias.State)
+			}
+		}
+	}
+	return issues
+}
+
+// runVerify implements the "verify" subcommand. It prints a JSON report and fails
+// when any issue is found, so it can gate a dataset publish in CI.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	aliasesPath := fs.String("aliases", "", "Alias CSV to check for aliases of suburbs missing from the dataset")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: verify [-aliases FILE] DATASET")
+	}
+
+	dataset, err := loadDataset(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	report := IntegrityReport{Dataset: fs.Arg(0), Rows: len(dataset.Rows), Counts: map[string]int{}}
+
+	var aliases *aliasTable
+	if *aliasesPath != "" {
+		if aliases, err = loadAliases(*aliasesPath); err != nil {
+			return err
+		}
+		report.Aliases = len(aliases.aliases)
+	}
+
+	report.Issues = verifyDataset(dataset, aliases)
+	for _, issue := range report.Issues {
+		report.Counts[issue.Type]++
+	}
+	report.OK = len(report.Issues) == 0
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "    ")
+	if err := out.Encode(report); err != nil {
+		return err
+	}
+	if !report.OK {
+		return fmt.Errorf("found %d issues in %s", len(report.Issues), fs.Arg(0))
+	}
+	return nil
+}
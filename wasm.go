@@ -0,0 +1,140 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"syscall/js"
+)
+
+func init() {
+	wasmMain = serveWASM
+}
+
+// serveWASM exposes the offline lookup core to JavaScript as the global
+// postcodeCheck object, so web forms can check addresses with the same dataset
+// and rules as the server without calling it. web/postcode-check.js wraps it.
+// Every function returns a plain object; failures have an "error" property.
+func serveWASM() {
+	js.Global().Set("postcodeCheck", js.ValueOf(map[string]any{
+		"load":        js.FuncOf(wasmLoad),
+		"loadAliases": js.FuncOf(wasmLoadAliases),
+		"validate":    js.FuncOf(wasmValidate),
+		"search":      js.FuncOf(wasmSearch),
+		"suggest":     js.FuncOf(wasmSuggest),
+	}))
+	// Keep the Go runtime alive to answer calls.
+	select {}
+}
+
+// toJS converts v to a JavaScript value by way of its JSON encoding, so results
+// have the same shape as the API's responses.
+func toJS(v any) any {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return wasmError(err)
+	}
+	return js.Global().Get("JSON").Call("parse", string(raw))
+}
+
+func wasmError(err error) any {
+	return map[string]any{"error": err.Error()}
+}
+
+// jsArg returns argument i, or undefined when fewer were passed.
+func jsArg(args []js.Value, i int) js.Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return js.Undefined()
+}
+
+// jsBytes returns the contents of a string or Uint8Array argument.
+func jsBytes(v js.Value) []byte {
+	if v.Type() == js.TypeString {
+		return []byte(v.String())
+	}
+	data := make([]byte, v.Get("length").Int())
+	js.CopyBytesToGo(data, v)
+	return data
+}
+
+// wasmLoad handles postcodeCheck.load(data), where data is a dataset CSV or a
+// packed dataset, as a string or Uint8Array.
+func wasmLoad(_ js.Value, args []js.Value) any {
+	data := jsBytes(jsArg(args, 0))
+	var dataset *Dataset
+	var err error
+	if isPacked(data) {
+		dataset, err = readPacked(data)
+	} else {
+		dataset, err = readDataset(bytes.NewReader(data))
+	}
+	if err != nil {
+		return wasmError(err)
+	}
+	activeDataset.Store(dataset)
+	return map[string]any{"rows": len(dataset.Rows)}
+}
+
+// wasmLoadAliases handles postcodeCheck.loadAliases(csv).
+func wasmLoadAliases(_ js.Value, args []js.Value) any {
+	aliases, err := readAliases(bytes.NewReader(jsBytes(jsArg(args, 0))))
+	if err != nil {
+		return wasmError(err)
+	}
+	activeAliases.Store(aliases)
+	return map[string]any{"aliases": len(aliases.aliases)}
+}
+
+// loadedDataset returns the dataset passed to load, or an error result.
+func loadedDataset() (*Dataset, any) {
+	dataset := currentDataset()
+	if dataset == nil {
+		return nil, map[string]any{"error": "no dataset is loaded; call load first"}
+	}
+	return dataset, nil
+}
+
+// wasmValidate handles postcodeCheck.validate(postcode, suburb, state, strict),
+// returning the same result as GET /validate.
+func wasmValidate(_ js.Value, args []js.Value) any {
+	dataset, failed := loadedDataset()
+	if dataset == nil {
+		return failed
+	}
+	var state string
+	if jsArg(args, 2).Type() == js.TypeString {
+		state = jsArg(args, 2).String()
+	}
+	return toJS(dataset.Validate(strings.TrimSpace(jsArg(args, 0).String()), jsArg(args, 1).String(), state, jsArg(args, 3).Truthy()))
+}
+
+// wasmSearch handles postcodeCheck.search(keyword), returning the results a plain
+// GET /search would.
+func wasmSearch(_ js.Value, args []js.Value) any {
+	dataset, failed := loadedDataset()
+	if dataset == nil {
+		return failed
+	}
+	parsed := parseSearchQuery(jsArg(args, 0).String())
+	results := append(dataset.Search(parsed.Suburb), currentAliases().Search(dataset, parsed.Suburb)...)
+	rankResults(results, parsed.Suburb, false)
+	return toJS(parsed.filter(results))
+}
+
+// wasmSuggest handles postcodeCheck.suggest(input, limit), returning the
+// suggestions GET /suggest would.
+func wasmSuggest(_ js.Value, args []js.Value) any {
+	dataset, failed := loadedDataset()
+	if dataset == nil {
+		return failed
+	}
+	limit := defaultSuggestions
+	if jsArg(args, 1).Type() == js.TypeNumber {
+		limit = min(max(jsArg(args, 1).Int(), 1), maxSuggestions)
+	}
+	return toJS(dataset.Suggest(jsArg(args, 0).String(), limit))
+}
@@ -0,0 +1,51 @@
+// Client-side postcode validation with the server's own lookup core, compiled to
+// WebAssembly. Load Go's wasm_exec.js before this module; see the README section
+// "WebAssembly Validation" for how to build postcode-check.wasm.
+//
+//   import { loadPostcodeCheck } from "./postcode-check.js";
+//   const check = await loadPostcodeCheck({ datasetURL: "/postcodes.pcd" });
+//   check.validate("3182", "St Kilda", "VIC"); // { valid: true, mode: "lenient", match: {...} }
+
+// unwrap returns a result from the core, throwing its error if it failed.
+function unwrap(result) {
+  if (result && result.error) {
+    throw new Error(result.error);
+  }
+  return result;
+}
+
+async function fetchBytes(url) {
+  const response = await fetch(url);
+  if (!response.ok) {
+    throw new Error(`fetch ${url}: ${response.status}`);
+  }
+  return new Uint8Array(await response.arrayBuffer());
+}
+
+// loadPostcodeCheck starts the WebAssembly core and loads a dataset (CSV or
+// packed) and, optionally, an alias CSV into it.
+export async function loadPostcodeCheck({ wasmURL = "postcode-check.wasm", datasetURL, aliasesURL } = {}) {
+  if (!datasetURL) {
+    throw new Error("loadPostcodeCheck: datasetURL is required");
+  }
+  const go = new Go();
+  const { instance } = await WebAssembly.instantiateStreaming(fetch(wasmURL), go.importObject);
+  // run resolves only when the program exits; the core is ready once it returns.
+  go.run(instance);
+  const core = globalThis.postcodeCheck;
+
+  unwrap(core.load(await fetchBytes(datasetURL)));
+  if (aliasesURL) {
+    unwrap(core.loadAliases(await fetchBytes(aliasesURL)));
+  }
+
+  return {
+    // validate checks a postcode/suburb/state combination like GET /validate.
+    validate: (postcode, suburb, state = "", strict = false) =>
+      unwrap(core.validate(postcode, suburb, state, strict)),
+    // search finds localities like GET /search?keyword=...
+    search: (keyword) => unwrap(core.search(keyword)),
+    // suggest offers official suburbs for a misspelt or partial name like GET /suggest.
+    suggest: (input, limit = 5) => unwrap(core.suggest(input, limit)),
+  };
+}
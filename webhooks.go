@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookBackoff is the delay before the first retry; it doubles per attempt.
+	webhookBackoff = 2 * time.Second
+	// webhookMaxBackoff caps the delay between retries.
+	webhookMaxBackoff = 5 * time.Minute
+)
+
+// DatasetEvent is the payload delivered to webhooks when the active dataset changes.
+type DatasetEvent struct {
+	Event        string    `json:"event"`  // always "dataset.updated"
+	Source       string    `json:"source"` // "crawl", "remote" or "admin"
+	Rows         int       `json:"rows"`
+	PreviousRows int       `json:"previous_rows"`
+	OccurredAt   time.Time `json:"occurred_at"`
+}
+
+// webhookDispatcher delivers dataset events to the configured URLs. Every delivery
+// carries a unique ID and an HMAC-SHA256 signature, is retried with exponential
+// backoff, and is written to the dead-letter log once its attempts run out.
+type webhookDispatcher struct {
+	urls        []string
+	secret      []byte
+	maxAttempts int
+	deadLetter  string // path of the JSON-lines dead-letter log; empty only logs
+	client      *http.Client
+
+	mu sync.Mutex // serialises dead-letter writes
+}
+
+// webhooks is the configured dispatcher, or nil when no webhook URLs are set.
+var webhooks *webhookDispatcher
+
+// newWebhookDispatcher configures delivery to a comma-separated list of URLs.
+func newWebhookDispatcher(urlSpec, secret string, maxAttempts int, deadLetter string) *webhookDispatcher {
+	var urls []string
+	for _, u := range strings.Split(urlSpec, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	return &webhookDispatcher{
+		urls:        urls,
+		secret:      []byte(secret),
+		maxAttempts: max(maxAttempts, 1),
+		deadLetter:  deadLetter,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// replaceDataset swaps in a new active dataset after a refresh, crawl or admin
+// reload, records it in the audit log and notifies webhooks of the change.
+func replaceDataset(dataset *Dataset, source, actor string) {
+	previous := swapDataset(dataset, source, actor)
+	if webhooks == nil {
+		return
+	}
+	event := DatasetEvent{
+		Event:      "dataset.updated",
+		Source:     source,
+		Rows:       len(dataset.Rows),
+		OccurredAt: time.Now().UTC(),
+	}
+	if previous != nil {
+		event.PreviousRows = len(previous.Rows)
+	}
+	webhooks.notify(event)
+}
+
+// swapDataset is replaceDataset without the webhooks, for replicas following a
+// change another one has already announced. It returns the dataset replaced,
+// which is retired: a packed one is unmapped after a grace period.
+func swapDataset(dataset *Dataset, source, actor string) *Dataset {
+	previous := activeDataset.Swap(dataset)
+	retireDataset(previous)
+	trackDataset(dataset)
+	markDatasetVerified(dataset)
+	auditTrail.record(actor, "dataset.reload", map[string]any{"source": source, "rows": len(dataset.Rows)})
+	return previous
+}
+
+// notify delivers event to every URL in the background.
+func (d *webhookDispatcher) notify(event DatasetEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Warning: failed to encode webhook event: %v", err)
+		return
+	}
+	for _, url := range d.urls {
+		go d.deliver(context.Background(), url, newDeliveryID(), payload)
+	}
+}
+
+// newDeliveryID returns a random identifier shared by every attempt of a delivery,
+// so receivers can discard duplicates caused by retries.
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// webhookSignature signs "<timestamp>.<payload>", so a captured delivery cannot be
+// replayed later with a fresh timestamp.
+func webhookSignature(secret []byte, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver posts payload to url, retrying until it is accepted or the attempts run
+// out. Any 2xx response counts as delivered.
+func (d *webhookDispatcher) deliver(ctx context.Context, url, id string, payload []byte) {
+	backoff := webhookBackoff
+	var lastErr error
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		if lastErr = d.post(ctx, url, id, attempt, payload); lastErr == nil {
+			return
+		}
+		if attempt == d.maxAttempts {
+			break
+		}
+		log.Printf("Warning: webhook delivery %s to %s failed (attempt %d of %d), retrying in %s: %v", id, url, attempt, d.maxAttempts, backoff, lastErr)
+		select {
+		case <-ctx.Done():
+			lastErr = ctx.Err()
+			d.recordDeadLetter(url, id, attempt, payload, lastErr)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, webhookMaxBackoff)
+	}
+	d.recordDeadLetter(url, id, d.maxAttempts, payload, lastErr)
+}
+
+func (d *webhookDispatcher) post(ctx context.Context, url, id string, attempt int, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", id)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Attempt", strconv.Itoa(attempt))
+	if len(d.secret) > 0 {
+		req.Header.Set("X-Webhook-Signature", webhookSignature(d.secret, timestamp, payload))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// deadLetter is one undeliverable webhook in the dead-letter log.
+type deadLetter struct {
+	DeliveryID string          `json:"delivery_id"`
+	URL        string          `json:"url"`
+	Attempts   int             `json:"attempts"`
+	LastError  string          `json:"last_error"`
+	FailedAt   time.Time       `json:"failed_at"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// recordDeadLetter appends an undeliverable event to the dead-letter log so it can
+// be inspected and re-sent by hand.
+func (d *webhookDispatcher) recordDeadLetter(url, id string, attempts int, payload []byte, cause error) {
+	log.Printf("Warning: webhook delivery %s to %s abandoned after %d attempts: %v", id, url, attempts, cause)
+	if d.deadLetter == "" {
+		return
+	}
+
+	line, err := json.Marshal(deadLetter{
+		DeliveryID: id,
+		URL:        url,
+		Attempts:   attempts,
+		LastError:  cause.Error(),
+		FailedAt:   time.Now().UTC(),
+		Payload:    payload,
+	})
+	if err != nil {
+		log.Printf("Warning: failed to encode dead letter %s: %v", id, err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := os.OpenFile(d.deadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Printf("Warning: failed to write dead letter %s: %v", id, err)
+	}
+}
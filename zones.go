@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// zonesBucket is the store bucket holding the shared shipping zones, keyed by
+// zone ID. Each API key's private zones live in a bucket of their own.
+const zonesBucket = "zones"
+
+// ZoneRange is an inclusive range of postcodes, e.g. 4000 to 4179.
+type ZoneRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Zone is a named set of postcodes and postcode ranges, such as a courier's
+// delivery zone.
+type Zone struct {
+	ID        string      `json:"id"`
+	Name      string      `json:"name"`
+	Postcodes []string    `json:"postcodes"`
+	Ranges    []ZoneRange `json:"ranges"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// zoneIDPattern restricts client-chosen zone IDs to URL-safe names.
+var zoneIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// validate checks a zone's definition, normalising its postcodes and ranges.
+func (z *Zone) validate() error {
+	if z.Postcodes == nil {
+		z.Postcodes = []string{}
+	}
+	if z.Ranges == nil {
+		z.Ranges = []ZoneRange{}
+	}
+	if len(z.Postcodes) == 0 && len(z.Ranges) == 0 {
+		return fmt.Errorf("A zone needs at least one postcode or range")
+	}
+	for i, postcode := range z.Postcodes {
+		z.Postcodes[i] = strings.TrimSpace(postcode)
+		if !fourDigits.MatchString(z.Postcodes[i]) {
+			return fmt.Errorf("Invalid postcode %q: expected four digits", postcode)
+		}
+	}
+	for i, r := range z.Ranges {
+		r.From, r.To = strings.TrimSpace(r.From), strings.TrimSpace(r.To)
+		if !fourDigits.MatchString(r.From) || !fourDigits.MatchString(r.To) {
+			return fmt.Errorf("Invalid range %q-%q: expected four-digit postcodes", r.From, r.To)
+		}
+		if r.From > r.To {
+			return fmt.Errorf("Invalid range %s-%s: 'from' must not be greater than 'to'", r.From, r.To)
+		}
+		z.Ranges[i] = r
+	}
+	return nil
+}
+
+// contains reports whether postcode is one of the zone's postcodes or lies in one
+// of its ranges. Four-digit postcodes compare correctly as strings.
+func (z Zone) contains(postcode string) bool {
+	for _, p := range z.Postcodes {
+		if p == postcode {
+			return true
+		}
+	}
+	for _, r := range z.Ranges {
+		if postcode >= r.From && postcode <= r.To {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneRegistry persists zones in one store bucket, so they survive restarts with
+// the bolt backend.
+type zoneRegistry struct {
+	store  Store
+	bucket string
+}
+
+// shippingZones is the shared registry; main points it at the store.
+var shippingZones *zoneRegistry
+
+// requestZones returns the registry for r's namespace: the API key's or token
+// client's own zones, or the shared ones for requests made without either. Zone IDs only need to be unique
+// within a namespace, so teams can't read or overwrite each other's zones.
+func requestZones(r *http.Request) *zoneRegistry {
+	namespace := requestNamespace(r)
+	if namespace == "" {
+		return shippingZones
+	}
+	return &zoneRegistry{store: shippingZones.store, bucket: zonesBucket + ":" + namespace}
+}
+
+func (z *zoneRegistry) get(id string) (Zone, bool, error) {
+	raw, ok, err := z.store.Get(z.bucket, id)
+	if err != nil || !ok {
+		return Zone{}, false, err
+	}
+	var zone Zone
+	if err := json.Unmarshal(raw, &zone); err != nil {
+		return Zone{}, false, fmt.Errorf("corrupt zone %s: %w", id, err)
+	}
+	return zone, true, nil
+}
+
+func (z *zoneRegistry) put(zone Zone) error {
+	raw, err := json.Marshal(zone)
+	if err != nil {
+		return err
+	}
+	return z.store.Put(z.bucket, zone.ID, raw)
+}
+
+func (z *zoneRegistry) list() ([]Zone, error) {
+	zones := []Zone{}
+	err := z.store.ForEach(z.bucket, func(id string, raw []byte) error {
+		var zone Zone
+		if err := json.Unmarshal(raw, &zone); err != nil {
+			return fmt.Errorf("corrupt zone %s: %w", id, err)
+		}
+		zones = append(zones, zone)
+		return nil
+	})
+	return zones, err
+}
+
+// newZoneID returns a random zone ID for zones created without one.
+func newZoneID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// createZoneHandler handles POST /zones, storing a zone from a body like
+// {"name": "Brisbane metro", "postcodes": ["4300"], "ranges": [{"from": "4000", "to": "4179"}]}.
+// An "id" may be given; otherwise one is generated.
+func createZoneHandler(w http.ResponseWriter, r *http.Request) {
+	var zone Zone
+	if !decodeJSONBody(w, r, &zone) {
+		return
+	}
+	zone.Name = strings.TrimSpace(zone.Name)
+	if zone.Name == "" {
+		writeError(w, http.StatusBadRequest, codeBodyInvalid, "A zone needs a 'name'")
+		return
+	}
+	if err := zone.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, codeBodyInvalid, err.Error())
+		return
+	}
+
+	if zone.ID == "" {
+		zone.ID = newZoneID()
+	} else if !zoneIDPattern.MatchString(zone.ID) {
+		writeError(w, http.StatusBadRequest, codeBodyInvalid, "Invalid 'id': use up to 64 letters, digits, '-' or '_'")
+		return
+	}
+	zones := requestZones(r)
+	if _, exists, err := zones.get(zone.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+		return
+	} else if exists {
+		writeError(w, http.StatusConflict, codeZoneExists, fmt.Sprintf("Zone '%s' already exists", zone.ID))
+		return
+	}
+
+	zone.CreatedAt = time.Now().UTC()
+	if err := zones.put(zone); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to save the zone: %s", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, zone)
+}
+
+// listZonesHandler handles GET /zones.
+func listZonesHandler(w http.ResponseWriter, r *http.Request) {
+	zones, err := requestZones(r).list()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, zones)
+}
+
+// zoneFromPath loads the zone named by the {id} path value, writing a 404 and
+// returning false when there is none.
+func zoneFromPath(w http.ResponseWriter, r *http.Request) (Zone, bool) {
+	id := r.PathValue("id")
+	zone, ok, err := requestZones(r).get(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+		return Zone{}, false
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Zone '%s' does not exist", id))
+		return Zone{}, false
+	}
+	return zone, true
+}
+
+// getZoneHandler handles GET /zones/{id}.
+func getZoneHandler(w http.ResponseWriter, r *http.Request) {
+	if zone, ok := zoneFromPath(w, r); ok {
+		writeJSON(w, http.StatusOK, zone)
+	}
+}
+
+// deleteZoneHandler handles DELETE /zones/{id}.
+func deleteZoneHandler(w http.ResponseWriter, r *http.Request) {
+	zones := requestZones(r)
+	zone, ok := zoneFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := zones.store.Delete(zones.bucket, zone.ID); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, fmt.Sprintf("Failed to delete the zone: %s", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// zoneContainsHandler handles GET /zones/{id}/contains?postcode=4000, answering
+// whether a postcode is in the zone.
+func zoneContainsHandler(w http.ResponseWriter, r *http.Request) {
+	postcode := strings.TrimSpace(r.URL.Query().Get("postcode"))
+	if !fourDigits.MatchString(postcode) {
+		writeError(w, http.StatusBadRequest, codeParameterInvalid, "Invalid 'postcode' parameter: expected four digits")
+		return
+	}
+	zone, ok := zoneFromPath(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"zone": zone.ID, "postcode": postcode, "contains": zone.contains(postcode)})
+}
+
+// containsRequest is the body of POST /contains. The postcodes are checked against
+// either a stored zone or an inline definition of postcodes and ranges.
+type containsRequest struct {
+	Postcodes []string    `json:"postcodes"`
+	Zone      string      `json:"zone"`
+	Ranges    []ZoneRange `json:"ranges"`
+	Members   []string    `json:"members"` // individual postcodes of an inline definition
+}
+
+// Membership is one postcode's result in a POST /contains response.
+type Membership struct {
+	Postcode string `json:"postcode"`
+	Contains bool   `json:"contains"`
+}
+
+// bulkContainsHandler handles POST /contains, checking many postcodes against one
+// zone in a single call, in the order given. Postcodes that aren't four digits are
+// reported as not contained rather than failing the batch.
+func bulkContainsHandler(w http.ResponseWriter, r *http.Request) {
+	var req containsRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if err := checkBatchSize(len(req.Postcodes)); err != nil {
+		writeError(w, http.StatusBadRequest, codeBatchTooLarge, err.Error())
+		return
+	}
+
+	var zone Zone
+	switch {
+	case req.Zone != "" && (len(req.Ranges) > 0 || len(req.Members) > 0):
+		writeError(w, http.StatusBadRequest, codeBodyInvalid, "Give either a stored 'zone' or inline 'ranges'/'members', not both")
+		return
+	case req.Zone != "":
+		stored, ok, err := requestZones(r).get(req.Zone)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("Zone '%s' does not exist", req.Zone))
+			return
+		}
+		zone = stored
+	default:
+		zone = Zone{Postcodes: req.Members, Ranges: req.Ranges}
+		if err := zone.validate(); err != nil {
+			writeError(w, http.StatusBadRequest, codeBodyInvalid, err.Error())
+			return
+		}
+	}
+
+	results := make([]Membership, len(req.Postcodes))
+	for i, postcode := range req.Postcodes {
+		postcode = strings.TrimSpace(postcode)
+		results[i] = Membership{Postcode: postcode, Contains: fourDigits.MatchString(postcode) && zone.contains(postcode)}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"results": results})
+}